@@ -0,0 +1,235 @@
+/*
+Package circuitbreaker implements a circuit breaker that makes the requests
+to an unavailable target fail fast instead of waiting for timeouts.
+
+The breaker starts in the Closed state and lets every request through.
+After FailureThreshold consecutive failures it opens and rejects every
+request for OpenTimeout. After that it becomes half-open: it lets through a
+limited number of trial requests. It closes if the trial requests succeed and
+opens again if any of them fails.
+
+The rejected requests fail with a NanoError that has the
+config.ErrorCodeCircuitOpen code and a util.RetryHint.
+
+A Breaker implements the http.CircuitBreaker interface of the http transport:
+
+	opts := &http.ClientOptions{
+		NewCircuitBreaker: func(service string) http.CircuitBreaker {
+			return circuitbreaker.New(service, circuitbreaker.Options{})
+		},
+	}
+*/
+package circuitbreaker
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pasztorpisti/nano/addons/transport/http/config"
+	"github.com/pasztorpisti/nano/addons/util"
+)
+
+type State int
+
+const (
+	Closed State = iota
+	Open
+	HalfOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case Closed:
+		return "closed"
+	case Open:
+		return "open"
+	case HalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+// Outcome is the result of a request let through by the breaker.
+type Outcome = config.Outcome
+
+const (
+	Success = config.OutcomeSuccess
+	Failure = config.OutcomeFailure
+	// Ignored means that the request doesn't tell anything about the health
+	// of the target, e.g.: the caller has canceled it.
+	Ignored = config.OutcomeIgnored
+)
+
+// Options tunes when a Breaker opens and closes.
+type Options struct {
+	// FailureThreshold is the number of consecutive failures that opens the
+	// breaker. Defaults to 5.
+	FailureThreshold int
+	// OpenTimeout is the time the breaker stays open before letting through
+	// trial requests. Defaults to 10 seconds.
+	OpenTimeout time.Duration
+	// HalfOpenMaxRequests is the maximum number of concurrent trial requests
+	// in the half-open state. Defaults to 1.
+	HalfOpenMaxRequests int
+	// SuccessThreshold is the number of successful trial requests that
+	// closes the breaker. Defaults to 1.
+	SuccessThreshold int
+
+	// OnStateChange is called when the state of the breaker changes.
+	// Optional. It is called while holding the lock of the breaker so it
+	// shouldn't call the methods of the breaker.
+	OnStateChange func(name string, from, to State)
+
+	// Clock defaults to time.Now.
+	Clock func() time.Time
+}
+
+func (o Options) withDefaults() Options {
+	if o.FailureThreshold <= 0 {
+		o.FailureThreshold = 5
+	}
+	if o.OpenTimeout <= 0 {
+		o.OpenTimeout = 10 * time.Second
+	}
+	if o.HalfOpenMaxRequests <= 0 {
+		o.HalfOpenMaxRequests = 1
+	}
+	if o.SuccessThreshold <= 0 {
+		o.SuccessThreshold = 1
+	}
+	if o.Clock == nil {
+		o.Clock = time.Now
+	}
+	return o
+}
+
+// Breaker is a circuit breaker of a single target.
+type Breaker struct {
+	name string
+	opts Options
+
+	mu    sync.Mutex
+	state State
+	// generation is incremented on every state change. The outcomes of the
+	// requests started in a previous generation are ignored.
+	generation uint64
+	failures   int
+	successes  int
+	trials     int
+	openedAt   time.Time
+}
+
+// New creates a new Breaker in Closed state. The name identifies the target
+// in error messages and in OnStateChange calls.
+func New(name string, opts Options) *Breaker {
+	return &Breaker{
+		name: name,
+		opts: opts.withDefaults(),
+	}
+}
+
+// State returns the current state of the breaker.
+func (b *Breaker) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.checkOpenTimeout(b.opts.Clock())
+	return b.state
+}
+
+// Closed returns true if the breaker is in the Closed state.
+func (b *Breaker) Closed() bool {
+	return b.State() == Closed
+}
+
+// Allow returns nil and a done func if the request can be sent. The done
+// func has to be called exactly once with the outcome of the request.
+// Allow returns an error with the config.ErrorCodeCircuitOpen code if the
+// request is rejected.
+func (b *Breaker) Allow() (done func(Outcome), err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := b.opts.Clock()
+	b.checkOpenTimeout(now)
+
+	switch b.state {
+	case Open:
+		return nil, b.openError(b.openedAt.Add(b.opts.OpenTimeout).Sub(now))
+	case HalfOpen:
+		if b.trials >= b.opts.HalfOpenMaxRequests {
+			return nil, b.openError(0)
+		}
+		b.trials++
+	}
+
+	generation := b.generation
+	var once sync.Once
+	return func(o Outcome) {
+		once.Do(func() {
+			b.done(generation, o)
+		})
+	}, nil
+}
+
+func (b *Breaker) done(generation uint64, o Outcome) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if generation != b.generation {
+		return
+	}
+
+	switch b.state {
+	case Closed:
+		switch o {
+		case Success:
+			b.failures = 0
+		case Failure:
+			b.failures++
+			if b.failures >= b.opts.FailureThreshold {
+				b.setState(Open)
+			}
+		}
+	case HalfOpen:
+		b.trials--
+		switch o {
+		case Success:
+			b.successes++
+			if b.successes >= b.opts.SuccessThreshold {
+				b.setState(Closed)
+			}
+		case Failure:
+			b.setState(Open)
+		}
+	}
+}
+
+// checkOpenTimeout switches from Open to HalfOpen if the OpenTimeout has
+// expired. The caller has to hold b.mu.
+func (b *Breaker) checkOpenTimeout(now time.Time) {
+	if b.state == Open && now.Sub(b.openedAt) >= b.opts.OpenTimeout {
+		b.setState(HalfOpen)
+	}
+}
+
+// setState has to be called while holding b.mu.
+func (b *Breaker) setState(s State) {
+	from := b.state
+	b.state = s
+	b.generation++
+	b.failures, b.successes, b.trials = 0, 0, 0
+	if s == Open {
+		b.openedAt = b.opts.Clock()
+	}
+	if b.opts.OnStateChange != nil {
+		b.opts.OnStateChange(b.name, from, s)
+	}
+}
+
+func (b *Breaker) openError(retryAfter time.Duration) error {
+	err := util.ErrCode(nil, config.ErrorCodeCircuitOpen,
+		"circuit breaker of "+b.name+" is open")
+	if retryAfter <= 0 {
+		return err
+	}
+	return util.WithRetryAfter(err, retryAfter)
+}
@@ -0,0 +1,135 @@
+package circuitbreaker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pasztorpisti/nano/addons/internal/fakeclock"
+	"github.com/pasztorpisti/nano/addons/transport/http/config"
+	"github.com/pasztorpisti/nano/addons/util"
+)
+
+func request(t *testing.T, b *Breaker, o Outcome) {
+	done, err := b.Allow()
+	if err != nil {
+		t.Errorf("request rejected in state %v :: %v", b.State(), err)
+		t.FailNow()
+	}
+	done(o)
+}
+
+func TestBreaker(t *testing.T) {
+	clock := fakeclock.New()
+	var transitions []string
+	b := New("target", Options{
+		Clock:            clock.Now,
+		FailureThreshold: 2,
+		OpenTimeout:      time.Second,
+		OnStateChange: func(name string, from, to State) {
+			transitions = append(transitions, name+":"+from.String()+"->"+to.String())
+		},
+	})
+
+	// A success resets the consecutive failure counter.
+	request(t, b, Failure)
+	request(t, b, Success)
+	request(t, b, Failure)
+	request(t, b, Ignored)
+	if s := b.State(); s != Closed {
+		t.Errorf("state == %v, want %v", s, Closed)
+	}
+	request(t, b, Failure)
+	if s := b.State(); s != Open {
+		t.Errorf("state == %v, want %v", s, Open)
+	}
+
+	clock.Advance(300 * time.Millisecond)
+	_, err := b.Allow()
+	if code := util.GetErrCode(err); code != config.ErrorCodeCircuitOpen {
+		t.Errorf("error code == %q, want %q", code, config.ErrorCodeCircuitOpen)
+	}
+	if d, ok := util.GetRetryAfter(err); !ok || d != 700*time.Millisecond {
+		t.Errorf("retry hint == %v %v, want %v", d, ok, 700*time.Millisecond)
+	}
+
+	clock.Advance(700 * time.Millisecond)
+	if s := b.State(); s != HalfOpen {
+		t.Errorf("state == %v, want %v", s, HalfOpen)
+	}
+	done, err := b.Allow()
+	if err != nil {
+		t.Errorf("trial request rejected :: %v", err)
+		t.FailNow()
+	}
+	// Only one trial request is allowed at a time by default.
+	if _, err := b.Allow(); err == nil {
+		t.Error("second trial request allowed")
+	}
+	done(Failure)
+	if s := b.State(); s != Open {
+		t.Errorf("state == %v, want %v", s, Open)
+	}
+
+	clock.Advance(time.Second)
+	request(t, b, Success)
+	if s := b.State(); s != Closed {
+		t.Errorf("state == %v, want %v", s, Closed)
+	}
+
+	want := []string{
+		"target:closed->open",
+		"target:open->half-open",
+		"target:half-open->open",
+		"target:open->half-open",
+		"target:half-open->closed",
+	}
+	if len(transitions) != len(want) {
+		t.Errorf("transitions == %v, want %v", transitions, want)
+		t.FailNow()
+	}
+	for i := range want {
+		if transitions[i] != want[i] {
+			t.Errorf("transitions == %v, want %v", transitions, want)
+			break
+		}
+	}
+}
+
+func TestBreaker_Ignores_Previous_Generation(t *testing.T) {
+	b := New("target", Options{FailureThreshold: 1})
+	slowDone, err := b.Allow()
+	if err != nil {
+		t.Errorf("unexpected error :: %v", err)
+		t.FailNow()
+	}
+	request(t, b, Failure)
+	if s := b.State(); s != Open {
+		t.Errorf("state == %v, want %v", s, Open)
+	}
+	// The outcome of a request started before opening the breaker doesn't
+	// close it.
+	slowDone(Success)
+	if s := b.State(); s != Open {
+		t.Errorf("state == %v, want %v", s, Open)
+	}
+}
+
+func TestBreaker_HalfOpen_Ignored_Frees_Trial(t *testing.T) {
+	clock := fakeclock.New()
+	b := New("target", Options{
+		FailureThreshold: 1,
+		OpenTimeout:      time.Second,
+		Clock:            clock.Now,
+	})
+	request(t, b, Failure)
+	clock.Advance(time.Second)
+
+	request(t, b, Ignored)
+	if s := b.State(); s != HalfOpen {
+		t.Errorf("state == %v, want %v", s, HalfOpen)
+	}
+	request(t, b, Success)
+	if s := b.State(); s != Closed {
+		t.Errorf("state == %v, want %v", s, Closed)
+	}
+}
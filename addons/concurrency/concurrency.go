@@ -0,0 +1,372 @@
+/*
+Package concurrency implements adaptive concurrency limiting and load
+shedding for services.
+
+A Limiter allows a limited number of concurrent requests per service. The
+limit is adjusted with AIMD (additive increase, multiplicative decrease): it
+grows slowly while the latency of the requests stays below a threshold and it
+is cut when the latency goes above it, e.g.: because a dependency of the
+service became slow. The threshold is either configured or derived from the
+lowest recently observed latency (the baseline) of the service.
+
+The requests above the limit wait in a short priority queue and are rejected
+with a NanoError that has the config.ErrorCodeOverloaded code if they can't
+get a slot quickly. The priority of a request is read from Ctx.Metadata.
+
+A Limiter is applied as a nano.Middleware, either to services of a ServiceSet
+or only to the endpoints of the http listener through its
+ListenerOptions.Middlewares.
+*/
+package concurrency
+
+import (
+	"container/heap"
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pasztorpisti/nano"
+	"github.com/pasztorpisti/nano/addons/transport/http/config"
+	"github.com/pasztorpisti/nano/addons/util"
+)
+
+// PriorityMetadataKey is the Ctx.Metadata key of the priority of a request.
+// The value is an integer, requests with higher values are more important.
+// Requests without a valid priority have priority 0.
+var PriorityMetadataKey = "priority"
+
+// Options tunes the AIMD limit, the latency threshold and the queue of a
+// Limiter.
+type Options struct {
+	// InitialLimit is the concurrency limit before the first adjustment.
+	// Defaults to 20.
+	InitialLimit int
+	// MinLimit defaults to 1.
+	MinLimit int
+	// MaxLimit defaults to 1000.
+	MaxLimit int
+	// Backoff is the multiplier of the limit when a request is too slow.
+	// Defaults to 0.9.
+	Backoff float64
+
+	// LatencyThreshold is the latency above which a request is considered
+	// too slow. If zero then the threshold is Tolerance times the baseline
+	// latency.
+	LatencyThreshold time.Duration
+	// Tolerance defaults to 2.
+	Tolerance float64
+	// BaselineWindow is the time after which the baseline latency is
+	// forgotten. Defaults to one minute.
+	BaselineWindow time.Duration
+
+	// QueueSize is the maximum number of requests waiting for a slot.
+	// Zero means that the requests above the limit are rejected immediately.
+	QueueSize int
+	// QueueTimeout is the maximum time a request waits in the queue.
+	// Defaults to 100ms.
+	QueueTimeout time.Duration
+
+	// Clock defaults to time.Now.
+	Clock func() time.Time
+}
+
+func (o Options) withDefaults() Options {
+	if o.InitialLimit <= 0 {
+		o.InitialLimit = 20
+	}
+	if o.MinLimit <= 0 {
+		o.MinLimit = 1
+	}
+	if o.MaxLimit <= 0 {
+		o.MaxLimit = 1000
+	}
+	if o.Backoff <= 0 || o.Backoff >= 1 {
+		o.Backoff = 0.9
+	}
+	if o.Tolerance <= 1 {
+		o.Tolerance = 2
+	}
+	if o.BaselineWindow <= 0 {
+		o.BaselineWindow = time.Minute
+	}
+	if o.QueueTimeout <= 0 {
+		o.QueueTimeout = 100 * time.Millisecond
+	}
+	if o.Clock == nil {
+		o.Clock = time.Now
+	}
+	return o
+}
+
+// Stats is a snapshot of the state of the limiter of a service.
+type Stats struct {
+	Limit    int
+	InFlight int
+	Queued   int
+	// Baseline is the lowest recently observed latency.
+	Baseline time.Duration
+}
+
+// Limiter limits the concurrent requests of each service it is applied to
+// separately.
+type Limiter struct {
+	opts Options
+
+	mu       sync.Mutex
+	services map[string]*serviceLimiter
+}
+
+// New creates a new Limiter.
+func New(opts Options) *Limiter {
+	return &Limiter{
+		opts:     opts.withDefaults(),
+		services: make(map[string]*serviceLimiter),
+	}
+}
+
+// Middleware returns a nano.Middleware that applies the limiter to the
+// requests.
+func (l *Limiter) Middleware() nano.Middleware {
+	return func(next nano.RequestHandler) nano.RequestHandler {
+		return func(caller, callee *nano.Ctx, req interface{}) (resp interface{}, err error) {
+			sl := l.service(callee.Svc.Name())
+			ctx := callee.Context
+			if ctx == nil {
+				ctx = context.Background()
+			}
+			if err := sl.acquire(ctx, priority(callee)); err != nil {
+				return nil, err
+			}
+
+			start := l.opts.Clock()
+			// A panic of the service is counted as a dropped request.
+			panicked := true
+			defer func() {
+				dropped := panicked || ctx.Err() == context.DeadlineExceeded ||
+					util.GetErrCode(err) == config.ErrorCodeOverloaded
+				sl.release(l.opts.Clock().Sub(start), dropped)
+			}()
+			resp, err = next(caller, callee, req)
+			panicked = false
+			return
+		}
+	}
+}
+
+// Stats returns the state of the limiter of the given service.
+func (l *Limiter) Stats(service string) Stats {
+	sl := l.service(service)
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+	return Stats{
+		Limit:    int(sl.limit),
+		InFlight: sl.inFlight,
+		Queued:   len(sl.queue),
+		Baseline: sl.baseline(),
+	}
+}
+
+func (l *Limiter) service(name string) *serviceLimiter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	sl, ok := l.services[name]
+	if !ok {
+		sl = &serviceLimiter{
+			opts:  &l.opts,
+			name:  name,
+			limit: float64(l.opts.InitialLimit),
+		}
+		l.services[name] = sl
+	}
+	return sl
+}
+
+func priority(c *nano.Ctx) int {
+	p, err := strconv.Atoi(c.Metadata[PriorityMetadataKey])
+	if err != nil {
+		return 0
+	}
+	return p
+}
+
+type serviceLimiter struct {
+	opts *Options
+	name string
+
+	mu       sync.Mutex
+	limit    float64
+	inFlight int
+	queue    waiterQueue
+	seq      uint64
+
+	windowStart time.Time
+	windowMin   time.Duration
+	prevMin     time.Duration
+}
+
+func (p *serviceLimiter) acquire(ctx context.Context, priority int) error {
+	p.mu.Lock()
+	if p.inFlight < int(p.limit) && len(p.queue) == 0 {
+		p.inFlight++
+		p.mu.Unlock()
+		return nil
+	}
+	if p.opts.QueueSize <= 0 {
+		p.mu.Unlock()
+		return p.overloadedError()
+	}
+	if len(p.queue) >= p.opts.QueueSize {
+		lowest := p.queue.lowest()
+		if lowest.priority >= priority {
+			p.mu.Unlock()
+			return p.overloadedError()
+		}
+		// The new request is more important than the least important
+		// queued request so the latter is shed.
+		heap.Remove(&p.queue, lowest.index)
+		lowest.ready <- false
+	}
+	p.seq++
+	w := &waiter{
+		priority: priority,
+		seq:      p.seq,
+		ready:    make(chan bool, 1),
+	}
+	heap.Push(&p.queue, w)
+	p.mu.Unlock()
+
+	t := time.NewTimer(p.opts.QueueTimeout)
+	defer t.Stop()
+	select {
+	case ok := <-w.ready:
+		if ok {
+			return nil
+		}
+		return p.overloadedError()
+	case <-t.C:
+	case <-ctx.Done():
+	}
+
+	p.mu.Lock()
+	if w.index >= 0 {
+		heap.Remove(&p.queue, w.index)
+		p.mu.Unlock()
+		return p.overloadedError()
+	}
+	p.mu.Unlock()
+	// The waiter has been removed from the queue concurrently with the
+	// timeout so the result is already in the channel.
+	if <-w.ready {
+		return nil
+	}
+	return p.overloadedError()
+}
+
+func (p *serviceLimiter) release(latency time.Duration, dropped bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.inFlight--
+
+	now := p.opts.Clock()
+	if now.Sub(p.windowStart) > p.opts.BaselineWindow {
+		p.prevMin, p.windowMin, p.windowStart = p.windowMin, 0, now
+	}
+	if !dropped && (p.windowMin == 0 || latency < p.windowMin) {
+		p.windowMin = latency
+	}
+
+	threshold := p.opts.LatencyThreshold
+	if threshold == 0 {
+		threshold = time.Duration(float64(p.baseline()) * p.opts.Tolerance)
+	}
+	if dropped || latency > threshold {
+		p.limit *= p.opts.Backoff
+		if p.limit < float64(p.opts.MinLimit) {
+			p.limit = float64(p.opts.MinLimit)
+		}
+	} else if p.inFlight+1 >= int(p.limit) {
+		// The limit is increased only if it has been reached. This prevents
+		// the limit from growing without bounds under light load.
+		p.limit += 1 / p.limit
+		if p.limit > float64(p.opts.MaxLimit) {
+			p.limit = float64(p.opts.MaxLimit)
+		}
+	}
+
+	for p.inFlight < int(p.limit) && len(p.queue) > 0 {
+		w := heap.Pop(&p.queue).(*waiter)
+		p.inFlight++
+		w.ready <- true
+	}
+}
+
+// baseline returns the lowest latency of the current and the previous
+// window. The caller has to hold p.mu.
+func (p *serviceLimiter) baseline() time.Duration {
+	if p.prevMin != 0 && (p.windowMin == 0 || p.prevMin < p.windowMin) {
+		return p.prevMin
+	}
+	return p.windowMin
+}
+
+func (p *serviceLimiter) overloadedError() error {
+	return util.ErrCode(nil, config.ErrorCodeOverloaded,
+		"service "+p.name+" is overloaded")
+}
+
+type waiter struct {
+	priority int
+	seq      uint64
+	// ready receives true if the waiter got a slot and false if it has
+	// been shed.
+	ready chan bool
+	// index is the index of the waiter in the queue, -1 if it isn't queued.
+	index int
+}
+
+// waiterQueue is a heap that pops the waiter with the highest priority first
+// and the waiters with the same priority in FIFO order.
+type waiterQueue []*waiter
+
+func (q waiterQueue) Len() int { return len(q) }
+
+func (q waiterQueue) Less(i, j int) bool {
+	if q[i].priority != q[j].priority {
+		return q[i].priority > q[j].priority
+	}
+	return q[i].seq < q[j].seq
+}
+
+func (q waiterQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *waiterQueue) Push(x interface{}) {
+	w := x.(*waiter)
+	w.index = len(*q)
+	*q = append(*q, w)
+}
+
+func (q *waiterQueue) Pop() interface{} {
+	old := *q
+	w := old[len(old)-1]
+	old[len(old)-1] = nil
+	*q = old[:len(old)-1]
+	w.index = -1
+	return w
+}
+
+// lowest returns the least important waiter: the one with the lowest
+// priority that arrived last.
+func (q waiterQueue) lowest() *waiter {
+	lowest := q[0]
+	for _, w := range q[1:] {
+		if q.Less(lowest.index, w.index) {
+			lowest = w
+		}
+	}
+	return lowest
+}
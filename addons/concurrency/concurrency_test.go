@@ -0,0 +1,254 @@
+package concurrency
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pasztorpisti/nano"
+	"github.com/pasztorpisti/nano/addons/internal/fakeclock"
+	"github.com/pasztorpisti/nano/addons/transport/http/config"
+	"github.com/pasztorpisti/nano/addons/util"
+)
+
+// blockingClient returns a client of a service whose requests block until
+// unblock is closed. started receives a value when a request reaches the
+// service.
+func blockingClient(l *Limiter) (client nano.Client, started chan struct{}, unblock chan struct{}) {
+	started = make(chan struct{}, 100)
+	unblock = make(chan struct{})
+	svc := util.NewService("svc", func(c *nano.Ctx, req interface{}) (interface{}, error) {
+		started <- struct{}{}
+		<-unblock
+		return nil, nil
+	})
+	ss := nano.NewServiceSetOpts(nano.ServiceSetOpts{
+		Services:    []nano.Service{svc},
+		Middlewares: []nano.Middleware{l.Middleware()},
+	})
+	client = nano.NewClientSet(ss, "test").LookupClient("svc")
+	return
+}
+
+func ctxWithPriority(priority string) *nano.Ctx {
+	return (&nano.Ctx{}).WithMetadata(PriorityMetadataKey, priority)
+}
+
+func TestLimiter_Sheds_Load(t *testing.T) {
+	l := New(Options{InitialLimit: 2, LatencyThreshold: time.Hour})
+	client, started, unblock := blockingClient(l)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			client.Request(nil, nil)
+		}()
+		<-started
+	}
+
+	_, err := client.Request(nil, nil)
+	if code := util.GetErrCode(err); code != config.ErrorCodeOverloaded {
+		t.Errorf("error code == %q, want %q", code, config.ErrorCodeOverloaded)
+	}
+	if s := l.Stats("svc"); s.InFlight != 2 || s.Queued != 0 {
+		t.Errorf("unexpected stats: %+v", s)
+	}
+
+	close(unblock)
+	wg.Wait()
+	if _, err := client.Request(nil, nil); err != nil {
+		t.Errorf("unexpected error :: %v", err)
+	}
+}
+
+func TestLimiter_Panic(t *testing.T) {
+	origLogPanic := nano.LogPanic
+	nano.LogPanic = func(*nano.Ctx, *nano.PanicError) {}
+	defer func() {
+		nano.LogPanic = origLogPanic
+	}()
+
+	l := New(Options{InitialLimit: 10, LatencyThreshold: time.Hour})
+	svc := util.NewService("svc", func(c *nano.Ctx, req interface{}) (interface{}, error) {
+		if req == "panic" {
+			panic("test")
+		}
+		return nil, nil
+	})
+	ss := nano.NewServiceSetOpts(nano.ServiceSetOpts{
+		Services:    []nano.Service{svc},
+		Middlewares: []nano.Middleware{l.Middleware()},
+	})
+	client := nano.NewClientSet(ss, "test").LookupClient("svc")
+
+	_, err := client.Request(nil, "panic")
+	if code := util.GetErrCode(err); code != nano.PanicErrorCode {
+		t.Errorf("error code == %q, want %q", code, nano.PanicErrorCode)
+	}
+	// The slot of the panicking request has been released and the request
+	// has been counted as dropped.
+	if s := l.Stats("svc"); s.InFlight != 0 || s.Limit != 9 {
+		t.Errorf("unexpected stats: %+v", s)
+	}
+	if _, err := client.Request(nil, nil); err != nil {
+		t.Errorf("unexpected error :: %v", err)
+	}
+}
+
+func TestLimiter_Queue(t *testing.T) {
+	l := New(Options{
+		InitialLimit:     1,
+		LatencyThreshold: time.Hour,
+		QueueSize:        1,
+		QueueTimeout:     time.Hour,
+	})
+	client, started, unblock := blockingClient(l)
+
+	results := make(chan error, 3)
+	go func() {
+		_, err := client.Request(nil, nil)
+		results <- err
+	}()
+	<-started
+
+	// This request waits in the queue.
+	go func() {
+		_, err := client.Request(ctxWithPriority("1"), nil)
+		results <- err
+	}()
+	waitForQueued(t, l, 1)
+
+	// The queue is full and this request isn't more important than the
+	// queued one.
+	_, err := client.Request(ctxWithPriority("1"), nil)
+	if code := util.GetErrCode(err); code != config.ErrorCodeOverloaded {
+		t.Errorf("error code == %q, want %q", code, config.ErrorCodeOverloaded)
+	}
+
+	// This request is more important so it takes the place of the queued one.
+	go func() {
+		_, err := client.Request(ctxWithPriority("2"), nil)
+		results <- err
+	}()
+	err = <-results
+	if code := util.GetErrCode(err); code != config.ErrorCodeOverloaded {
+		t.Errorf("error code of the shed request == %q, want %q", code, config.ErrorCodeOverloaded)
+	}
+	waitForQueued(t, l, 1)
+
+	close(unblock)
+	for i := 0; i < 2; i++ {
+		if err := <-results; err != nil {
+			t.Errorf("unexpected error :: %v", err)
+		}
+	}
+}
+
+func TestLimiter_Queue_Timeout(t *testing.T) {
+	l := New(Options{
+		InitialLimit:     1,
+		LatencyThreshold: time.Hour,
+		QueueSize:        1,
+		QueueTimeout:     time.Millisecond,
+	})
+	client, started, unblock := blockingClient(l)
+	done := make(chan struct{})
+	defer func() {
+		close(unblock)
+		<-done
+	}()
+
+	go func() {
+		defer close(done)
+		client.Request(nil, nil)
+	}()
+	<-started
+
+	_, err := client.Request(nil, nil)
+	if code := util.GetErrCode(err); code != config.ErrorCodeOverloaded {
+		t.Errorf("error code == %q, want %q", code, config.ErrorCodeOverloaded)
+	}
+	if s := l.Stats("svc"); s.Queued != 0 {
+		t.Errorf("unexpected stats: %+v", s)
+	}
+}
+
+func waitForQueued(t *testing.T, l *Limiter, n int) {
+	deadline := time.Now().Add(time.Second)
+	for l.Stats("svc").Queued != n {
+		if time.Now().After(deadline) {
+			t.Errorf("the number of queued requests hasn't become %v", n)
+			t.FailNow()
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestLimiter_AIMD(t *testing.T) {
+	sl := New(Options{
+		InitialLimit:     10,
+		MinLimit:         5,
+		LatencyThreshold: 100 * time.Millisecond,
+	}).service("svc")
+	ctx := context.Background()
+
+	// The limit doesn't grow if it isn't reached.
+	sl.acquire(ctx, 0)
+	sl.release(time.Millisecond, false)
+	if sl.limit != 10 {
+		t.Errorf("limit == %v, want 10", sl.limit)
+	}
+
+	for i := 0; i < 10; i++ {
+		sl.acquire(ctx, 0)
+	}
+	sl.release(time.Millisecond, false)
+	if sl.limit != 10.1 {
+		t.Errorf("limit == %v, want 10.1", sl.limit)
+	}
+
+	sl.release(time.Second, false)
+	if sl.limit < 9.08 || sl.limit > 9.1 {
+		t.Errorf("limit == %v, want 9.09", sl.limit)
+	}
+
+	for i := 0; i < 8; i++ {
+		sl.release(time.Millisecond, true)
+	}
+	if sl.limit != 5 {
+		t.Errorf("limit == %v, want the MinLimit", sl.limit)
+	}
+}
+
+func TestLimiter_Baseline(t *testing.T) {
+	clock := fakeclock.New()
+	sl := New(Options{InitialLimit: 10, Clock: clock.Now}).service("svc")
+	ctx := context.Background()
+
+	sl.acquire(ctx, 0)
+	sl.release(10*time.Millisecond, false)
+	if b := sl.baseline(); b != 10*time.Millisecond {
+		t.Errorf("baseline == %v, want %v", b, 10*time.Millisecond)
+	}
+
+	// 30ms is above the default tolerance of 2x the baseline.
+	sl.acquire(ctx, 0)
+	sl.release(30*time.Millisecond, false)
+	if sl.limit != 9 {
+		t.Errorf("limit == %v, want 9", sl.limit)
+	}
+
+	// The baseline is forgotten after two windows.
+	clock.Advance(61 * time.Second)
+	sl.acquire(ctx, 0)
+	sl.release(50*time.Millisecond, false)
+	clock.Advance(61 * time.Second)
+	sl.acquire(ctx, 0)
+	sl.release(60*time.Millisecond, false)
+	if b := sl.baseline(); b != 50*time.Millisecond {
+		t.Errorf("baseline == %v, want %v", b, 50*time.Millisecond)
+	}
+}
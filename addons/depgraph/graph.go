@@ -0,0 +1,255 @@
+/*
+Package depgraph analyses and exports the dependency graph recorded by a
+nano.ServiceSet while its services were looking up each other.
+*/
+package depgraph
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/pasztorpisti/nano"
+)
+
+// Graph is the dependency graph of a set of services.
+type Graph struct {
+	// Services contains the names of all services in sorted order.
+	Services []string
+
+	// Dependencies maps service names to the sorted names of the services
+	// they depend on.
+	Dependencies map[string][]string
+}
+
+// FromServiceSet returns the dependency graph of the given ServiceSet.
+// It returns an error if ss doesn't implement the nano.DependencyGraph
+// interface.
+func FromServiceSet(ss nano.ServiceSet) (*Graph, error) {
+	dg, ok := ss.(nano.DependencyGraph)
+	if !ok {
+		return nil, fmt.Errorf("%T doesn't implement nano.DependencyGraph", ss)
+	}
+	return New(dg.Dependencies()), nil
+}
+
+// New creates a Graph from a map in which the keys are service names and the
+// values are the names of the services the given service depends on.
+// Services that appear only as dependencies are added to the graph too.
+func New(deps map[string][]string) *Graph {
+	g := &Graph{
+		Dependencies: make(map[string][]string, len(deps)),
+	}
+	for name, svcDeps := range deps {
+		g.add(name)
+		sorted := make([]string, 0, len(svcDeps))
+		seen := make(map[string]struct{}, len(svcDeps))
+		for _, dep := range svcDeps {
+			if _, ok := seen[dep]; ok {
+				continue
+			}
+			seen[dep] = struct{}{}
+			sorted = append(sorted, dep)
+			g.add(dep)
+		}
+		sort.Strings(sorted)
+		g.Dependencies[name] = sorted
+	}
+	sort.Strings(g.Services)
+	return g
+}
+
+func (g *Graph) add(name string) {
+	if _, ok := g.Dependencies[name]; ok {
+		return
+	}
+	g.Services = append(g.Services, name)
+	g.Dependencies[name] = nil
+}
+
+// Dependents returns the sorted names of the services that depend on svcName.
+func (g *Graph) Dependents(svcName string) []string {
+	var dependents []string
+	for _, name := range g.Services {
+		for _, dep := range g.Dependencies[name] {
+			if dep == svcName {
+				dependents = append(dependents, name)
+				break
+			}
+		}
+	}
+	return dependents
+}
+
+// Cycles returns the dependency cycles of the graph. Each item of the returned
+// list is a set of services (in sorted order) that depend on each other
+// directly or indirectly. A service that depends on itself forms a cycle on
+// its own. Returns nil if the graph is acyclic.
+func (g *Graph) Cycles() [][]string {
+	// Tarjan's strongly connected components algorithm.
+	index := 0
+	indices := make(map[string]int, len(g.Services))
+	lowLinks := make(map[string]int, len(g.Services))
+	onStack := make(map[string]bool, len(g.Services))
+	var stack []string
+	var cycles [][]string
+
+	var strongConnect func(name string)
+	strongConnect = func(name string) {
+		indices[name] = index
+		lowLinks[name] = index
+		index++
+		stack = append(stack, name)
+		onStack[name] = true
+
+		selfLoop := false
+		for _, dep := range g.Dependencies[name] {
+			if dep == name {
+				selfLoop = true
+			}
+			if _, ok := indices[dep]; !ok {
+				strongConnect(dep)
+				if lowLinks[dep] < lowLinks[name] {
+					lowLinks[name] = lowLinks[dep]
+				}
+			} else if onStack[dep] && indices[dep] < lowLinks[name] {
+				lowLinks[name] = indices[dep]
+			}
+		}
+
+		if lowLinks[name] != indices[name] {
+			return
+		}
+		var component []string
+		for {
+			n := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			onStack[n] = false
+			component = append(component, n)
+			if n == name {
+				break
+			}
+		}
+		if len(component) > 1 || selfLoop {
+			sort.Strings(component)
+			cycles = append(cycles, component)
+		}
+	}
+
+	for _, name := range g.Services {
+		if _, ok := indices[name]; !ok {
+			strongConnect(name)
+		}
+	}
+	sort.Slice(cycles, func(i, j int) bool {
+		return cycles[i][0] < cycles[j][0]
+	})
+	return cycles
+}
+
+// Unused returns the sorted names of the services that aren't reachable from
+// any of the given entry point services. The entry points are usually the
+// services exposed by the listeners of a server.
+//
+// If no entry points are specified then Unused returns the services that no
+// other service depends on. In this case the result contains both the unused
+// services and the entry points of the graph.
+func (g *Graph) Unused(entryPoints ...string) []string {
+	var unused []string
+	if len(entryPoints) == 0 {
+		for _, name := range g.Services {
+			if len(g.dependentsExcludingSelf(name)) == 0 {
+				unused = append(unused, name)
+			}
+		}
+		return unused
+	}
+
+	reachable := make(map[string]bool, len(g.Services))
+	var visit func(name string)
+	visit = func(name string) {
+		if reachable[name] {
+			return
+		}
+		reachable[name] = true
+		for _, dep := range g.Dependencies[name] {
+			visit(dep)
+		}
+	}
+	for _, name := range entryPoints {
+		visit(name)
+	}
+	for _, name := range g.Services {
+		if !reachable[name] {
+			unused = append(unused, name)
+		}
+	}
+	return unused
+}
+
+func (g *Graph) dependentsExcludingSelf(svcName string) []string {
+	var dependents []string
+	for _, name := range g.Dependents(svcName) {
+		if name != svcName {
+			dependents = append(dependents, name)
+		}
+	}
+	return dependents
+}
+
+// WriteDOT writes the graph to w in Graphviz DOT format. An "A -> B" edge
+// means "A depends on B" or "A can send requests to B".
+func (g *Graph) WriteDOT(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "digraph services {")
+	for _, name := range g.Services {
+		fmt.Fprintf(bw, "\t%q;\n", name)
+	}
+	for _, name := range g.Services {
+		for _, dep := range g.Dependencies[name] {
+			fmt.Fprintf(bw, "\t%q -> %q;\n", name, dep)
+		}
+	}
+	fmt.Fprintln(bw, "}")
+	return bw.Flush()
+}
+
+// jsonGraph is the JSON representation of a Graph.
+type jsonGraph struct {
+	Services []jsonService `json:"services"`
+	Cycles   [][]string    `json:"cycles"`
+}
+
+type jsonService struct {
+	Name         string   `json:"name"`
+	Dependencies []string `json:"dependencies"`
+	Dependents   []string `json:"dependents"`
+}
+
+// MarshalJSON implements the json.Marshaler interface.
+func (g *Graph) MarshalJSON() ([]byte, error) {
+	jg := jsonGraph{
+		Services: make([]jsonService, 0, len(g.Services)),
+		Cycles:   g.Cycles(),
+	}
+	if jg.Cycles == nil {
+		jg.Cycles = [][]string{}
+	}
+	for _, name := range g.Services {
+		js := jsonService{
+			Name:         name,
+			Dependencies: g.Dependencies[name],
+			Dependents:   g.Dependents(name),
+		}
+		if js.Dependencies == nil {
+			js.Dependencies = []string{}
+		}
+		if js.Dependents == nil {
+			js.Dependents = []string{}
+		}
+		jg.Services = append(jg.Services, js)
+	}
+	return json.Marshal(&jg)
+}
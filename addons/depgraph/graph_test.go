@@ -0,0 +1,123 @@
+package depgraph
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/pasztorpisti/nano"
+	"github.com/pasztorpisti/nano/addons/util"
+)
+
+func newSvc(name string, deps ...string) nano.Service {
+	return util.NewServiceOpts(util.ServiceOpts{
+		Name: name,
+		Handler: func(c *nano.Ctx, req interface{}) (interface{}, error) {
+			return nil, nil
+		},
+		Init: func(cs nano.ClientSet) error {
+			for _, dep := range deps {
+				cs.LookupClient(dep)
+			}
+			return nil
+		},
+	})
+}
+
+func TestFromServiceSet(t *testing.T) {
+	ss := nano.NewServiceSet(
+		newSvc("svc2", "svc3", "svc1"),
+		newSvc("svc1"),
+		newSvc("svc3", "svc4"),
+		newSvc("svc4"),
+	)
+	g, err := FromServiceSet(ss)
+	if err != nil {
+		t.Errorf("FromServiceSet failed :: %v", err)
+		t.FailNow()
+	}
+
+	if want := []string{"svc1", "svc2", "svc3", "svc4"}; !reflect.DeepEqual(g.Services, want) {
+		t.Errorf("g.Services == %v, want %v", g.Services, want)
+	}
+	if v, want := g.Dependencies["svc2"], []string{"svc1", "svc3"}; !reflect.DeepEqual(v, want) {
+		t.Errorf("dependencies of svc2 == %v, want %v", v, want)
+	}
+	if v, want := g.Dependents("svc3"), []string{"svc2"}; !reflect.DeepEqual(v, want) {
+		t.Errorf("dependents of svc3 == %v, want %v", v, want)
+	}
+	if v := g.Cycles(); v != nil {
+		t.Errorf("unexpected cycles: %v", v)
+	}
+}
+
+func TestCycles(t *testing.T) {
+	g := New(map[string][]string{
+		"a": {"b"},
+		"b": {"c"},
+		"c": {"a", "d"},
+		"d": nil,
+		"e": {"e"},
+	})
+	want := [][]string{{"a", "b", "c"}, {"e"}}
+	if v := g.Cycles(); !reflect.DeepEqual(v, want) {
+		t.Errorf("cycles == %v, want %v", v, want)
+	}
+}
+
+func TestUnused(t *testing.T) {
+	g := New(map[string][]string{
+		"svc1": nil,
+		"svc2": {"svc1", "svc3"},
+		"svc3": {"svc4"},
+		"svc4": nil,
+		"svc5": {"svc4"},
+	})
+
+	if v, want := g.Unused(), []string{"svc2", "svc5"}; !reflect.DeepEqual(v, want) {
+		t.Errorf("Unused() == %v, want %v", v, want)
+	}
+	if v, want := g.Unused("svc2"), []string{"svc5"}; !reflect.DeepEqual(v, want) {
+		t.Errorf("Unused(svc2) == %v, want %v", v, want)
+	}
+}
+
+func TestWriteDOT(t *testing.T) {
+	g := New(map[string][]string{
+		"svc1": nil,
+		"svc2": {"svc1"},
+	})
+	b := bytes.NewBuffer(nil)
+	if err := g.WriteDOT(b); err != nil {
+		t.Errorf("WriteDOT failed :: %v", err)
+		t.FailNow()
+	}
+	want := "digraph services {\n" +
+		"\t\"svc1\";\n" +
+		"\t\"svc2\";\n" +
+		"\t\"svc2\" -> \"svc1\";\n" +
+		"}\n"
+	if b.String() != want {
+		t.Errorf("DOT output == %q, want %q", b.String(), want)
+	}
+}
+
+func TestMarshalJSON(t *testing.T) {
+	g := New(map[string][]string{
+		"svc1": {"svc2"},
+		"svc2": {"svc1"},
+	})
+	b, err := json.Marshal(g)
+	if err != nil {
+		t.Errorf("json.Marshal failed :: %v", err)
+		t.FailNow()
+	}
+	want := `{"services":[` +
+		`{"name":"svc1","dependencies":["svc2"],"dependents":["svc2"]},` +
+		`{"name":"svc2","dependencies":["svc1"],"dependents":["svc1"]}],` +
+		`"cycles":[["svc1","svc2"]]}`
+	if string(b) != want {
+		t.Errorf("json == %s, want %s", b, want)
+	}
+}
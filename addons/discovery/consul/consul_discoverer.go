@@ -0,0 +1,381 @@
+/*
+Package consul implements a discovery.Discoverer that locates the instances of
+services through the health API of the Consul HTTP agent. It talks to the
+agent with net/http so it doesn't depend on the Consul client packages.
+
+The first discovery of a service queries the agent and starts a watch: a loop
+of blocking queries that updates the instances of the service as soon as the
+catalog changes. The watch keeps the last known instances if the agent is
+unavailable and retries with exponential backoff. The responses without a
+valid X-Consul-Index header can't be followed by blocking queries so the watch
+polls with the same backoff in that case. The watch of a service stops after
+IdleTimeout without discoveries of the service.
+
+The consultest sub-package provides a fake agent for offline tests.
+*/
+package consul
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pasztorpisti/nano/addons/discovery"
+)
+
+// ServiceEntry is an item of the response of the /v1/health/service/<name>
+// endpoint.
+type ServiceEntry struct {
+	Node    Node
+	Service AgentService
+	Checks  []HealthCheck
+}
+
+type Node struct {
+	Node       string
+	Address    string
+	Datacenter string
+}
+
+type AgentService struct {
+	ID      string
+	Service string
+	Tags    []string
+	// Address defaults to the Address of the Node if empty.
+	Address string
+	Port    int
+	Meta    map[string]string
+	Weights Weights
+}
+
+type Weights struct {
+	Passing int
+	Warning int
+}
+
+// Health check statuses.
+const (
+	HealthPassing  = "passing"
+	HealthWarning  = "warning"
+	HealthCritical = "critical"
+)
+
+type HealthCheck struct {
+	CheckID string
+	Status  string
+}
+
+// IndexHeader is the header of the responses that contains the index of the
+// catalog used by blocking queries.
+const IndexHeader = "X-Consul-Index"
+
+// Options locates the Consul agent and filters the instances returned by its
+// health API.
+type Options struct {
+	// Addr is the "host:port" of the Consul agent. Defaults to
+	// "127.0.0.1:8500".
+	Addr string
+	// Scheme defaults to "http".
+	Scheme string
+	// Token is sent in the X-Consul-Token header if it isn't empty.
+	Token string
+	// Datacenter defaults to the datacenter of the agent.
+	Datacenter string
+	// Tag filters the instances by tag if it isn't empty.
+	Tag string
+	// IncludeUnhealthy includes the instances with failing health checks.
+	// By default only the instances with passing health checks are used.
+	IncludeUnhealthy bool
+
+	// Client defaults to http.DefaultClient. Its timeout has to be longer
+	// than WaitTime.
+	Client *http.Client
+	// WaitTime is the maximum duration of a blocking query. Defaults to
+	// 5 minutes.
+	WaitTime time.Duration
+	// MaxRetryBackoff is the maximum wait time after a failed query.
+	// Defaults to 30 seconds.
+	MaxRetryBackoff time.Duration
+	// IdleTimeout is the time after which the watch of a service stops if
+	// the service hasn't been discovered. The watch checks it after each
+	// query so it can take an additional WaitTime to stop.
+	// Defaults to 10 minutes.
+	IdleTimeout time.Duration
+}
+
+func (o Options) withDefaults() Options {
+	if o.Addr == "" {
+		o.Addr = "127.0.0.1:8500"
+	}
+	if o.Scheme == "" {
+		o.Scheme = "http"
+	}
+	if o.Client == nil {
+		o.Client = http.DefaultClient
+	}
+	if o.WaitTime <= 0 {
+		o.WaitTime = 5 * time.Minute
+	}
+	if o.MaxRetryBackoff <= 0 {
+		o.MaxRetryBackoff = 30 * time.Second
+	}
+	if o.IdleTimeout <= 0 {
+		o.IdleTimeout = 10 * time.Minute
+	}
+	return o
+}
+
+// Discoverer implements the discovery.InstanceDiscoverer and
+// discovery.Resolver interfaces.
+type Discoverer struct {
+	opts   Options
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu       sync.Mutex
+	services map[string]*watch
+	wg       sync.WaitGroup
+}
+
+type watch struct {
+	// ready is closed after the first query.
+	ready     chan struct{}
+	instances []discovery.Instance
+	err       error
+	index     uint64
+	lastUsed  time.Time
+}
+
+// New creates a new Discoverer. Close stops its watches.
+func New(opts Options) *Discoverer {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Discoverer{
+		opts:     opts.withDefaults(),
+		ctx:      ctx,
+		cancel:   cancel,
+		services: make(map[string]*watch),
+	}
+}
+
+// Discover returns the address of the first instance of the service.
+func (d *Discoverer) Discover(name string) (string, error) {
+	instances, err := d.DiscoverInstances(name)
+	if err != nil {
+		return "", err
+	}
+	return instances[0].Addr, nil
+}
+
+func (d *Discoverer) DiscoverAll(name string) ([]string, error) {
+	instances, err := d.DiscoverInstances(name)
+	if err != nil {
+		return nil, err
+	}
+	addrs := make([]string, len(instances))
+	for i, inst := range instances {
+		addrs[i] = inst.Addr
+	}
+	return addrs, nil
+}
+
+func (d *Discoverer) DiscoverInstances(name string) ([]discovery.Instance, error) {
+	return d.Resolve(context.Background(), name)
+}
+
+// Resolve implements the discovery.Resolver interface. It waits for the
+// first query of the service until ctx is done.
+func (d *Discoverer) Resolve(ctx context.Context, name string) ([]discovery.Instance, error) {
+	d.mu.Lock()
+	w, ok := d.services[name]
+	if !ok {
+		w = &watch{ready: make(chan struct{})}
+		d.services[name] = w
+		d.wg.Add(1)
+		go d.watch(name, w)
+	}
+	w.lastUsed = time.Now()
+	d.mu.Unlock()
+
+	select {
+	case <-w.ready:
+	case <-d.ctx.Done():
+		return nil, d.ctx.Err()
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if w.err != nil {
+		return nil, w.err
+	}
+	if len(w.instances) == 0 {
+		return nil, discovery.NotFoundError
+	}
+	return w.instances, nil
+}
+
+// Close stops the watches and waits for them to finish.
+func (d *Discoverer) Close() {
+	d.cancel()
+	d.wg.Wait()
+}
+
+func (d *Discoverer) watch(name string, w *watch) {
+	defer d.wg.Done()
+	var backoff time.Duration
+	for first := true; ; first = false {
+		var index uint64
+		if !first {
+			index = w.index
+		}
+		instances, newIndex, err := d.query(name, index)
+		if d.ctx.Err() != nil {
+			return
+		}
+
+		// poll is set if the next query can't block.
+		poll := false
+		d.mu.Lock()
+		switch {
+		case err == nil:
+			w.instances, w.err = instances, nil
+			switch {
+			case newIndex == 0:
+				// The response has no valid index. Querying again
+				// without waiting would turn the watch into a busy loop.
+				poll = true
+			case newIndex < w.index:
+				// A decreasing index means that the catalog has been reset
+				// and the next query shouldn't block.
+				newIndex = 0
+			}
+			w.index = newIndex
+		case w.instances == nil:
+			// The error is returned only if there are no results to fall
+			// back to.
+			w.err = err
+		}
+		idle := time.Since(w.lastUsed) > d.opts.IdleTimeout
+		if idle {
+			delete(d.services, name)
+		}
+		d.mu.Unlock()
+		if first {
+			close(w.ready)
+		}
+		if idle {
+			return
+		}
+
+		if err == nil && !poll {
+			backoff = 0
+			continue
+		}
+		if backoff == 0 {
+			backoff = time.Second
+		} else if backoff *= 2; backoff > d.opts.MaxRetryBackoff {
+			backoff = d.opts.MaxRetryBackoff
+		}
+		t := time.NewTimer(backoff)
+		select {
+		case <-t.C:
+		case <-d.ctx.Done():
+			t.Stop()
+			return
+		}
+	}
+}
+
+// query sends a blocking query if index isn't zero. It returns the instances
+// of the service and the index of the response.
+func (d *Discoverer) query(name string, index uint64) ([]discovery.Instance, uint64, error) {
+	q := url.Values{}
+	if !d.opts.IncludeUnhealthy {
+		q.Set("passing", "true")
+	}
+	if d.opts.Datacenter != "" {
+		q.Set("dc", d.opts.Datacenter)
+	}
+	if d.opts.Tag != "" {
+		q.Set("tag", d.opts.Tag)
+	}
+	if index != 0 {
+		q.Set("index", strconv.FormatUint(index, 10))
+		q.Set("wait", strconv.FormatInt(int64(d.opts.WaitTime/time.Millisecond), 10)+"ms")
+	}
+	u := url.URL{
+		Scheme:   d.opts.Scheme,
+		Host:     d.opts.Addr,
+		Path:     "/v1/health/service/" + name,
+		RawQuery: q.Encode(),
+	}
+
+	req, err := http.NewRequestWithContext(d.ctx, "GET", u.String(), nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	if d.opts.Token != "" {
+		req.Header.Set("X-Consul-Token", d.opts.Token)
+	}
+	resp, err := d.opts.Client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, 0, fmt.Errorf("consul query of service %v failed with status %v",
+			name, resp.Status)
+	}
+
+	var entries []ServiceEntry
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		return nil, 0, fmt.Errorf("error decoding consul response :: %v", err)
+	}
+	newIndex, _ := strconv.ParseUint(resp.Header.Get(IndexHeader), 10, 64)
+	return d.instances(entries), newIndex, nil
+}
+
+func (d *Discoverer) instances(entries []ServiceEntry) []discovery.Instance {
+	var instances []discovery.Instance
+	for _, e := range entries {
+		status := aggregateStatus(e.Checks)
+		if status == HealthCritical && !d.opts.IncludeUnhealthy {
+			continue
+		}
+		host := e.Service.Address
+		if host == "" {
+			host = e.Node.Address
+		}
+		weight := e.Service.Weights.Passing
+		if status == HealthWarning {
+			weight = e.Service.Weights.Warning
+		}
+		instances = append(instances, discovery.Instance{
+			Addr:     net.JoinHostPort(host, strconv.Itoa(e.Service.Port)),
+			Weight:   weight,
+			Metadata: e.Service.Meta,
+		})
+	}
+	return instances
+}
+
+// aggregateStatus returns the worst status of the checks.
+func aggregateStatus(checks []HealthCheck) string {
+	status := HealthPassing
+	for _, c := range checks {
+		switch c.Status {
+		case HealthCritical:
+			return HealthCritical
+		case HealthWarning:
+			status = HealthWarning
+		}
+	}
+	return status
+}
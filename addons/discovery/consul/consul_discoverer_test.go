@@ -0,0 +1,210 @@
+// The tests are in a separate package because consultest imports consul.
+package consul_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pasztorpisti/nano/addons/discovery"
+	"github.com/pasztorpisti/nano/addons/discovery/consul"
+	"github.com/pasztorpisti/nano/addons/discovery/consul/consultest"
+)
+
+func entry(id, svc, addr string, port int, status string) consul.ServiceEntry {
+	return consul.ServiceEntry{
+		Node: consul.Node{Node: "node-" + id, Address: "10.0.0.1"},
+		Service: consul.AgentService{
+			ID:      id,
+			Service: svc,
+			Address: addr,
+			Port:    port,
+			Weights: consul.Weights{Passing: 2, Warning: 1},
+		},
+		Checks: []consul.HealthCheck{{CheckID: "check-" + id, Status: status}},
+	}
+}
+
+// waitFor polls DiscoverAll until it returns want.
+func waitFor(t *testing.T, d *consul.Discoverer, name string, want []string) {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		addrs, _ := d.DiscoverAll(name)
+		if reflect.DeepEqual(addrs, want) {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("addrs == %v, want %v", addrs, want)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestDiscoverer(t *testing.T) {
+	s := consultest.NewServer()
+	defer s.Close()
+	s.Register(entry("a", "svc", "1.1.1.1", 80, consul.HealthPassing))
+	s.Register(entry("b", "svc", "", 81, consul.HealthPassing))
+	s.Register(entry("c", "svc", "3.3.3.3", 82, consul.HealthCritical))
+	s.Register(entry("d", "other", "4.4.4.4", 83, consul.HealthPassing))
+
+	d := consul.New(consul.Options{Addr: s.Addr()})
+	defer d.Close()
+	instances, err := d.DiscoverInstances("svc")
+	if err != nil {
+		t.Fatalf("unexpected error :: %v", err)
+	}
+	want := []discovery.Instance{
+		{Addr: "1.1.1.1:80", Weight: 2},
+		// The address of the node is used if the service has none.
+		{Addr: "10.0.0.1:81", Weight: 2},
+	}
+	if !reflect.DeepEqual(instances, want) {
+		t.Errorf("instances == %v, want %v", instances, want)
+	}
+	if addr, err := d.Discover("other"); err != nil || addr != "4.4.4.4:83" {
+		t.Errorf("Discover() == %q, %v, want 4.4.4.4:83", addr, err)
+	}
+	if _, err := d.Discover("missing"); err != discovery.NotFoundError {
+		t.Errorf("err == %v, want %v", err, discovery.NotFoundError)
+	}
+}
+
+func TestDiscoverer_Watch(t *testing.T) {
+	s := consultest.NewServer()
+	defer s.Close()
+	s.Register(entry("a", "svc", "1.1.1.1", 80, consul.HealthPassing))
+
+	d := consul.New(consul.Options{Addr: s.Addr()})
+	defer d.Close()
+	waitFor(t, d, "svc", []string{"1.1.1.1:80"})
+
+	s.Register(entry("b", "svc", "2.2.2.2", 80, consul.HealthPassing))
+	waitFor(t, d, "svc", []string{"1.1.1.1:80", "2.2.2.2:80"})
+
+	s.SetHealth("a", consul.HealthCritical)
+	waitFor(t, d, "svc", []string{"2.2.2.2:80"})
+
+	s.SetHealth("a", consul.HealthPassing)
+	s.Deregister("b")
+	waitFor(t, d, "svc", []string{"1.1.1.1:80"})
+
+	// The watch uses blocking queries instead of polling.
+	queries := s.Queries()
+	time.Sleep(50 * time.Millisecond)
+	if n := s.Queries(); n > queries+1 {
+		t.Errorf("%v queries without changes", n-queries)
+	}
+}
+
+func TestDiscoverer_IncludeUnhealthy(t *testing.T) {
+	s := consultest.NewServer()
+	defer s.Close()
+	s.Register(entry("a", "svc", "1.1.1.1", 80, consul.HealthPassing))
+	s.Register(entry("b", "svc", "2.2.2.2", 80, consul.HealthWarning))
+	s.Register(entry("c", "svc", "3.3.3.3", 80, consul.HealthCritical))
+
+	d := consul.New(consul.Options{Addr: s.Addr(), IncludeUnhealthy: true})
+	defer d.Close()
+	instances, err := d.DiscoverInstances("svc")
+	if err != nil {
+		t.Fatalf("unexpected error :: %v", err)
+	}
+	// The instances in warning state get the warning weight.
+	want := []discovery.Instance{
+		{Addr: "1.1.1.1:80", Weight: 2},
+		{Addr: "2.2.2.2:80", Weight: 1},
+		{Addr: "3.3.3.3:80", Weight: 2},
+	}
+	if !reflect.DeepEqual(instances, want) {
+		t.Errorf("instances == %v, want %v", instances, want)
+	}
+}
+
+func TestDiscoverer_Keeps_Instances_On_Error(t *testing.T) {
+	s := consultest.NewServer()
+	s.Register(entry("a", "svc", "1.1.1.1", 80, consul.HealthPassing))
+
+	d := consul.New(consul.Options{
+		Addr:   s.Addr(),
+		Client: &http.Client{Timeout: time.Second},
+	})
+	defer d.Close()
+	waitFor(t, d, "svc", []string{"1.1.1.1:80"})
+
+	s.CloseClientConnections()
+	s.Close()
+	if addrs, err := d.DiscoverAll("svc"); err != nil || !reflect.DeepEqual(addrs, []string{"1.1.1.1:80"}) {
+		t.Errorf("DiscoverAll() == %v, %v, want [1.1.1.1:80]", addrs, err)
+	}
+}
+
+func TestDiscoverer_Agent_Unavailable(t *testing.T) {
+	s := consultest.NewServer()
+	addr := s.Addr()
+	s.Close()
+
+	d := consul.New(consul.Options{Addr: addr})
+	defer d.Close()
+	if _, err := d.Discover("svc"); err == nil {
+		t.Error("expected an error")
+	}
+}
+
+func TestDiscoverer_Missing_Index(t *testing.T) {
+	var mu sync.Mutex
+	queries := 0
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		queries++
+		mu.Unlock()
+		// The response has no index header.
+		json.NewEncoder(w).Encode([]consul.ServiceEntry{
+			entry("a", "svc", "1.1.1.1", 80, consul.HealthPassing),
+		})
+	}))
+	defer s.Close()
+
+	d := consul.New(consul.Options{Addr: s.Listener.Addr().String()})
+	defer d.Close()
+	if addr, err := d.Discover("svc"); err != nil || addr != "1.1.1.1:80" {
+		t.Fatalf("Discover() == %q, %v, want 1.1.1.1:80", addr, err)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	// The next query is sent after the backoff.
+	mu.Lock()
+	defer mu.Unlock()
+	if queries != 1 {
+		t.Errorf("queries == %v, want 1", queries)
+	}
+}
+
+func TestDiscoverer_IdleTimeout(t *testing.T) {
+	s := consultest.NewServer()
+	defer s.Close()
+	s.Register(entry("a", "svc", "1.1.1.1", 80, consul.HealthPassing))
+
+	d := consul.New(consul.Options{
+		Addr:        s.Addr(),
+		WaitTime:    10 * time.Millisecond,
+		IdleTimeout: 30 * time.Millisecond,
+	})
+	defer d.Close()
+	waitFor(t, d, "svc", []string{"1.1.1.1:80"})
+
+	time.Sleep(100 * time.Millisecond)
+	queries := s.Queries()
+	time.Sleep(50 * time.Millisecond)
+	if q := s.Queries(); q != queries {
+		t.Errorf("the idle watch sent %v queries", q-queries)
+	}
+
+	// The next discovery starts a new watch.
+	s.Register(entry("b", "svc", "2.2.2.2", 81, consul.HealthPassing))
+	waitFor(t, d, "svc", []string{"1.1.1.1:80", "2.2.2.2:81"})
+}
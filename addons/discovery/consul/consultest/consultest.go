@@ -0,0 +1,200 @@
+/*
+Package consultest implements a fake Consul agent that serves the
+/v1/health/service/<name> endpoint with blocking queries. It can be used to
+test the consul discoverer and the servers that use it without Consul.
+*/
+package consultest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pasztorpisti/nano/addons/discovery/consul"
+)
+
+// Server is a fake Consul agent. Every change of the catalog increments its
+// index and wakes up the blocking queries.
+type Server struct {
+	*httptest.Server
+
+	mu      sync.Mutex
+	index   uint64
+	entries map[string]*consul.ServiceEntry
+	// changed is closed and replaced by every change of the catalog.
+	changed chan struct{}
+	queries int
+}
+
+// NewServer starts a fake agent with an empty catalog. Server.Listener.Addr()
+// can be used as consul.Options.Addr.
+func NewServer() *Server {
+	s := &Server{
+		index:   1,
+		entries: make(map[string]*consul.ServiceEntry),
+		changed: make(chan struct{}),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// Addr returns the "host:port" of the server.
+func (s *Server) Addr() string {
+	return s.Listener.Addr().String()
+}
+
+// Register adds an entry to the catalog or replaces the entry with the same
+// Service.ID. The health checks of the entry decide whether it is healthy.
+func (s *Server) Register(e consul.ServiceEntry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries[e.Service.ID] = &e
+	s.changeLocked()
+}
+
+// Deregister removes the entry with the given Service.ID from the catalog.
+func (s *Server) Deregister(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.entries, id)
+	s.changeLocked()
+}
+
+// SetHealth sets the status of all health checks of the entry with the given
+// Service.ID. It adds a check if the entry has none.
+func (s *Server) SetHealth(id, status string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.entries[id]
+	if !ok {
+		return
+	}
+	if len(e.Checks) == 0 {
+		e.Checks = []consul.HealthCheck{{CheckID: "service:" + id}}
+	}
+	checks := make([]consul.HealthCheck, len(e.Checks))
+	for i, c := range e.Checks {
+		c.Status = status
+		checks[i] = c
+	}
+	e.Checks = checks
+	s.changeLocked()
+}
+
+// Index returns the current index of the catalog.
+func (s *Server) Index() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.index
+}
+
+// Queries returns the number of the queries that have been received.
+func (s *Server) Queries() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.queries
+}
+
+func (s *Server) changeLocked() {
+	s.index++
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	const prefix = "/v1/health/service/"
+	if r.Method != "GET" || !strings.HasPrefix(r.URL.Path, prefix) {
+		http.NotFound(w, r)
+		return
+	}
+	name := strings.TrimPrefix(r.URL.Path, prefix)
+	q := r.URL.Query()
+
+	var index uint64
+	if v := q.Get("index"); v != "" {
+		var err error
+		if index, err = strconv.ParseUint(v, 10, 64); err != nil {
+			http.Error(w, "invalid index", http.StatusBadRequest)
+			return
+		}
+	}
+	wait := 5 * time.Minute
+	if v := q.Get("wait"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			http.Error(w, "invalid wait", http.StatusBadRequest)
+			return
+		}
+		wait = d
+	}
+
+	s.mu.Lock()
+	s.queries++
+	s.mu.Unlock()
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	for {
+		s.mu.Lock()
+		if index == 0 || s.index > index {
+			break
+		}
+		changed := s.changed
+		s.mu.Unlock()
+		select {
+		case <-changed:
+		case <-timer.C:
+			// The current state is returned after the wait time even if it
+			// hasn't changed.
+			index = 0
+		case <-r.Context().Done():
+			return
+		}
+	}
+	entries := s.query(name, q.Get("tag"), q.Get("passing") != "")
+	w.Header().Set(consul.IndexHeader, strconv.FormatUint(s.index, 10))
+	s.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
+
+// query has to be called while holding s.mu.
+func (s *Server) query(name, tag string, passing bool) []consul.ServiceEntry {
+	entries := []consul.ServiceEntry{}
+	for _, e := range s.entries {
+		if e.Service.Service != name || tag != "" && !hasTag(e.Service.Tags, tag) {
+			continue
+		}
+		if passing && !isPassing(e.Checks) {
+			continue
+		}
+		entries = append(entries, *e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Service.ID < entries[j].Service.ID
+	})
+	return entries
+}
+
+func hasTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+func isPassing(checks []consul.HealthCheck) bool {
+	for _, c := range checks {
+		if c.Status != consul.HealthPassing {
+			return false
+		}
+	}
+	return true
+}
@@ -1,6 +1,9 @@
 package discovery
 
-import "errors"
+import (
+	"context"
+	"errors"
+)
 
 // NotFoundError can be returned by returned by Discoverer.Discover when it
 // can't locate a given service.
@@ -17,3 +20,135 @@ type Discoverer interface {
 	// NotFoundError makes sense.
 	Discover(name string) (string, error)
 }
+
+// MultiDiscoverer is an optional interface of Discoverers that can locate
+// more than one instance of a service.
+type MultiDiscoverer interface {
+	Discoverer
+
+	// DiscoverAll receives the name of a service and returns the "host:port"
+	// of all instances of the service. It returns the errors the same way
+	// as Discover and it never returns an empty list without an error.
+	DiscoverAll(name string) ([]string, error)
+}
+
+// DiscoverAll returns the addresses of all instances of a service. If d
+// doesn't implement MultiDiscoverer then it returns the single address
+// returned by d.Discover.
+func DiscoverAll(d Discoverer, name string) ([]string, error) {
+	if md, ok := d.(MultiDiscoverer); ok {
+		return md.DiscoverAll(name)
+	}
+	addr, err := d.Discover(name)
+	if err != nil {
+		return nil, err
+	}
+	return []string{addr}, nil
+}
+
+// Well-known keys of Instance.Metadata.
+const (
+	MetadataZone    = "zone"
+	MetadataVersion = "version"
+)
+
+// Instance is an instance of a service.
+type Instance struct {
+	// Addr is the "host:port" of the instance in a format expected by
+	// net.Dial.
+	Addr string
+	// Scheme is the URL scheme of the instance, e.g.: "https". Empty means
+	// the default of the transport, e.g.: "http" in case of the http
+	// transport.
+	Scheme string
+	// PathPrefix is prepended to the URL path of the requests sent to the
+	// instance, e.g.: "/api" if the instance is behind a reverse proxy that
+	// routes the requests by path. Optional.
+	PathPrefix string
+	// Weight is the relative share of the requests the instance should
+	// receive. Zero is treated as 1.
+	Weight int
+	// Metadata holds arbitrary information about the instance. Optional.
+	Metadata map[string]string
+}
+
+// InstanceDiscoverer is an optional interface of Discoverers that can return
+// the weight and metadata of the instances of a service.
+type InstanceDiscoverer interface {
+	Discoverer
+
+	// DiscoverInstances receives the name of a service and returns all of
+	// its instances. It returns the errors the same way as Discover and it
+	// never returns an empty list without an error.
+	DiscoverInstances(name string) ([]Instance, error)
+}
+
+// DiscoverInstances returns all instances of a service. If d doesn't
+// implement InstanceDiscoverer then it returns the addresses returned by
+// DiscoverAll with zero weight and nil metadata.
+func DiscoverInstances(d Discoverer, name string) ([]Instance, error) {
+	if id, ok := d.(InstanceDiscoverer); ok {
+		return id.DiscoverInstances(name)
+	}
+	addrs, err := DiscoverAll(d, name)
+	if err != nil {
+		return nil, err
+	}
+	instances := make([]Instance, len(addrs))
+	for i, addr := range addrs {
+		instances[i].Addr = addr
+	}
+	return instances, nil
+}
+
+// Resolver is the context-aware alternative of Discoverer. The transports
+// use it to locate the instances of the services within the deadline of the
+// request being sent.
+type Resolver interface {
+	// Resolve receives the name of a service and returns all of its
+	// instances. It returns the errors the same way as Discoverer.Discover
+	// and it never returns an empty list without an error. It should return
+	// ctx.Err() if ctx is done before the instances are located.
+	Resolve(ctx context.Context, name string) ([]Instance, error)
+}
+
+// NewResolver adapts a Discoverer to the Resolver interface. If d implements
+// Resolver then it is returned as is. Otherwise Resolve calls
+// DiscoverInstances on a separate goroutine and returns when ctx is done
+// without waiting for a slow Discoverer.
+func NewResolver(d Discoverer) Resolver {
+	if r, ok := d.(Resolver); ok {
+		return r
+	}
+	return resolver{d}
+}
+
+type resolver struct {
+	d Discoverer
+}
+
+func (r resolver) Resolve(ctx context.Context, name string) ([]Instance, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if ctx.Done() == nil {
+		return DiscoverInstances(r.d, name)
+	}
+
+	type result struct {
+		instances []Instance
+		err       error
+	}
+	// Buffered to let the lookup finish after ctx is done.
+	ch := make(chan result, 1)
+	go func() {
+		instances, err := DiscoverInstances(r.d, name)
+		ch <- result{instances, err}
+	}()
+	select {
+	case res := <-ch:
+		return res.instances, res.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
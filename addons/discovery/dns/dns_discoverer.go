@@ -0,0 +1,405 @@
+/*
+Package dns implements a discovery.Discoverer that locates the instances of
+services with DNS SRV or A/AAAA records.
+
+The results are cached for the TTL of the records and the failed lookups for
+Options.NegativeTTL. The names that have been discovered recently are
+refreshed in the background before their TTL expires so Discover rarely has to
+wait for a DNS query. If a refresh fails because of a network or server error
+then the previous results are used until a later refresh succeeds.
+*/
+package dns
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pasztorpisti/nano/addons/discovery"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+type Mode int
+
+const (
+	// SRV looks up the SRV records of the name of the service and resolves
+	// their targets with A/AAAA records unless the DNS server has sent the
+	// addresses along with the SRV records. Only the targets with the lowest
+	// priority are used and the weight of the SRV records becomes the
+	// weight of the instances.
+	SRV Mode = iota
+	// A looks up the A and AAAA records of the name of the service and
+	// combines the addresses with Options.Port.
+	A
+)
+
+// Options selects the DNS records of the services and the caching of the
+// lookups.
+type Options struct {
+	Mode Mode
+	// Name returns the DNS name of a service, e.g.: "_http._tcp.<service>" or
+	// "<service>.default.svc.cluster.local". Defaults to the name of the
+	// service.
+	Name func(service string) string
+	// Port is the port of the instances in A mode.
+	Port int
+
+	// Resolver defaults to DefaultResolver().
+	Resolver Resolver
+	// Timeout is the timeout of a lookup. Defaults to 5 seconds.
+	Timeout time.Duration
+
+	// MinTTL defaults to 1 second.
+	MinTTL time.Duration
+	// MaxTTL defaults to 5 minutes.
+	MaxTTL time.Duration
+	// NegativeTTL is the time for which a failed lookup is cached.
+	// Defaults to 5 seconds.
+	NegativeTTL time.Duration
+	// IdleTimeout is the time after which a name isn't refreshed in the
+	// background if it hasn't been discovered. Defaults to 10 minutes.
+	IdleTimeout time.Duration
+}
+
+func (o Options) withDefaults() Options {
+	if o.Name == nil {
+		o.Name = func(service string) string {
+			return service
+		}
+	}
+	if o.Resolver == nil {
+		o.Resolver = DefaultResolver()
+	}
+	if o.Timeout <= 0 {
+		o.Timeout = 5 * time.Second
+	}
+	if o.MinTTL <= 0 {
+		o.MinTTL = time.Second
+	}
+	if o.MaxTTL <= 0 {
+		o.MaxTTL = 5 * time.Minute
+	}
+	if o.NegativeTTL <= 0 {
+		o.NegativeTTL = 5 * time.Second
+	}
+	if o.IdleTimeout <= 0 {
+		o.IdleTimeout = 10 * time.Minute
+	}
+	return o
+}
+
+// Discoverer implements the discovery.InstanceDiscoverer interface.
+type Discoverer struct {
+	opts Options
+
+	mu      sync.Mutex
+	entries map[string]*entry
+	closed  bool
+}
+
+type entry struct {
+	instances []discovery.Instance
+	err       error
+	expires   time.Time
+	lastUsed  time.Time
+	// loading is closed when the lookup in progress finishes. It is nil if
+	// there is no lookup in progress.
+	loading chan struct{}
+	// refresh is the timer of the background refresh. Nil if there is no
+	// scheduled refresh.
+	refresh *time.Timer
+}
+
+// New creates a new Discoverer. Close stops its background refreshes.
+func New(opts Options) *Discoverer {
+	return &Discoverer{
+		opts:    opts.withDefaults(),
+		entries: make(map[string]*entry),
+	}
+}
+
+// Discover returns the address of a random instance of the service.
+func (d *Discoverer) Discover(name string) (string, error) {
+	instances, err := d.DiscoverInstances(name)
+	if err != nil {
+		return "", err
+	}
+	return instances[rand.Intn(len(instances))].Addr, nil
+}
+
+func (d *Discoverer) DiscoverAll(name string) ([]string, error) {
+	instances, err := d.DiscoverInstances(name)
+	if err != nil {
+		return nil, err
+	}
+	addrs := make([]string, len(instances))
+	for i, inst := range instances {
+		addrs[i] = inst.Addr
+	}
+	return addrs, nil
+}
+
+func (d *Discoverer) DiscoverInstances(name string) ([]discovery.Instance, error) {
+	d.mu.Lock()
+	e, ok := d.entries[name]
+	if !ok {
+		e = &entry{}
+		d.entries[name] = e
+	}
+	e.lastUsed = time.Now()
+	if e.expires.After(e.lastUsed) {
+		defer d.mu.Unlock()
+		return e.instances, e.err
+	}
+	if e.loading == nil {
+		d.load(name, e)
+	} else {
+		// Another goroutine is already looking up the name.
+		loading := e.loading
+		d.mu.Unlock()
+		<-loading
+		d.mu.Lock()
+	}
+	defer d.mu.Unlock()
+	return e.instances, e.err
+}
+
+// Close stops the background refreshes.
+func (d *Discoverer) Close() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.closed = true
+	for _, e := range d.entries {
+		if e.refresh != nil {
+			e.refresh.Stop()
+		}
+	}
+}
+
+// load looks up the name and stores the results in e. It has to be called
+// while holding d.mu and it unlocks d.mu during the lookup.
+func (d *Discoverer) load(name string, e *entry) {
+	loading := make(chan struct{})
+	e.loading = loading
+	d.mu.Unlock()
+	instances, ttl, err := d.lookup(name)
+	d.mu.Lock()
+	e.loading = nil
+	close(loading)
+
+	now := time.Now()
+	switch {
+	case err == nil:
+		e.instances, e.err = instances, nil
+	case e.instances != nil && err != discovery.NotFoundError:
+		// Keeping the previous results is better than failing because of a
+		// temporary network or server error.
+		ttl = d.opts.NegativeTTL
+	default:
+		e.instances, e.err = nil, err
+		e.expires = now.Add(d.opts.NegativeTTL)
+		return
+	}
+	e.expires = now.Add(ttl)
+	if !d.closed {
+		// The refresh starts a bit earlier than the expiry to give it time to
+		// finish.
+		e.refresh = time.AfterFunc(ttl*9/10, func() {
+			d.refresh(name, e)
+		})
+	}
+}
+
+func (d *Discoverer) refresh(name string, e *entry) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	e.refresh = nil
+	if d.closed || e.loading != nil {
+		return
+	}
+	if time.Now().Sub(e.lastUsed) > d.opts.IdleTimeout {
+		delete(d.entries, name)
+		return
+	}
+	d.load(name, e)
+}
+
+// lookup resolves the name of a service into instances.
+func (d *Discoverer) lookup(service string) ([]discovery.Instance, time.Duration, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), d.opts.Timeout)
+	defer cancel()
+	name := fqdn(d.opts.Name(service))
+
+	var instances []discovery.Instance
+	var ttl time.Duration
+	if d.opts.Mode == A {
+		ips, ipTTL, err := d.lookupIP(ctx, name, nil)
+		if err != nil {
+			return nil, 0, err
+		}
+		port := strconv.Itoa(d.opts.Port)
+		for _, ip := range ips {
+			instances = append(instances, discovery.Instance{
+				Addr: net.JoinHostPort(ip.String(), port),
+			})
+		}
+		ttl = ipTTL
+	} else {
+		var err error
+		instances, ttl, err = d.lookupSRV(ctx, name)
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+
+	if ttl < d.opts.MinTTL {
+		ttl = d.opts.MinTTL
+	}
+	if ttl > d.opts.MaxTTL {
+		ttl = d.opts.MaxTTL
+	}
+	return instances, ttl, nil
+}
+
+func (d *Discoverer) lookupSRV(ctx context.Context, name string) ([]discovery.Instance, time.Duration, error) {
+	msg, err := d.query(ctx, name, dnsmessage.TypeSRV)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var records []*dnsmessage.SRVResource
+	ttl := time.Duration(-1)
+	for _, r := range msg.Answers {
+		srv, ok := r.Body.(*dnsmessage.SRVResource)
+		if !ok {
+			continue
+		}
+		if len(records) != 0 && srv.Priority > records[0].Priority {
+			continue
+		}
+		if len(records) != 0 && srv.Priority < records[0].Priority {
+			records = records[:0]
+		}
+		records = append(records, srv)
+		ttl = minTTL(ttl, r.Header.TTL)
+	}
+	if len(records) == 0 {
+		return nil, 0, discovery.NotFoundError
+	}
+
+	var instances []discovery.Instance
+	for _, srv := range records {
+		target := srv.Target.String()
+		ips, ipTTL, err := d.lookupIP(ctx, target, msg.Additionals)
+		if err != nil {
+			return nil, 0, fmt.Errorf("error resolving SRV target %v: %v", target, err)
+		}
+		if ipTTL < ttl {
+			ttl = ipTTL
+		}
+		port := strconv.Itoa(int(srv.Port))
+		for _, ip := range ips {
+			instances = append(instances, discovery.Instance{
+				Addr:   net.JoinHostPort(ip.String(), port),
+				Weight: int(srv.Weight),
+			})
+		}
+	}
+	return instances, ttl, nil
+}
+
+// lookupIP returns the addresses of the A and AAAA records of the name. It
+// looks up the name only if additionals doesn't contain its records.
+func (d *Discoverer) lookupIP(ctx context.Context, name string,
+	additionals []dnsmessage.Resource) ([]net.IP, time.Duration, error) {
+	ips, ttl := ipRecords(name, additionals)
+	if len(ips) != 0 {
+		return ips, ttl, nil
+	}
+
+	var firstErr error
+	for _, qtype := range []dnsmessage.Type{dnsmessage.TypeA, dnsmessage.TypeAAAA} {
+		msg, err := d.query(ctx, name, qtype)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		// The answers can contain a CNAME chain so the records of all names
+		// are used.
+		qips, qttl := ipRecords("", msg.Answers)
+		if len(qips) != 0 && (ttl < 0 || qttl < ttl) {
+			ttl = qttl
+		}
+		ips = append(ips, qips...)
+	}
+	if len(ips) == 0 {
+		if firstErr == nil {
+			firstErr = discovery.NotFoundError
+		}
+		return nil, 0, firstErr
+	}
+	return ips, ttl, nil
+}
+
+// ipRecords returns the addresses and the lowest TTL of the A and AAAA
+// records of the given name. It returns the records of all names if name is
+// empty. The TTL is -1 if there are no records.
+func ipRecords(name string, resources []dnsmessage.Resource) ([]net.IP, time.Duration) {
+	var ips []net.IP
+	ttl := time.Duration(-1)
+	for _, r := range resources {
+		if name != "" && !strings.EqualFold(r.Header.Name.String(), name) {
+			continue
+		}
+		switch body := r.Body.(type) {
+		case *dnsmessage.AResource:
+			ips = append(ips, net.IP(body.A[:]))
+		case *dnsmessage.AAAAResource:
+			ips = append(ips, net.IP(body.AAAA[:]))
+		default:
+			continue
+		}
+		ttl = minTTL(ttl, r.Header.TTL)
+	}
+	return ips, ttl
+}
+
+// query sends a query and converts the error responses into errors.
+func (d *Discoverer) query(ctx context.Context, name string, qtype dnsmessage.Type) (*dnsmessage.Message, error) {
+	msg, err := d.opts.Resolver.Query(ctx, name, qtype)
+	if err != nil {
+		return nil, err
+	}
+	switch msg.RCode {
+	case dnsmessage.RCodeSuccess:
+		return msg, nil
+	case dnsmessage.RCodeNameError:
+		return nil, discovery.NotFoundError
+	default:
+		return nil, fmt.Errorf("%v %v query failed: %v", name, qtype, msg.RCode)
+	}
+}
+
+// minTTL returns the lower of ttl and the TTL of a record in seconds. A
+// negative ttl means that there is no TTL yet.
+func minTTL(ttl time.Duration, seconds uint32) time.Duration {
+	d := time.Duration(seconds) * time.Second
+	if ttl < 0 || d < ttl {
+		return d
+	}
+	return ttl
+}
+
+func fqdn(name string) string {
+	if strings.HasSuffix(name, ".") {
+		return name
+	}
+	return name + "."
+}
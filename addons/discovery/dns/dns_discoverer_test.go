@@ -0,0 +1,339 @@
+package dns
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pasztorpisti/nano/addons/discovery"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// fakeServer is a DNS server that answers the queries over UDP and TCP from
+// a predefined set of records.
+type fakeServer struct {
+	addr string
+	udp  net.PacketConn
+	tcp  net.Listener
+
+	mu      sync.Mutex
+	records map[dnsmessage.Question][]dnsmessage.Resource
+	// additionals are sent with the answers of the SRV queries.
+	additionals []dnsmessage.Resource
+	// truncate makes the server send truncated responses over UDP.
+	truncate bool
+	queries  int
+}
+
+func newFakeServer(t *testing.T) *fakeServer {
+	tcp, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening on tcp :: %v", err)
+	}
+	udp, err := net.ListenPacket("udp", tcp.Addr().String())
+	if err != nil {
+		tcp.Close()
+		t.Fatalf("error listening on udp :: %v", err)
+	}
+	s := &fakeServer{
+		addr:    tcp.Addr().String(),
+		udp:     udp,
+		tcp:     tcp,
+		records: make(map[dnsmessage.Question][]dnsmessage.Resource),
+	}
+	go s.serveUDP()
+	go s.serveTCP()
+	return s
+}
+
+func (s *fakeServer) Close() {
+	s.udp.Close()
+	s.tcp.Close()
+}
+
+func (s *fakeServer) set(name string, qtype dnsmessage.Type, ttl uint32, bodies ...dnsmessage.ResourceBody) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	q := dnsmessage.Question{Name: dnsmessage.MustNewName(name), Type: qtype, Class: dnsmessage.ClassINET}
+	var resources []dnsmessage.Resource
+	for _, body := range bodies {
+		resources = append(resources, dnsmessage.Resource{
+			Header: dnsmessage.ResourceHeader{Name: q.Name, Type: qtype, Class: q.Class, TTL: ttl},
+			Body:   body,
+		})
+	}
+	s.records[q] = resources
+}
+
+func (s *fakeServer) numQueries() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.queries
+}
+
+func (s *fakeServer) respond(req []byte, udp bool) []byte {
+	var msg dnsmessage.Message
+	if err := msg.Unpack(req); err != nil || len(msg.Questions) != 1 {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.queries++
+
+	q := msg.Questions[0]
+	msg.Response = true
+	if udp && s.truncate {
+		msg.Truncated = true
+	} else if answers, ok := s.records[q]; ok {
+		msg.Answers = answers
+		if q.Type == dnsmessage.TypeSRV {
+			msg.Additionals = s.additionals
+		}
+	} else {
+		msg.RCode = dnsmessage.RCodeNameError
+	}
+	resp, _ := msg.Pack()
+	return resp
+}
+
+func (s *fakeServer) serveUDP() {
+	buf := make([]byte, 512)
+	for {
+		n, addr, err := s.udp.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		if resp := s.respond(buf[:n], true); resp != nil {
+			s.udp.WriteTo(resp, addr)
+		}
+	}
+}
+
+func (s *fakeServer) serveTCP() {
+	for {
+		conn, err := s.tcp.Accept()
+		if err != nil {
+			return
+		}
+		var l [2]byte
+		if _, err := io.ReadFull(conn, l[:]); err == nil {
+			req := make([]byte, binary.BigEndian.Uint16(l[:]))
+			if _, err := io.ReadFull(conn, req); err == nil {
+				resp := s.respond(req, false)
+				binary.BigEndian.PutUint16(l[:], uint16(len(resp)))
+				conn.Write(append(l[:], resp...))
+			}
+		}
+		conn.Close()
+	}
+}
+
+func a(ip string) *dnsmessage.AResource {
+	r := &dnsmessage.AResource{}
+	copy(r.A[:], net.ParseIP(ip).To4())
+	return r
+}
+
+func srv(priority, weight, port uint16, target string) *dnsmessage.SRVResource {
+	return &dnsmessage.SRVResource{
+		Priority: priority,
+		Weight:   weight,
+		Port:     port,
+		Target:   dnsmessage.MustNewName(target),
+	}
+}
+
+func TestDiscoverer_SRV(t *testing.T) {
+	s := newFakeServer(t)
+	defer s.Close()
+	s.set("_http._tcp.svc.", dnsmessage.TypeSRV, 60,
+		srv(1, 10, 8000, "a.svc."),
+		srv(1, 20, 8001, "b.svc."),
+		srv(2, 10, 8002, "backup.svc."))
+	s.set("a.svc.", dnsmessage.TypeA, 60, a("10.0.0.1"))
+	s.set("b.svc.", dnsmessage.TypeA, 60, a("10.0.0.2"), a("10.0.0.3"))
+
+	d := New(Options{
+		Name:     func(service string) string { return "_http._tcp." + service },
+		Resolver: NewResolver(s.addr),
+	})
+	defer d.Close()
+
+	instances, err := d.DiscoverInstances("svc")
+	if err != nil {
+		t.Fatalf("unexpected error :: %v", err)
+	}
+	sort.Slice(instances, func(i, j int) bool { return instances[i].Addr < instances[j].Addr })
+	want := []discovery.Instance{
+		{Addr: "10.0.0.1:8000", Weight: 10},
+		{Addr: "10.0.0.2:8001", Weight: 20},
+		{Addr: "10.0.0.3:8001", Weight: 20},
+	}
+	if !reflect.DeepEqual(instances, want) {
+		t.Errorf("instances == %v, want %v", instances, want)
+	}
+}
+
+func TestDiscoverer_SRV_Additionals(t *testing.T) {
+	s := newFakeServer(t)
+	defer s.Close()
+	s.set("svc.", dnsmessage.TypeSRV, 60, srv(1, 0, 8000, "a.svc."))
+	s.mu.Lock()
+	s.additionals = []dnsmessage.Resource{{
+		Header: dnsmessage.ResourceHeader{
+			Name:  dnsmessage.MustNewName("a.svc."),
+			Type:  dnsmessage.TypeA,
+			Class: dnsmessage.ClassINET,
+			TTL:   60,
+		},
+		Body: a("10.0.0.1"),
+	}}
+	s.mu.Unlock()
+
+	d := New(Options{Resolver: NewResolver(s.addr)})
+	defer d.Close()
+
+	addr, err := d.Discover("svc")
+	if err != nil {
+		t.Fatalf("unexpected error :: %v", err)
+	}
+	if addr != "10.0.0.1:8000" {
+		t.Errorf("addr == %q, want %q", addr, "10.0.0.1:8000")
+	}
+	if n := s.numQueries(); n != 1 {
+		t.Errorf("%v queries, want 1", n)
+	}
+}
+
+func TestDiscoverer_A_Cache(t *testing.T) {
+	s := newFakeServer(t)
+	defer s.Close()
+	s.set("svc.", dnsmessage.TypeA, 60, a("10.0.0.1"), a("10.0.0.2"))
+
+	d := New(Options{Mode: A, Port: 80, Resolver: NewResolver(s.addr)})
+	defer d.Close()
+
+	for i := 0; i < 3; i++ {
+		addrs, err := d.DiscoverAll("svc")
+		if err != nil {
+			t.Fatalf("unexpected error :: %v", err)
+		}
+		sort.Strings(addrs)
+		if want := []string{"10.0.0.1:80", "10.0.0.2:80"}; !reflect.DeepEqual(addrs, want) {
+			t.Errorf("addrs == %v, want %v", addrs, want)
+		}
+	}
+	// An A and an AAAA query.
+	if n := s.numQueries(); n != 2 {
+		t.Errorf("%v queries, want 2", n)
+	}
+}
+
+func TestDiscoverer_Negative_Cache(t *testing.T) {
+	s := newFakeServer(t)
+	defer s.Close()
+
+	d := New(Options{
+		Resolver:    NewResolver(s.addr),
+		NegativeTTL: time.Hour,
+	})
+	defer d.Close()
+
+	for i := 0; i < 3; i++ {
+		if _, err := d.Discover("svc"); err != discovery.NotFoundError {
+			t.Errorf("err == %v, want %v", err, discovery.NotFoundError)
+		}
+	}
+	if n := s.numQueries(); n != 1 {
+		t.Errorf("%v queries, want 1", n)
+	}
+}
+
+func TestDiscoverer_Background_Refresh(t *testing.T) {
+	s := newFakeServer(t)
+	defer s.Close()
+	s.set("svc.", dnsmessage.TypeA, 0, a("10.0.0.1"))
+
+	d := New(Options{
+		Mode:     A,
+		Port:     80,
+		Resolver: NewResolver(s.addr),
+		MinTTL:   50 * time.Millisecond,
+	})
+	defer d.Close()
+
+	if _, err := d.Discover("svc"); err != nil {
+		t.Fatalf("unexpected error :: %v", err)
+	}
+	s.set("svc.", dnsmessage.TypeA, 0, a("10.0.0.2"))
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		// The refresh happens before the expiry so Discover doesn't have to
+		// wait for a lookup.
+		d.mu.Lock()
+		instances := d.entries["svc"].instances
+		d.mu.Unlock()
+		if len(instances) == 1 && instances[0].Addr == "10.0.0.2:80" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("the results haven't been refreshed: %v", instances)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestDiscoverer_Keeps_Results_On_Error(t *testing.T) {
+	s := newFakeServer(t)
+	s.set("svc.", dnsmessage.TypeA, 0, a("10.0.0.1"))
+
+	d := New(Options{
+		Mode:     A,
+		Port:     80,
+		Resolver: NewResolver(s.addr),
+		Timeout:  50 * time.Millisecond,
+		MinTTL:   20 * time.Millisecond,
+	})
+	defer d.Close()
+
+	if _, err := d.Discover("svc"); err != nil {
+		t.Fatalf("unexpected error :: %v", err)
+	}
+	// The lookups time out after closing the server.
+	s.Close()
+	time.Sleep(50 * time.Millisecond)
+	addr, err := d.Discover("svc")
+	if err != nil {
+		t.Fatalf("unexpected error :: %v", err)
+	}
+	if addr != "10.0.0.1:80" {
+		t.Errorf("addr == %q, want %q", addr, "10.0.0.1:80")
+	}
+}
+
+func TestResolver_TCP_Fallback(t *testing.T) {
+	s := newFakeServer(t)
+	defer s.Close()
+	s.set("svc.", dnsmessage.TypeA, 60, a("10.0.0.1"))
+	s.mu.Lock()
+	s.truncate = true
+	s.mu.Unlock()
+
+	msg, err := NewResolver(s.addr).Query(context.Background(), "svc.", dnsmessage.TypeA)
+	if err != nil {
+		t.Fatalf("unexpected error :: %v", err)
+	}
+	if len(msg.Answers) != 1 || msg.Truncated {
+		t.Errorf("unexpected response: %v", msg.GoString())
+	}
+	if n := s.numQueries(); n != 2 {
+		t.Errorf("%v queries, want 2", n)
+	}
+}
@@ -0,0 +1,155 @@
+package dns
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"os"
+	"strings"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// Resolver sends DNS queries. The Discoverer uses it instead of the net
+// package because the latter doesn't return the TTL of the records.
+type Resolver interface {
+	// Query sends a query of the given type and returns the response. The
+	// name is fully qualified (it ends with a dot). A response with a
+	// non-success RCode isn't an error.
+	Query(ctx context.Context, name string, qtype dnsmessage.Type) (*dnsmessage.Message, error)
+}
+
+// NewResolver returns a Resolver that sends the queries to the DNS server at
+// the given "host:port" address over UDP and retries the truncated responses
+// over TCP.
+func NewResolver(addr string) Resolver {
+	return &resolver{addr: addr}
+}
+
+// ResolvConfPath is the file from which DefaultResolver reads the address of
+// the DNS server.
+var ResolvConfPath = "/etc/resolv.conf"
+
+// DefaultResolver returns a Resolver that uses the first nameserver of
+// ResolvConfPath or "127.0.0.1:53" if it can't be found.
+func DefaultResolver() Resolver {
+	addr := "127.0.0.1:53"
+	if f, err := os.Open(ResolvConfPath); err == nil {
+		defer f.Close()
+		s := bufio.NewScanner(f)
+		for s.Scan() {
+			fields := strings.Fields(s.Text())
+			if len(fields) >= 2 && fields[0] == "nameserver" {
+				addr = net.JoinHostPort(fields[1], "53")
+				break
+			}
+		}
+	}
+	return NewResolver(addr)
+}
+
+type resolver struct {
+	addr string
+}
+
+var errMismatch = errors.New("response doesn't match the query")
+
+func (p *resolver) Query(ctx context.Context, name string, qtype dnsmessage.Type) (*dnsmessage.Message, error) {
+	n, err := dnsmessage.NewName(name)
+	if err != nil {
+		return nil, err
+	}
+	q := dnsmessage.Message{
+		Header: dnsmessage.Header{
+			ID:               uint16(rand.Uint32()),
+			RecursionDesired: true,
+		},
+		Questions: []dnsmessage.Question{{
+			Name:  n,
+			Type:  qtype,
+			Class: dnsmessage.ClassINET,
+		}},
+	}
+	req, err := q.Pack()
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := p.exchange(ctx, "udp", req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Truncated {
+		if resp, err = p.exchange(ctx, "tcp", req); err != nil {
+			return nil, err
+		}
+	}
+	if resp.ID != q.ID || len(resp.Questions) != 1 ||
+		!strings.EqualFold(resp.Questions[0].Name.String(), name) ||
+		resp.Questions[0].Type != qtype {
+		return nil, errMismatch
+	}
+	return resp, nil
+}
+
+func (p *resolver) exchange(ctx context.Context, network string, req []byte) (*dnsmessage.Message, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, network, p.addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	// Closing the connection interrupts the blocking reads and writes when
+	// the ctx is canceled.
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-stop:
+		}
+	}()
+
+	var buf []byte
+	if network == "tcp" {
+		// The messages are prefixed with their length over TCP.
+		msg := make([]byte, 2+len(req))
+		binary.BigEndian.PutUint16(msg, uint16(len(req)))
+		copy(msg[2:], req)
+		if _, err := conn.Write(msg); err != nil {
+			return nil, err
+		}
+		var l [2]byte
+		if _, err := io.ReadFull(conn, l[:]); err != nil {
+			return nil, err
+		}
+		buf = make([]byte, binary.BigEndian.Uint16(l[:]))
+		if _, err := io.ReadFull(conn, buf); err != nil {
+			return nil, err
+		}
+	} else {
+		if _, err := conn.Write(req); err != nil {
+			return nil, err
+		}
+		buf = make([]byte, 4096)
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		buf = buf[:n]
+	}
+
+	resp := &dnsmessage.Message{}
+	if err := resp.Unpack(buf); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
@@ -0,0 +1,268 @@
+/*
+Package file implements a discovery.Discoverer that reads the instances of the
+services from a JSON or YAML file and reloads it when it changes.
+
+The file maps service names to lists of instances. An instance is either a
+"host:port" string or an object with addr, scheme, path_prefix, weight and
+metadata fields:
+
+	{
+		"svc1": ["localhost:8001", "localhost:8002"],
+		"svc2": [{"addr": "localhost:8003", "weight": 2, "metadata": {"zone": "a"}}],
+		"svc3": [{"addr": "gateway:443", "scheme": "https", "path_prefix": "/svc3"}]
+	}
+
+Files with .yaml or .yml extension are parsed as YAML, others as JSON.
+
+The file is polled for changes. The new mapping replaces the old one
+atomically after parsing the whole file. If the changed file can't be read or
+parsed then the error is reported and the last good mapping stays in use.
+*/
+package file
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pasztorpisti/nano/addons/discovery"
+	"github.com/pasztorpisti/nano/addons/log"
+	"gopkg.in/yaml.v3"
+)
+
+// Options controls how a Discoverer watches its file.
+type Options struct {
+	// PollInterval is the time between two checks of the modification time
+	// and size of the file. Defaults to 1 second.
+	PollInterval time.Duration
+	// OnError is called when the changed file can't be loaded. Defaults to
+	// logging the error.
+	OnError func(path string, err error)
+	// OnReload is called after loading the changed file. Optional.
+	OnReload func(path string)
+}
+
+func (o Options) withDefaults() Options {
+	if o.PollInterval <= 0 {
+		o.PollInterval = time.Second
+	}
+	if o.OnError == nil {
+		o.OnError = func(path string, err error) {
+			log.Err(nil, err, "error loading discovery file "+path)
+		}
+	}
+	return o
+}
+
+type mapping map[string][]discovery.Instance
+
+// Discoverer implements the discovery.InstanceDiscoverer interface.
+type Discoverer struct {
+	path string
+	opts Options
+
+	// m holds the current mapping.
+	m atomic.Value
+
+	// mu serializes the reloads.
+	mu      sync.Mutex
+	modTime time.Time
+	size    int64
+	// badModTime and badSize identify the last version of the file that
+	// couldn't be loaded. The errors are reported only once per version.
+	badModTime time.Time
+	badSize    int64
+	// missing is true if the last Stat of the file has failed.
+	missing bool
+
+	stop      chan struct{}
+	stopped   chan struct{}
+	closeOnce sync.Once
+}
+
+// New loads the file and starts watching it. It returns an error if the file
+// can't be loaded. Close stops watching the file.
+func New(path string, opts Options) (*Discoverer, error) {
+	d := &Discoverer{
+		path:    path,
+		opts:    opts.withDefaults(),
+		stop:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	if _, err := d.reload(true); err != nil {
+		return nil, err
+	}
+	go d.watch()
+	return d, nil
+}
+
+// Discover returns the address of the first instance of the service.
+func (d *Discoverer) Discover(name string) (string, error) {
+	instances, err := d.DiscoverInstances(name)
+	if err != nil {
+		return "", err
+	}
+	return instances[0].Addr, nil
+}
+
+func (d *Discoverer) DiscoverAll(name string) ([]string, error) {
+	instances, err := d.DiscoverInstances(name)
+	if err != nil {
+		return nil, err
+	}
+	addrs := make([]string, len(instances))
+	for i, inst := range instances {
+		addrs[i] = inst.Addr
+	}
+	return addrs, nil
+}
+
+func (d *Discoverer) DiscoverInstances(name string) ([]discovery.Instance, error) {
+	if instances := d.m.Load().(mapping)[name]; len(instances) != 0 {
+		return instances, nil
+	}
+	return nil, discovery.NotFoundError
+}
+
+// Reload loads the file if it has changed since the last load attempt. It
+// returns and reports an error only once for each version of the file that
+// can't be loaded. The watcher calls it periodically but it can also be
+// called explicitly, e.g.: on SIGHUP.
+func (d *Discoverer) Reload() error {
+	reloaded, err := d.reload(false)
+	if err != nil {
+		d.opts.OnError(d.path, err)
+		return err
+	}
+	if reloaded && d.opts.OnReload != nil {
+		d.opts.OnReload(d.path)
+	}
+	return nil
+}
+
+// Close stops watching the file.
+func (d *Discoverer) Close() {
+	d.closeOnce.Do(func() {
+		close(d.stop)
+	})
+	<-d.stopped
+}
+
+func (d *Discoverer) watch() {
+	defer close(d.stopped)
+	t := time.NewTicker(d.opts.PollInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			d.Reload()
+		case <-d.stop:
+			return
+		}
+	}
+}
+
+func (d *Discoverer) reload(force bool) (reloaded bool, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	fi, err := os.Stat(d.path)
+	if err != nil {
+		if d.missing && !force {
+			return false, nil
+		}
+		d.missing = true
+		return false, err
+	}
+	d.missing = false
+	if !force && (fi.ModTime().Equal(d.modTime) && fi.Size() == d.size ||
+		fi.ModTime().Equal(d.badModTime) && fi.Size() == d.badSize) {
+		return false, nil
+	}
+	data, err := ioutil.ReadFile(d.path)
+	if err == nil {
+		var m mapping
+		if m, err = parse(d.path, data); err == nil {
+			d.m.Store(m)
+			d.modTime, d.size = fi.ModTime(), fi.Size()
+			return true, nil
+		}
+	}
+	d.badModTime, d.badSize = fi.ModTime(), fi.Size()
+	return false, err
+}
+
+func parse(path string, data []byte) (mapping, error) {
+	var file map[string][]instance
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		if err := yaml.Unmarshal(data, &file); err != nil {
+			return nil, err
+		}
+	default:
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&file); err != nil {
+			return nil, err
+		}
+	}
+
+	m := make(mapping, len(file))
+	for name, instances := range file {
+		for _, inst := range instances {
+			if inst.Addr == "" {
+				return nil, errors.New("service " + name + " has an instance without addr")
+			}
+			m[name] = append(m[name], discovery.Instance(inst))
+		}
+	}
+	return m, nil
+}
+
+// instance is a discovery.Instance in the file. It can be unmarshaled from
+// an object or from a plain address string.
+type instance discovery.Instance
+
+type instanceObject struct {
+	Addr       string            `json:"addr" yaml:"addr"`
+	Scheme     string            `json:"scheme" yaml:"scheme"`
+	PathPrefix string            `json:"path_prefix" yaml:"path_prefix"`
+	Weight     int               `json:"weight" yaml:"weight"`
+	Metadata   map[string]string `json:"metadata" yaml:"metadata"`
+}
+
+func (p *instance) UnmarshalJSON(data []byte) error {
+	var addr string
+	if err := json.Unmarshal(data, &addr); err == nil {
+		*p = instance{Addr: addr}
+		return nil
+	}
+	var obj instanceObject
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&obj); err != nil {
+		return err
+	}
+	*p = instance(obj)
+	return nil
+}
+
+func (p *instance) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		*p = instance{Addr: node.Value}
+		return nil
+	}
+	var obj instanceObject
+	if err := node.Decode(&obj); err != nil {
+		return err
+	}
+	*p = instance(obj)
+	return nil
+}
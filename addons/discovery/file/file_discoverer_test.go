@@ -0,0 +1,208 @@
+package file
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/pasztorpisti/nano/addons/discovery"
+)
+
+// writeFile writes the file and sets a modification time that differs from
+// the previous one even on file systems with coarse timestamps.
+func writeFile(t *testing.T, path, content string) {
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("error writing file :: %v", err)
+	}
+	modTime := time.Unix(0, 0)
+	if fi, err := os.Stat(path); err == nil {
+		modTime = fi.ModTime()
+	}
+	modTime = modTime.Add(time.Duration(len(content)+1) * time.Second)
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatalf("error setting modification time :: %v", err)
+	}
+}
+
+func tempFile(t *testing.T, name, content string) (path string, cleanup func()) {
+	dir, err := ioutil.TempDir("", "file_discoverer")
+	if err != nil {
+		t.Fatalf("error creating temp dir :: %v", err)
+	}
+	path = filepath.Join(dir, name)
+	writeFile(t, path, content)
+	return path, func() { os.RemoveAll(dir) }
+}
+
+func TestDiscoverer_JSON(t *testing.T) {
+	path, cleanup := tempFile(t, "services.json", `{
+		"svc1": ["localhost:8001", "localhost:8002"],
+		"svc2": [{"addr": "localhost:8003", "weight": 2, "metadata": {"zone": "a"}}]
+	}`)
+	defer cleanup()
+
+	d, err := New(path, Options{PollInterval: time.Hour})
+	if err != nil {
+		t.Fatalf("unexpected error :: %v", err)
+	}
+	defer d.Close()
+
+	addrs, err := d.DiscoverAll("svc1")
+	if err != nil {
+		t.Errorf("unexpected error :: %v", err)
+	}
+	if want := []string{"localhost:8001", "localhost:8002"}; !reflect.DeepEqual(addrs, want) {
+		t.Errorf("addrs == %v, want %v", addrs, want)
+	}
+
+	instances, err := d.DiscoverInstances("svc2")
+	if err != nil {
+		t.Errorf("unexpected error :: %v", err)
+	}
+	want := []discovery.Instance{{
+		Addr:     "localhost:8003",
+		Weight:   2,
+		Metadata: map[string]string{"zone": "a"},
+	}}
+	if !reflect.DeepEqual(instances, want) {
+		t.Errorf("instances == %v, want %v", instances, want)
+	}
+
+	if _, err := d.Discover("svc3"); err != discovery.NotFoundError {
+		t.Errorf("err == %v, want %v", err, discovery.NotFoundError)
+	}
+}
+
+func TestDiscoverer_YAML(t *testing.T) {
+	path, cleanup := tempFile(t, "services.yaml", `
+svc1:
+  - localhost:8001
+  - addr: localhost:8002
+    weight: 3
+`)
+	defer cleanup()
+
+	d, err := New(path, Options{PollInterval: time.Hour})
+	if err != nil {
+		t.Fatalf("unexpected error :: %v", err)
+	}
+	defer d.Close()
+
+	instances, err := d.DiscoverInstances("svc1")
+	if err != nil {
+		t.Errorf("unexpected error :: %v", err)
+	}
+	want := []discovery.Instance{
+		{Addr: "localhost:8001"},
+		{Addr: "localhost:8002", Weight: 3},
+	}
+	if !reflect.DeepEqual(instances, want) {
+		t.Errorf("instances == %v, want %v", instances, want)
+	}
+}
+
+func TestDiscoverer_Reload(t *testing.T) {
+	path, cleanup := tempFile(t, "services.json", `{"svc": ["localhost:8001"]}`)
+	defer cleanup()
+
+	var loadErr error
+	d, err := New(path, Options{
+		PollInterval: time.Hour,
+		OnError: func(path string, err error) {
+			loadErr = err
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error :: %v", err)
+	}
+	defer d.Close()
+
+	writeFile(t, path, `{"svc": ["localhost:8002"]}`)
+	if err := d.Reload(); err != nil {
+		t.Errorf("unexpected error :: %v", err)
+	}
+	if addr, _ := d.Discover("svc"); addr != "localhost:8002" {
+		t.Errorf("addr == %q, want %q", addr, "localhost:8002")
+	}
+
+	// The last good mapping stays in use if the file is invalid.
+	writeFile(t, path, `{"svc": [`)
+	if err := d.Reload(); err == nil {
+		t.Error("unexpected success")
+	}
+	if loadErr == nil {
+		t.Error("OnError hasn't been called")
+	}
+	if addr, _ := d.Discover("svc"); addr != "localhost:8002" {
+		t.Errorf("addr == %q, want %q", addr, "localhost:8002")
+	}
+}
+
+func TestDiscoverer_Watch(t *testing.T) {
+	path, cleanup := tempFile(t, "services.json", `{"svc": ["localhost:8001"]}`)
+	defer cleanup()
+
+	reloaded := make(chan struct{}, 1)
+	d, err := New(path, Options{
+		PollInterval: time.Millisecond,
+		OnReload: func(path string) {
+			reloaded <- struct{}{}
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error :: %v", err)
+	}
+	defer d.Close()
+
+	writeFile(t, path, `{"svc": ["localhost:8002"]}`)
+	select {
+	case <-reloaded:
+	case <-time.After(time.Second):
+		t.Fatal("the file hasn't been reloaded")
+	}
+	if addr, _ := d.Discover("svc"); addr != "localhost:8002" {
+		t.Errorf("addr == %q, want %q", addr, "localhost:8002")
+	}
+}
+
+func TestNew_Invalid_File(t *testing.T) {
+	path, cleanup := tempFile(t, "services.json", `{"svc": [{"weight": 1}]}`)
+	defer cleanup()
+
+	if _, err := New(path, Options{}); err == nil {
+		t.Error("unexpected success")
+	}
+}
+
+func TestDiscoverer_Reports_Error_Once(t *testing.T) {
+	path, cleanup := tempFile(t, "services.json", `{"svc": ["localhost:8001"]}`)
+	defer cleanup()
+
+	errs := 0
+	d, err := New(path, Options{
+		PollInterval: time.Hour,
+		OnError: func(path string, err error) {
+			errs++
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error :: %v", err)
+	}
+	defer d.Close()
+
+	writeFile(t, path, `{`)
+	d.Reload()
+	d.Reload()
+	os.Remove(path)
+	d.Reload()
+	d.Reload()
+	if errs != 2 {
+		t.Errorf("OnError called %v times, want 2", errs)
+	}
+	if addr, _ := d.Discover("svc"); addr != "localhost:8001" {
+		t.Errorf("addr == %q, want %q", addr, "localhost:8001")
+	}
+}
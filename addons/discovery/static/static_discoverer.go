@@ -13,3 +13,45 @@ func (v Discoverer) Discover(name string) (string, error) {
 	}
 	return "", discovery.NotFoundError
 }
+
+// MultiDiscoverer implements the discovery.MultiDiscoverer interface. It can
+// resolve a predefined set of service names into predefined lists of
+// net.Dial compatible addresses.
+type MultiDiscoverer map[string][]string
+
+// Discover returns the first address of the service.
+func (v MultiDiscoverer) Discover(name string) (string, error) {
+	addrs, err := v.DiscoverAll(name)
+	if err != nil {
+		return "", err
+	}
+	return addrs[0], nil
+}
+
+func (v MultiDiscoverer) DiscoverAll(name string) ([]string, error) {
+	if addrs, ok := v[name]; ok && len(addrs) != 0 {
+		return addrs, nil
+	}
+	return nil, discovery.NotFoundError
+}
+
+// InstanceDiscoverer implements the discovery.InstanceDiscoverer interface.
+// It can resolve a predefined set of service names into predefined lists of
+// instances.
+type InstanceDiscoverer map[string][]discovery.Instance
+
+// Discover returns the address of the first instance of the service.
+func (v InstanceDiscoverer) Discover(name string) (string, error) {
+	instances, err := v.DiscoverInstances(name)
+	if err != nil {
+		return "", err
+	}
+	return instances[0].Addr, nil
+}
+
+func (v InstanceDiscoverer) DiscoverInstances(name string) ([]discovery.Instance, error) {
+	if instances, ok := v[name]; ok && len(instances) != 0 {
+		return instances, nil
+	}
+	return nil, discovery.NotFoundError
+}
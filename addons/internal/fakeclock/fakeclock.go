@@ -0,0 +1,37 @@
+/*
+Package fakeclock provides a manually advanced clock for the tests of the
+addons that accept a Clock func in their options.
+*/
+package fakeclock
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock is a fake clock that moves only when Advance is called. It is safe
+// for concurrent use.
+type Clock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+// New creates a Clock that starts at the Unix epoch.
+func New() *Clock {
+	return &Clock{now: time.Unix(0, 0)}
+}
+
+// Now returns the current time of the clock. It can be used as the Clock
+// option of the addons.
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Advance moves the clock forward by d.
+func (c *Clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
@@ -0,0 +1,395 @@
+/*
+Package loadbalancer implements client-side load balancing across the
+instances of a service returned by a discovery.Discoverer.
+
+A Balancer picks an instance for each request with a pluggable Policy and
+passively ejects the instances that keep failing: after EjectionThreshold
+consecutive failures an instance doesn't receive requests for EjectionTime.
+The ejection time grows with each consecutive ejection of the same instance.
+
+A Balancer implements the http.LoadBalancer interface of the http transport:
+
+	opts := &http.ClientOptions{
+		NewLoadBalancer: func(service string) http.LoadBalancer {
+			return loadbalancer.New(loadbalancer.Options{})
+		},
+	}
+*/
+package loadbalancer
+
+import (
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/pasztorpisti/nano/addons/discovery"
+	"github.com/pasztorpisti/nano/addons/transport/http/config"
+)
+
+// Outcome is the result of a request sent to the picked instance.
+type Outcome = config.Outcome
+
+const (
+	Success = config.OutcomeSuccess
+	Failure = config.OutcomeFailure
+	// Ignored means that the request doesn't tell anything about the health
+	// of the instance, e.g.: the caller has canceled it. It only ends the
+	// outstanding request of the instance.
+	Ignored = config.OutcomeIgnored
+)
+
+// Candidate is an instance that can receive the next request.
+type Candidate struct {
+	discovery.Instance
+	// Outstanding is the number of requests sent to the instance that
+	// haven't finished yet.
+	Outstanding int
+}
+
+// weight returns the weight of the candidate, zero is treated as 1.
+func (c *Candidate) weight() int {
+	if c.Weight <= 0 {
+		return 1
+	}
+	return c.Weight
+}
+
+// load returns the outstanding requests of the candidate relative to its
+// weight.
+func (c *Candidate) load() float64 {
+	return float64(c.Outstanding) / float64(c.weight())
+}
+
+// Policy chooses the instance of the next request.
+type Policy interface {
+	// Pick receives a non-empty list of candidates and returns the index of
+	// the chosen one. It can be called concurrently.
+	Pick(candidates []Candidate) int
+}
+
+// PolicyFunc adapts a function to the Policy interface.
+type PolicyFunc func(candidates []Candidate) int
+
+func (f PolicyFunc) Pick(candidates []Candidate) int {
+	return f(candidates)
+}
+
+// RoundRobin returns a Policy that picks the candidates in turn. Candidates
+// with higher weight are picked proportionally more often.
+func RoundRobin() Policy {
+	var mu sync.Mutex
+	var next uint64
+	return PolicyFunc(func(candidates []Candidate) int {
+		total := 0
+		for i := range candidates {
+			total += candidates[i].weight()
+		}
+		mu.Lock()
+		n := int(next % uint64(total))
+		next++
+		mu.Unlock()
+
+		for i := range candidates {
+			n -= candidates[i].weight()
+			if n < 0 {
+				return i
+			}
+		}
+		return len(candidates) - 1
+	})
+}
+
+// LeastOutstanding returns a Policy that picks the candidate with the fewest
+// outstanding requests relative to its weight. Ties are broken randomly.
+func LeastOutstanding() Policy {
+	return PolicyFunc(func(candidates []Candidate) int {
+		best, ties := 0, 1
+		for i := 1; i < len(candidates); i++ {
+			switch l, bl := candidates[i].load(), candidates[best].load(); {
+			case l < bl:
+				best, ties = i, 1
+			case l == bl:
+				// Reservoir sampling keeps each tied candidate with the same
+				// probability.
+				ties++
+				if intn(ties) == 0 {
+					best = i
+				}
+			}
+		}
+		return best
+	})
+}
+
+// PowerOfTwoChoices returns a Policy that picks two random candidates (with
+// probability proportional to their weight) and chooses the one with fewer
+// outstanding requests relative to its weight. It is cheaper than
+// LeastOutstanding with lots of instances and avoids herding on the least
+// loaded one when the information about the load is stale.
+func PowerOfTwoChoices() Policy {
+	return PolicyFunc(func(candidates []Candidate) int {
+		a := weightedRandom(candidates)
+		b := weightedRandom(candidates)
+		if candidates[b].load() < candidates[a].load() {
+			return b
+		}
+		return a
+	})
+}
+
+func weightedRandom(candidates []Candidate) int {
+	total := 0
+	for i := range candidates {
+		total += candidates[i].weight()
+	}
+	n := intn(total)
+	for i := range candidates {
+		n -= candidates[i].weight()
+		if n < 0 {
+			return i
+		}
+	}
+	return len(candidates) - 1
+}
+
+// intn returns a random number in [0, n). Tests can replace it.
+var intn = rand.Intn
+
+// Options selects the Policy of a Balancer and tunes the ejection of the
+// failing instances.
+type Options struct {
+	// Policy defaults to RoundRobin().
+	Policy Policy
+
+	// EjectionThreshold is the number of consecutive failures that ejects
+	// an instance. Defaults to 5. Negative value disables ejection.
+	EjectionThreshold int
+	// EjectionTime is the time an instance stays ejected for the first time.
+	// It is multiplied by the number of consecutive ejections of the
+	// instance. Defaults to 30 seconds.
+	EjectionTime time.Duration
+	// MaxEjectionTime defaults to 5 minutes.
+	MaxEjectionTime time.Duration
+	// MaxEjectionPercent is the maximum percentage of the instances that can
+	// be ejected at the same time. Defaults to 50.
+	MaxEjectionPercent int
+
+	// Clock defaults to time.Now.
+	Clock func() time.Time
+}
+
+func (o Options) withDefaults() Options {
+	if o.Policy == nil {
+		o.Policy = RoundRobin()
+	}
+	if o.EjectionThreshold == 0 {
+		o.EjectionThreshold = 5
+	}
+	if o.EjectionTime <= 0 {
+		o.EjectionTime = 30 * time.Second
+	}
+	if o.MaxEjectionTime <= 0 {
+		o.MaxEjectionTime = 5 * time.Minute
+	}
+	if o.MaxEjectionPercent <= 0 || o.MaxEjectionPercent > 100 {
+		o.MaxEjectionPercent = 50
+	}
+	if o.Clock == nil {
+		o.Clock = time.Now
+	}
+	return o
+}
+
+// NoInstanceError is returned by Balancer.Pick when it receives no instances.
+var NoInstanceError = errors.New("no instances")
+
+// Balancer balances the requests across the instances of a single service.
+type Balancer struct {
+	opts Options
+
+	mu        sync.Mutex
+	instances map[string]*instanceState
+}
+
+type instanceState struct {
+	outstanding int
+	failures    int
+	// ejections is the number of consecutive ejections. It is reset by a
+	// success after the ejection.
+	ejections    int
+	ejectedUntil time.Time
+}
+
+// New creates a new Balancer.
+func New(opts Options) *Balancer {
+	return &Balancer{
+		opts:      opts.withDefaults(),
+		instances: make(map[string]*instanceState),
+	}
+}
+
+// Pick chooses an instance from the instances accepted by filter. A nil
+// filter accepts all instances. The instances parameter has to contain all
+// instances of the service even if some of them are filtered out because
+// the Balancer forgets the instances that are missing from it and applies
+// the MaxEjectionPercent to its length.
+//
+// The done func has to be called exactly once with the outcome of the
+// request when it has finished.
+func (b *Balancer) Pick(instances []discovery.Instance, filter func(discovery.Instance) bool) (
+	inst discovery.Instance, done func(Outcome), err error) {
+	return b.PickWith(instances, filter, b.opts.Policy)
+}
+
+// PickWith is the same as Pick but it chooses from the candidates with the
+// given policy instead of the Policy of the Balancer.
+func (b *Balancer) PickWith(instances []discovery.Instance, filter func(discovery.Instance) bool,
+	policy Policy) (inst discovery.Instance, done func(Outcome), err error) {
+	accepted := instances
+	if filter != nil {
+		accepted = make([]discovery.Instance, 0, len(instances))
+		for _, inst := range instances {
+			if filter(inst) {
+				accepted = append(accepted, inst)
+			}
+		}
+	}
+	if len(accepted) == 0 {
+		return discovery.Instance{}, nil, NoInstanceError
+	}
+
+	b.mu.Lock()
+	now := b.opts.Clock()
+	b.prune(instances)
+	candidates := make([]Candidate, 0, len(accepted))
+	for _, inst := range accepted {
+		if !b.state(inst.Addr).ejectedUntil.After(now) {
+			candidates = append(candidates, Candidate{Instance: inst})
+		}
+	}
+	if len(candidates) == 0 {
+		// Sending the request to an ejected instance is better than failing.
+		for _, inst := range accepted {
+			candidates = append(candidates, Candidate{Instance: inst})
+		}
+	}
+	for i := range candidates {
+		candidates[i].Outstanding = b.instances[candidates[i].Addr].outstanding
+	}
+	b.mu.Unlock()
+
+	inst = candidates[policy.Pick(candidates)].Instance
+
+	b.mu.Lock()
+	s := b.state(inst.Addr)
+	s.outstanding++
+	b.mu.Unlock()
+
+	var once sync.Once
+	return inst, func(o Outcome) {
+		once.Do(func() {
+			b.done(s, o, len(instances))
+		})
+	}, nil
+}
+
+func (b *Balancer) done(s *instanceState, o Outcome, numInstances int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	s.outstanding--
+	switch o {
+	case Ignored:
+		return
+	case Success:
+		s.failures = 0
+		if !s.ejectedUntil.After(b.opts.Clock()) {
+			s.ejections = 0
+		}
+		return
+	}
+
+	s.failures++
+	if b.opts.EjectionThreshold < 0 || s.failures < b.opts.EjectionThreshold {
+		return
+	}
+	now := b.opts.Clock()
+	if s.ejectedUntil.After(now) {
+		return
+	}
+	if (b.numEjected(now)+1)*100 > numInstances*b.opts.MaxEjectionPercent {
+		return
+	}
+	s.failures = 0
+	s.ejections++
+	d := time.Duration(s.ejections) * b.opts.EjectionTime
+	if d > b.opts.MaxEjectionTime {
+		d = b.opts.MaxEjectionTime
+	}
+	s.ejectedUntil = now.Add(d)
+}
+
+// Ejected returns the addresses of the currently ejected instances.
+func (b *Balancer) Ejected() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := b.opts.Clock()
+	var addrs []string
+	for addr, s := range b.instances {
+		if s.ejectedUntil.After(now) {
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs
+}
+
+// Load returns the number of the outstanding requests of the instance with
+// the given address and whether the instance is ejected.
+func (b *Balancer) Load(addr string) (outstanding int, ejected bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	s, ok := b.instances[addr]
+	if !ok {
+		return 0, false
+	}
+	return s.outstanding, s.ejectedUntil.After(b.opts.Clock())
+}
+
+// state returns the state of the instance with the given address.
+// The caller has to hold b.mu.
+func (b *Balancer) state(addr string) *instanceState {
+	s, ok := b.instances[addr]
+	if !ok {
+		s = &instanceState{}
+		b.instances[addr] = s
+	}
+	return s
+}
+
+// numEjected has to be called while holding b.mu.
+func (b *Balancer) numEjected(now time.Time) int {
+	n := 0
+	for _, s := range b.instances {
+		if s.ejectedUntil.After(now) {
+			n++
+		}
+	}
+	return n
+}
+
+// prune forgets the instances that have disappeared from the discovery
+// results and have no outstanding requests. The caller has to hold b.mu.
+func (b *Balancer) prune(instances []discovery.Instance) {
+	if len(b.instances) <= 2*len(instances) {
+		return
+	}
+	current := make(map[string]bool, len(instances))
+	for _, inst := range instances {
+		current[inst.Addr] = true
+	}
+	for addr, s := range b.instances {
+		if !current[addr] && s.outstanding == 0 {
+			delete(b.instances, addr)
+		}
+	}
+}
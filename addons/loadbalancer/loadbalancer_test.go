@@ -0,0 +1,230 @@
+package loadbalancer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pasztorpisti/nano/addons/discovery"
+	"github.com/pasztorpisti/nano/addons/internal/fakeclock"
+)
+
+var testInstances = []discovery.Instance{
+	{Addr: "a:80"},
+	{Addr: "b:80", Weight: 2},
+	{Addr: "c:80"},
+	{Addr: "d:80"},
+}
+
+// pick picks an instance and finishes its request.
+func pick(t *testing.T, b *Balancer, o Outcome) string {
+	inst, done, err := b.Pick(testInstances, nil)
+	if err != nil {
+		t.Errorf("unexpected error :: %v", err)
+		t.FailNow()
+	}
+	done(o)
+	return inst.Addr
+}
+
+func TestRoundRobin(t *testing.T) {
+	b := New(Options{Policy: RoundRobin()})
+	var picked []string
+	for i := 0; i < 10; i++ {
+		picked = append(picked, pick(t, b, Success))
+	}
+	want := []string{"a:80", "b:80", "b:80", "c:80", "d:80", "a:80", "b:80", "b:80", "c:80", "d:80"}
+	for i := range want {
+		if picked[i] != want[i] {
+			t.Errorf("picked == %v, want %v", picked, want)
+			break
+		}
+	}
+}
+
+func TestLeastOutstanding(t *testing.T) {
+	b := New(Options{Policy: LeastOutstanding()})
+	counts := make(map[string]int)
+	for i := 0; i < 5; i++ {
+		inst, _, err := b.Pick(testInstances, nil)
+		if err != nil {
+			t.Errorf("unexpected error :: %v", err)
+			t.FailNow()
+		}
+		counts[inst.Addr]++
+	}
+	// b:80 has double weight so it receives two outstanding requests.
+	want := map[string]int{"a:80": 1, "b:80": 2, "c:80": 1, "d:80": 1}
+	for addr, n := range want {
+		if counts[addr] != n {
+			t.Errorf("outstanding requests == %v, want %v", counts, want)
+			break
+		}
+	}
+}
+
+func TestPowerOfTwoChoices(t *testing.T) {
+	origIntn := intn
+	defer func() { intn = origIntn }()
+	// The two random choices are a:80 and c:80.
+	choices := []int{0, 3}
+	intn = func(n int) int {
+		c := choices[0]
+		choices = append(choices[1:], c)
+		return c
+	}
+
+	b := New(Options{Policy: PowerOfTwoChoices()})
+	inst, _, _ := b.Pick(testInstances, nil)
+	if inst.Addr != "a:80" {
+		t.Errorf("first pick == %v, want a:80", inst.Addr)
+	}
+	// a:80 has an outstanding request now.
+	inst, _, _ = b.Pick(testInstances, nil)
+	if inst.Addr != "c:80" {
+		t.Errorf("second pick == %v, want c:80", inst.Addr)
+	}
+}
+
+func TestBalancer_Ejection(t *testing.T) {
+	clock := fakeclock.New()
+	b := New(Options{
+		Clock:             clock.Now,
+		Policy:            PolicyFunc(func(c []Candidate) int { return 0 }),
+		EjectionThreshold: 2,
+		EjectionTime:      time.Second,
+	})
+	pick(t, b, Failure)
+	pick(t, b, Success)
+	pick(t, b, Failure)
+	if addr := pick(t, b, Failure); addr != "a:80" {
+		t.Errorf("picked %v, want a:80", addr)
+	}
+	if addr := pick(t, b, Success); addr != "b:80" {
+		t.Errorf("picked %v after ejecting a:80, want b:80", addr)
+	}
+	if e := b.Ejected(); len(e) != 1 || e[0] != "a:80" {
+		t.Errorf("ejected == %v, want [a:80]", e)
+	}
+	if outstanding, ejected := b.Load("a:80"); !ejected || outstanding != 0 {
+		t.Errorf("Load(a:80) == %v %v, want ejected without outstanding requests",
+			outstanding, ejected)
+	}
+
+	// The ejection time doubles on the second consecutive ejection.
+	clock.Advance(time.Second)
+	pick(t, b, Failure)
+	pick(t, b, Failure)
+	clock.Advance(1500 * time.Millisecond)
+	if addr := pick(t, b, Success); addr != "b:80" {
+		t.Errorf("picked %v, want b:80", addr)
+	}
+	clock.Advance(500 * time.Millisecond)
+	if addr := pick(t, b, Success); addr != "a:80" {
+		t.Errorf("picked %v after the ejection time, want a:80", addr)
+	}
+}
+
+func TestBalancer_Ignored(t *testing.T) {
+	b := New(Options{
+		Policy:            PolicyFunc(func(c []Candidate) int { return 0 }),
+		EjectionThreshold: 2,
+	})
+	// An ignored outcome doesn't reset the consecutive failures.
+	pick(t, b, Failure)
+	pick(t, b, Ignored)
+	pick(t, b, Failure)
+	if e := b.Ejected(); len(e) != 1 || e[0] != "a:80" {
+		t.Errorf("ejected == %v, want [a:80]", e)
+	}
+	if outstanding, _ := b.Load("a:80"); outstanding != 0 {
+		t.Errorf("Load(a:80) == %v, want no outstanding requests", outstanding)
+	}
+}
+
+func TestBalancer_MaxEjectionPercent(t *testing.T) {
+	b := New(Options{
+		Policy:            PolicyFunc(func(c []Candidate) int { return 0 }),
+		EjectionThreshold: 1,
+	})
+	// The default MaxEjectionPercent allows ejecting 2 of the 4 instances.
+	for i := 0; i < 3; i++ {
+		pick(t, b, Failure)
+	}
+	if e := b.Ejected(); len(e) != 2 {
+		t.Errorf("ejected == %v, want 2 instances", e)
+	}
+	if addr := pick(t, b, Success); addr != "c:80" {
+		t.Errorf("picked %v, want c:80", addr)
+	}
+}
+
+func TestBalancer_Load(t *testing.T) {
+	b := New(Options{})
+	_, done, err := b.Pick([]discovery.Instance{{Addr: "a:80"}}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error :: %v", err)
+	}
+	if outstanding, ejected := b.Load("a:80"); outstanding != 1 || ejected {
+		t.Errorf("Load(a:80) == %v %v, want 1 outstanding request", outstanding, ejected)
+	}
+	done(Success)
+	if outstanding, _ := b.Load("a:80"); outstanding != 0 {
+		t.Errorf("Load(a:80) == %v, want no outstanding requests", outstanding)
+	}
+}
+
+func TestBalancer_PickWith(t *testing.T) {
+	b := New(Options{Policy: PolicyFunc(func(c []Candidate) int { return 0 })})
+	last := PolicyFunc(func(c []Candidate) int { return len(c) - 1 })
+	inst, done, err := b.PickWith(testInstances, nil, last)
+	if err != nil {
+		t.Fatalf("unexpected error :: %v", err)
+	}
+	done(Success)
+	if want := testInstances[len(testInstances)-1].Addr; inst.Addr != want {
+		t.Errorf("picked %v, want %v", inst.Addr, want)
+	}
+}
+
+func TestBalancer_No_Instances(t *testing.T) {
+	b := New(Options{})
+	if _, _, err := b.Pick(nil, nil); err != NoInstanceError {
+		t.Errorf("err == %v, want %v", err, NoInstanceError)
+	}
+}
+
+func TestBalancer_Filter(t *testing.T) {
+	b := New(Options{
+		Policy:            PolicyFunc(func(c []Candidate) int { return 0 }),
+		EjectionThreshold: 1,
+	})
+	ab := func(inst discovery.Instance) bool {
+		return inst.Addr == "a:80" || inst.Addr == "b:80"
+	}
+	for i := 0; i < 2; i++ {
+		_, done, err := b.Pick(testInstances, ab)
+		if err != nil {
+			t.Fatalf("unexpected error :: %v", err)
+		}
+		done(Failure)
+	}
+	// The MaxEjectionPercent applies to all instances, not only to the
+	// filtered ones.
+	if e := b.Ejected(); len(e) != 2 {
+		t.Errorf("ejected == %v, want 2 instances", e)
+	}
+	// The filtered instances are picked even if they are ejected.
+	inst, done, err := b.Pick(testInstances, ab)
+	if err != nil {
+		t.Fatalf("unexpected error :: %v", err)
+	}
+	done(Success)
+	if !ab(inst) {
+		t.Errorf("picked %v, want a filtered instance", inst.Addr)
+	}
+
+	none := func(discovery.Instance) bool { return false }
+	if _, _, err := b.Pick(testInstances, none); err != NoInstanceError {
+		t.Errorf("err == %v, want %v", err, NoInstanceError)
+	}
+}
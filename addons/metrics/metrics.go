@@ -0,0 +1,357 @@
+/*
+Package metrics records request counters and latency histograms of services
+and exposes them in Prometheus text exposition format.
+
+The metrics are recorded by a nano.Middleware so they cover the requests
+sent through any client of the ServiceSet: in-process calls, the calls
+received by the http listener and the calls sent by the http client proxies.
+
+	ss := nano.NewServiceSetOpts(nano.ServiceSetOpts{
+		Services:    services,
+		Middlewares: []nano.Middleware{metrics.Default.Middleware()},
+	})
+
+The metrics are labeled with the name of the service, the go type of the
+request, the ClientName of the caller and the NanoError code of the response
+("OK" for successful requests and "ERROR" for errors without a code).
+
+The ClientName of the requests received by a listener comes from the caller
+so it can't be trusted. Only the allowed client names appear in the client
+label (see Options.Clients and Registry.AllowDependencies), the others are
+recorded as OtherClient. This keeps the number of series bounded.
+
+Other packages can export their own counters through a Registry with its
+IncCounter method without depending on this package, e.g.: the http client
+exports the counters of its zone-aware routing if the Metrics field of its
+ZoneOptions is a Registry.
+*/
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pasztorpisti/nano"
+	"github.com/pasztorpisti/nano/addons/log"
+	"github.com/pasztorpisti/nano/addons/util"
+)
+
+const (
+	// CodeOK is the code label of successful requests.
+	CodeOK = "OK"
+	// CodeError is the code label of errors that don't have a NanoError code.
+	CodeError = "ERROR"
+	// OtherClient is the client label of the callers that aren't allowed.
+	OtherClient = "other"
+)
+
+// DefaultBuckets are the default upper bounds of the latency histogram
+// buckets in seconds.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Default is a registry that can be shared by the ServiceSet and the listener
+// of a server executable.
+var Default = NewRegistry(nil)
+
+// Options sets the histogram buckets and the allowed client labels of a
+// Registry.
+type Options struct {
+	// Buckets are the upper bounds of the latency histogram buckets in
+	// seconds. They have to be sorted. Defaults to DefaultBuckets.
+	Buckets []float64
+	// Clients is the list of the client names that appear in the client
+	// label. The names of the other callers are recorded as OtherClient.
+	Clients []string
+	// Clock defaults to time.Now.
+	Clock func() time.Time
+}
+
+// Registry holds the recorded metrics. It implements the http.Handler
+// interface to serve the metrics in Prometheus text exposition format.
+type Registry struct {
+	buckets []float64
+	clock   func() time.Time
+
+	mu       sync.Mutex
+	requests map[requestLabels]*histogram
+	inFlight map[string]int64
+	// counters maps the names of the counters of IncCounter to their
+	// formatted labels and values.
+	counters map[string]map[string]uint64
+	// clients is the set of the allowed client names.
+	clients map[string]bool
+	// dependents maps service names to the set of the services that depend
+	// on them.
+	dependents map[string]map[string]bool
+}
+
+// NewRegistry creates a new registry with the given histogram buckets.
+// The buckets have to be sorted. DefaultBuckets is used if buckets is nil.
+func NewRegistry(buckets []float64) *Registry {
+	return NewRegistryOpts(Options{Buckets: buckets})
+}
+
+// NewRegistryOpts creates a new registry with the given options.
+func NewRegistryOpts(opts Options) *Registry {
+	buckets := opts.Buckets
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+	if !sort.Float64sAreSorted(buckets) {
+		panic("histogram buckets aren't sorted")
+	}
+	clock := opts.Clock
+	if clock == nil {
+		clock = time.Now
+	}
+	clients := make(map[string]bool, len(opts.Clients))
+	for _, name := range opts.Clients {
+		clients[name] = true
+	}
+	return &Registry{
+		buckets:    buckets,
+		clock:      clock,
+		requests:   make(map[requestLabels]*histogram),
+		inFlight:   make(map[string]int64),
+		counters:   make(map[string]map[string]uint64),
+		clients:    clients,
+		dependents: make(map[string]map[string]bool),
+	}
+}
+
+// AllowDependencies allows the services of a ServiceSet to appear in the
+// client label of the services they depend on. The ServiceSet returned by
+// nano.NewServiceSet implements nano.DependencyGraph.
+func (r *Registry) AllowDependencies(g nano.DependencyGraph) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for owner, deps := range g.Dependencies() {
+		for _, svc := range deps {
+			if r.dependents[svc] == nil {
+				r.dependents[svc] = make(map[string]bool)
+			}
+			r.dependents[svc][owner] = true
+		}
+	}
+}
+
+// clientLabel returns the client label of a request sent by the given client
+// to the given service. The caller has to hold r.mu.
+func (r *Registry) clientLabel(service, client string) string {
+	if client == "" || r.clients[client] || r.dependents[service][client] {
+		return client
+	}
+	return OtherClient
+}
+
+type requestLabels struct {
+	service string
+	reqType string
+	client  string
+	code    string
+}
+
+type histogram struct {
+	// counts[i] is the number of observations that fall into bucket i.
+	// The last item is the +Inf bucket.
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// Middleware returns a nano.Middleware that records the requests.
+func (r *Registry) Middleware() nano.Middleware {
+	return func(next nano.RequestHandler) nano.RequestHandler {
+		return func(caller, callee *nano.Ctx, req interface{}) (resp interface{}, err error) {
+			labels := requestLabels{
+				service: callee.Svc.Name(),
+				reqType: fmt.Sprintf("%T", req),
+				client:  callee.ClientName,
+			}
+			r.addInFlight(labels.service, 1)
+			start := r.clock()
+			panicked := true
+			defer func() {
+				r.addInFlight(labels.service, -1)
+				switch {
+				case panicked:
+					labels.code = nano.PanicErrorCode
+				case err == nil:
+					labels.code = CodeOK
+				default:
+					labels.code = util.GetErrCode(err)
+					if labels.code == "" {
+						labels.code = CodeError
+					}
+				}
+				r.observe(labels, r.clock().Sub(start))
+			}()
+			resp, err = next(caller, callee, req)
+			panicked = false
+			return
+		}
+	}
+}
+
+// IncCounter increments the counter with the given name and labels. The
+// labels are name and value pairs. The counters are written after the
+// request metrics by WritePrometheus.
+func (r *Registry) IncCounter(name string, labels ...string) {
+	if len(labels)%2 != 0 {
+		panic("odd number of label names and values")
+	}
+	b := &strings.Builder{}
+	for i := 0; i < len(labels); i += 2 {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(b, `%s="%s"`, labels[i], escapeLabelValue(labels[i+1]))
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	c, ok := r.counters[name]
+	if !ok {
+		c = make(map[string]uint64)
+		r.counters[name] = c
+	}
+	c[b.String()]++
+}
+
+func (r *Registry) addInFlight(service string, delta int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.inFlight[service] += delta
+}
+
+func (r *Registry) observe(labels requestLabels, d time.Duration) {
+	seconds := d.Seconds()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	labels.client = r.clientLabel(labels.service, labels.client)
+	h, ok := r.requests[labels]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(r.buckets)+1)}
+		r.requests[labels] = h
+	}
+	i := sort.SearchFloat64s(r.buckets, seconds)
+	h.counts[i]++
+	h.sum += seconds
+	h.count++
+}
+
+// WritePrometheus writes the metrics in Prometheus text exposition format.
+func (r *Registry) WritePrometheus(w io.Writer) error {
+	b := &strings.Builder{}
+	r.mu.Lock()
+	keys := make([]requestLabels, 0, len(r.requests))
+	for k := range r.requests {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		if a.service != b.service {
+			return a.service < b.service
+		}
+		if a.reqType != b.reqType {
+			return a.reqType < b.reqType
+		}
+		if a.client != b.client {
+			return a.client < b.client
+		}
+		return a.code < b.code
+	})
+
+	b.WriteString("# HELP nano_requests_total Number of handled requests.\n")
+	b.WriteString("# TYPE nano_requests_total counter\n")
+	for _, k := range keys {
+		fmt.Fprintf(b, "nano_requests_total{%s} %d\n", k.format(), r.requests[k].count)
+	}
+
+	b.WriteString("# HELP nano_request_duration_seconds Latency of the handled requests.\n")
+	b.WriteString("# TYPE nano_request_duration_seconds histogram\n")
+	for _, k := range keys {
+		h := r.requests[k]
+		labels := k.format()
+		var cumulative uint64
+		for i, le := range r.buckets {
+			cumulative += h.counts[i]
+			fmt.Fprintf(b, "nano_request_duration_seconds_bucket{%s,le=%q} %d\n",
+				labels, formatFloat(le), cumulative)
+		}
+		fmt.Fprintf(b, "nano_request_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n",
+			labels, h.count)
+		fmt.Fprintf(b, "nano_request_duration_seconds_sum{%s} %s\n",
+			labels, formatFloat(h.sum))
+		fmt.Fprintf(b, "nano_request_duration_seconds_count{%s} %d\n",
+			labels, h.count)
+	}
+
+	services := make([]string, 0, len(r.inFlight))
+	for svc := range r.inFlight {
+		services = append(services, svc)
+	}
+	sort.Strings(services)
+	b.WriteString("# HELP nano_requests_in_flight Number of requests being handled.\n")
+	b.WriteString("# TYPE nano_requests_in_flight gauge\n")
+	for _, svc := range services {
+		fmt.Fprintf(b, "nano_requests_in_flight{service=\"%s\"} %d\n",
+			escapeLabelValue(svc), r.inFlight[svc])
+	}
+
+	names := make([]string, 0, len(r.counters))
+	for name := range r.counters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		c := r.counters[name]
+		labels := make([]string, 0, len(c))
+		for l := range c {
+			labels = append(labels, l)
+		}
+		sort.Strings(labels)
+		fmt.Fprintf(b, "# TYPE %s counter\n", name)
+		for _, l := range labels {
+			if l == "" {
+				fmt.Fprintf(b, "%s %d\n", name, c[l])
+			} else {
+				fmt.Fprintf(b, "%s{%s} %d\n", name, l, c[l])
+			}
+		}
+	}
+	r.mu.Unlock()
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// ServeHTTP serves the metrics in Prometheus text exposition format.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if err := r.WritePrometheus(w); err != nil {
+		log.Err(nil, err, "error writing metrics")
+	}
+}
+
+func (k requestLabels) format() string {
+	return fmt.Sprintf(`service="%s",req_type="%s",client="%s",code="%s"`,
+		escapeLabelValue(k.service), escapeLabelValue(k.reqType),
+		escapeLabelValue(k.client), escapeLabelValue(k.code))
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabelValue(s string) string {
+	return labelValueEscaper.Replace(s)
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
@@ -0,0 +1,186 @@
+package metrics
+
+import (
+	"bytes"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pasztorpisti/nano"
+	"github.com/pasztorpisti/nano/addons/internal/fakeclock"
+	"github.com/pasztorpisti/nano/addons/util"
+)
+
+type Req struct{}
+
+func newTestClient(r *Registry, err error) nano.Client {
+	svc := util.NewService("svc", func(c *nano.Ctx, req interface{}) (interface{}, error) {
+		return nil, err
+	})
+	ss := nano.NewServiceSetOpts(nano.ServiceSetOpts{
+		Services:    []nano.Service{svc},
+		Middlewares: []nano.Middleware{r.Middleware()},
+	})
+	return nano.NewClientSet(ss, "test").LookupClient("svc")
+}
+
+func TestRegistry(t *testing.T) {
+	// Every request takes 20ms.
+	clock := fakeclock.New()
+	r := NewRegistryOpts(Options{
+		Buckets: []float64{0.01, 0.1},
+		Clients: []string{"test"},
+		Clock: func() time.Time {
+			clock.Advance(20 * time.Millisecond)
+			return clock.Now()
+		},
+	})
+	newTestClient(r, nil).Request(nil, &Req{})
+	newTestClient(r, nil).Request(nil, &Req{})
+	newTestClient(r, util.ErrCode(nil, "C-TEST", "test")).Request(nil, &Req{})
+	newTestClient(r, errors.New("test")).Request(nil, &Req{})
+
+	buf := bytes.NewBuffer(nil)
+	err := r.WritePrometheus(buf)
+	if err != nil {
+		t.Errorf("unexpected error :: %v", err)
+	}
+
+	const labels = `service="svc",req_type="*metrics.Req",client="test"`
+	for _, want := range []string{
+		"# TYPE nano_requests_total counter\n",
+		"nano_requests_total{" + labels + `,code="OK"} 2` + "\n",
+		"nano_requests_total{" + labels + `,code="C-TEST"} 1` + "\n",
+		"nano_requests_total{" + labels + `,code="ERROR"} 1` + "\n",
+		"# TYPE nano_request_duration_seconds histogram\n",
+		"nano_request_duration_seconds_bucket{" + labels + `,code="OK",le="0.01"} 0` + "\n",
+		"nano_request_duration_seconds_bucket{" + labels + `,code="OK",le="0.1"} 2` + "\n",
+		"nano_request_duration_seconds_bucket{" + labels + `,code="OK",le="+Inf"} 2` + "\n",
+		"nano_request_duration_seconds_sum{" + labels + `,code="OK"} 0.04` + "\n",
+		"nano_request_duration_seconds_count{" + labels + `,code="OK"} 2` + "\n",
+		"# TYPE nano_requests_in_flight gauge\n",
+		`nano_requests_in_flight{service="svc"} 0` + "\n",
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("output doesn't contain %q:\n%s", want, buf.String())
+		}
+	}
+}
+
+func TestRegistry_OtherClient(t *testing.T) {
+	r := NewRegistry(nil)
+	newTestClient(r, nil).Request(nil, &Req{})
+
+	buf := bytes.NewBuffer(nil)
+	r.WritePrometheus(buf)
+	want := `client="` + OtherClient + `",code="OK"} 1`
+	if !strings.Contains(buf.String(), want) {
+		t.Errorf("output doesn't contain %q:\n%s", want, buf.String())
+	}
+}
+
+func TestRegistry_AllowDependencies(t *testing.T) {
+	r := NewRegistry(nil)
+	svc := util.NewService("svc", func(c *nano.Ctx, req interface{}) (interface{}, error) {
+		return nil, nil
+	})
+	var client nano.Client
+	caller := util.NewServiceOpts(util.ServiceOpts{
+		Name: "caller",
+		Handler: func(c *nano.Ctx, req interface{}) (interface{}, error) {
+			return nil, nil
+		},
+		Init: func(cs nano.ClientSet) error {
+			client = cs.LookupClient("svc")
+			return nil
+		},
+	})
+	ss := nano.NewServiceSetOpts(nano.ServiceSetOpts{
+		Services:    []nano.Service{caller, svc},
+		Middlewares: []nano.Middleware{r.Middleware()},
+	})
+	r.AllowDependencies(ss.(nano.DependencyGraph))
+	client.Request(nil, &Req{})
+	nano.NewClientSet(ss, "unknown").LookupClient("svc").Request(nil, &Req{})
+
+	buf := bytes.NewBuffer(nil)
+	r.WritePrometheus(buf)
+	for _, want := range []string{
+		`client="caller",code="OK"} 1`,
+		`client="` + OtherClient + `",code="OK"} 1`,
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("output doesn't contain %q:\n%s", want, buf.String())
+		}
+	}
+	if strings.Contains(buf.String(), `client="unknown"`) {
+		t.Errorf("output contains a client that isn't allowed:\n%s", buf.String())
+	}
+}
+
+func TestRegistry_IncCounter(t *testing.T) {
+	r := NewRegistry(nil)
+	r.IncCounter("test_total", "a", "x", "b", "y\"")
+	r.IncCounter("test_total", "a", "x", "b", "y\"")
+	r.IncCounter("test_total", "a", "z", "b", "y")
+	r.IncCounter("test2_total")
+
+	buf := bytes.NewBuffer(nil)
+	r.WritePrometheus(buf)
+	want := "# TYPE test2_total counter\n" +
+		"test2_total 1\n" +
+		"# TYPE test_total counter\n" +
+		`test_total{a="x",b="y\""} 2` + "\n" +
+		`test_total{a="z",b="y"} 1` + "\n"
+	if !strings.HasSuffix(buf.String(), want) {
+		t.Errorf("output doesn't end with %q:\n%s", want, buf.String())
+	}
+}
+
+func TestRegistry_Panic(t *testing.T) {
+	r := NewRegistry(nil)
+	svc := util.NewService("svc", func(c *nano.Ctx, req interface{}) (interface{}, error) {
+		panic("test")
+	})
+	ss := nano.NewServiceSetOpts(nano.ServiceSetOpts{
+		Services:    []nano.Service{svc},
+		Middlewares: []nano.Middleware{r.Middleware()},
+	})
+	origLogPanic := nano.LogPanic
+	nano.LogPanic = func(*nano.Ctx, *nano.PanicError) {}
+	defer func() {
+		nano.LogPanic = origLogPanic
+	}()
+	nano.NewClientSet(ss, "test").LookupClient("svc").Request(nil, &Req{})
+
+	buf := bytes.NewBuffer(nil)
+	r.WritePrometheus(buf)
+	want := `code="` + nano.PanicErrorCode + `"} 1`
+	if !strings.Contains(buf.String(), want) {
+		t.Errorf("output doesn't contain %q:\n%s", want, buf.String())
+	}
+}
+
+func TestRegistry_ServeHTTP(t *testing.T) {
+	r := NewRegistry(nil)
+	newTestClient(r, nil).Request(nil, &Req{})
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Content-Type == %q", ct)
+	}
+	if !strings.Contains(w.Body.String(), "nano_requests_total{") {
+		t.Errorf("unexpected body:\n%s", w.Body.String())
+	}
+}
+
+func TestEscapeLabelValue(t *testing.T) {
+	s := escapeLabelValue("a\\b\"c\nd")
+	want := `a\\b\"c\nd`
+	if s != want {
+		t.Errorf("escapeLabelValue == %q, want %q", s, want)
+	}
+}
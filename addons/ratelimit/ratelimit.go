@@ -0,0 +1,258 @@
+/*
+Package ratelimit protects services from noisy callers with token bucket
+rate limits.
+
+A Limiter is applied to services as a nano.Middleware. It can be applied to
+all services of a ServiceSet or only to some of them:
+
+	limiter := ratelimit.New(ratelimit.Options{
+		Service:   ratelimit.Limit{Rate: 1000, Burst: 100},
+		PerClient: ratelimit.Limit{Rate: 100, Burst: 10},
+	})
+	ss := nano.NewServiceSetOpts(nano.ServiceSetOpts{
+		Services: services,
+		ServiceMiddlewares: map[string][]nano.Middleware{
+			"svc1": {limiter.Middleware()},
+		},
+	})
+
+The rejected requests fail with a NanoError that has the
+config.ErrorCodeRateLimited code and a util.RetryHint. The http transport
+sends them with status 429 and a Retry-After header.
+
+The ClientName of the requests received by a listener comes from the caller
+so the Limiter bounds the memory used by the per client buckets: the buckets
+that have been refilled are dropped periodically and the clients that don't
+fit into Options.MaxClients share a single bucket.
+*/
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/pasztorpisti/nano"
+	"github.com/pasztorpisti/nano/addons/transport/http/config"
+	"github.com/pasztorpisti/nano/addons/util"
+)
+
+// Limit is the configuration of a token bucket.
+type Limit struct {
+	// Rate is the number of requests per second allowed on the long run.
+	// Zero means no limit.
+	Rate float64
+	// Burst is the maximum number of requests that can be sent at once
+	// after a quiet period. Values less than 1 are treated as 1.
+	Burst int
+}
+
+func (l Limit) unlimited() bool {
+	return l.Rate <= 0
+}
+
+func (l Limit) burst() float64 {
+	if l.Burst < 1 {
+		return 1
+	}
+	return float64(l.Burst)
+}
+
+// Options holds the limits of a Limiter. They are tracked separately for
+// each service the Limiter is applied to.
+type Options struct {
+	// Service limits the requests of a service regardless of the caller.
+	Service Limit
+	// PerClient limits the requests of each caller (Ctx.ClientName) of a
+	// service separately.
+	PerClient Limit
+	// Clients overrides PerClient for the given ClientNames. This can be
+	// used to give different quotas to different callers. A zero Limit
+	// exempts the client from the per client limits.
+	Clients map[string]Limit
+	// MaxClients is the maximum number of callers not listed in Clients
+	// that get their own PerClient bucket. The requests of the other
+	// callers share a PerClient bucket per service.
+	// Defaults to DefaultMaxClients.
+	MaxClients int
+	// Clock defaults to time.Now.
+	Clock func() time.Time
+}
+
+// DefaultMaxClients is the default value of Options.MaxClients.
+const DefaultMaxClients = 10000
+
+// sweepInterval is the minimum time between two scans for the buckets that
+// have been refilled.
+const sweepInterval = time.Minute
+
+func (o Options) withDefaults() Options {
+	if o.MaxClients <= 0 {
+		o.MaxClients = DefaultMaxClients
+	}
+	if o.Clock == nil {
+		o.Clock = time.Now
+	}
+	return o
+}
+
+// Limiter implements the rate limits described by its Options.
+type Limiter struct {
+	opts Options
+
+	mu      sync.Mutex
+	buckets map[bucketKey]*bucket
+	// clients is the number of buckets of the callers not listed in
+	// Options.Clients.
+	clients   int
+	lastSweep time.Time
+}
+
+type bucketKey struct {
+	service string
+	kind    bucketKind
+	// client is set only for the buckets of kindClient.
+	client string
+}
+
+type bucketKind int
+
+const (
+	// kindService is the bucket of a service.
+	kindService bucketKind = iota
+	// kindClient is the bucket of a caller of a service.
+	kindClient
+	// kindShared is the bucket of the callers of a service that don't fit
+	// into Options.MaxClients.
+	kindShared
+)
+
+// New creates a new Limiter.
+func New(opts Options) *Limiter {
+	opts = opts.withDefaults()
+	return &Limiter{
+		opts:      opts,
+		buckets:   make(map[bucketKey]*bucket),
+		lastSweep: opts.Clock(),
+	}
+}
+
+// Middleware returns a nano.Middleware that rejects the requests that exceed
+// the limits before they reach the service.
+func (l *Limiter) Middleware() nano.Middleware {
+	return func(next nano.RequestHandler) nano.RequestHandler {
+		return func(caller, callee *nano.Ctx, req interface{}) (interface{}, error) {
+			if err := l.Allow(callee.Svc.Name(), callee.ClientName); err != nil {
+				return nil, err
+			}
+			return next(caller, callee, req)
+		}
+	}
+}
+
+// Allow takes a token for a request of client to service. It returns nil if
+// the request is allowed. In other case it returns an error that has the
+// config.ErrorCodeRateLimited code and implements util.RetryHint.
+func (l *Limiter) Allow(service, client string) error {
+	clientLimit, listed := l.opts.Clients[client]
+	if !listed {
+		clientLimit = l.opts.PerClient
+	}
+
+	now := l.opts.Clock()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastSweep) >= sweepInterval {
+		l.sweep(now)
+	}
+
+	var clientBucket *bucket
+	if !clientLimit.unlimited() {
+		key := bucketKey{service: service, kind: kindClient, client: client}
+		if _, ok := l.buckets[key]; !ok && !listed {
+			if l.clients >= l.opts.MaxClients {
+				key = bucketKey{service: service, kind: kindShared}
+			} else {
+				l.clients++
+			}
+		}
+		clientBucket = l.bucket(key, clientLimit, now)
+		if wait := clientBucket.take(clientLimit, now); wait > 0 {
+			return rateLimitedError("client "+client+" of service "+service, wait)
+		}
+	}
+	if !l.opts.Service.unlimited() {
+		b := l.bucket(bucketKey{service: service, kind: kindService}, l.opts.Service, now)
+		if wait := b.take(l.opts.Service, now); wait > 0 {
+			if clientBucket != nil {
+				// The request isn't sent so the client gets back its token.
+				clientBucket.tokens++
+			}
+			return rateLimitedError("service "+service, wait)
+		}
+	}
+	return nil
+}
+
+func (l *Limiter) bucket(key bucketKey, limit Limit, now time.Time) *bucket {
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{
+			limit:  limit,
+			tokens: limit.burst(),
+			last:   now,
+		}
+		l.buckets[key] = b
+	}
+	return b
+}
+
+// sweep drops the buckets that have been refilled since their last use.
+// A new bucket created later with the same key is the same as the dropped one.
+func (l *Limiter) sweep(now time.Time) {
+	l.lastSweep = now
+	for key, b := range l.buckets {
+		if !b.full(now) {
+			continue
+		}
+		delete(l.buckets, key)
+		if _, listed := l.opts.Clients[key.client]; key.kind == kindClient && !listed {
+			l.clients--
+		}
+	}
+}
+
+func rateLimitedError(subject string, retryAfter time.Duration) error {
+	return util.WithRetryAfter(util.ErrCode(nil, config.ErrorCodeRateLimited,
+		"rate limit exceeded: "+subject), retryAfter)
+}
+
+type bucket struct {
+	limit  Limit
+	tokens float64
+	last   time.Time
+}
+
+// full returns true if the bucket would be full after a refill at now.
+func (b *bucket) full(now time.Time) bool {
+	return b.tokens+now.Sub(b.last).Seconds()*b.limit.Rate >= b.limit.burst()
+}
+
+// take refills the bucket and takes a token from it. It returns zero on
+// success and the time needed to refill a token if the bucket is empty.
+func (b *bucket) take(limit Limit, now time.Time) time.Duration {
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens = math.Min(limit.burst(), b.tokens+elapsed.Seconds()*limit.Rate)
+		b.last = now
+	}
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0
+	}
+	wait := time.Duration((1 - b.tokens) / limit.Rate * float64(time.Second))
+	if wait <= 0 {
+		wait = time.Nanosecond
+	}
+	return wait
+}
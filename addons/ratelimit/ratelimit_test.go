@@ -0,0 +1,153 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pasztorpisti/nano"
+	"github.com/pasztorpisti/nano/addons/internal/fakeclock"
+	"github.com/pasztorpisti/nano/addons/transport/http/config"
+	"github.com/pasztorpisti/nano/addons/util"
+)
+
+func TestLimiter_PerClient(t *testing.T) {
+	clock := fakeclock.New()
+	l := New(Options{
+		Clock:     clock.Now,
+		PerClient: Limit{Rate: 1, Burst: 2},
+		Clients: map[string]Limit{
+			"vip":    {Rate: 10, Burst: 10},
+			"exempt": {},
+		},
+	})
+
+	for i := 0; i < 2; i++ {
+		if err := l.Allow("svc", "client1"); err != nil {
+			t.Errorf("request %v rejected :: %v", i, err)
+		}
+	}
+	err := l.Allow("svc", "client1")
+	if code := util.GetErrCode(err); code != config.ErrorCodeRateLimited {
+		t.Errorf("error code == %q, want %q", code, config.ErrorCodeRateLimited)
+	}
+	if d, ok := util.GetRetryAfter(err); !ok || d != time.Second {
+		t.Errorf("retry hint == %v %v, want %v", d, ok, time.Second)
+	}
+
+	// The other clients and the same client of other services have their
+	// own buckets.
+	if err := l.Allow("svc", "client2"); err != nil {
+		t.Errorf("client2 rejected :: %v", err)
+	}
+	if err := l.Allow("svc2", "client1"); err != nil {
+		t.Errorf("client1 of svc2 rejected :: %v", err)
+	}
+	for i := 0; i < 10; i++ {
+		if err := l.Allow("svc", "vip"); err != nil {
+			t.Errorf("vip request %v rejected :: %v", i, err)
+		}
+		if err := l.Allow("svc", "exempt"); err != nil {
+			t.Errorf("exempt request %v rejected :: %v", i, err)
+		}
+	}
+
+	clock.Advance(time.Second)
+	if err := l.Allow("svc", "client1"); err != nil {
+		t.Errorf("request rejected after refill :: %v", err)
+	}
+	if err := l.Allow("svc", "client1"); err == nil {
+		t.Error("request allowed with an empty bucket")
+	}
+}
+
+func TestLimiter_Service(t *testing.T) {
+	clock := fakeclock.New()
+	l := New(Options{
+		Clock:     clock.Now,
+		Service:   Limit{Rate: 2, Burst: 1},
+		PerClient: Limit{Rate: 1, Burst: 1},
+	})
+	if err := l.Allow("svc", "client1"); err != nil {
+		t.Errorf("request rejected :: %v", err)
+	}
+	err := l.Allow("svc", "client2")
+	if d, ok := util.GetRetryAfter(err); !ok || d != 500*time.Millisecond {
+		t.Errorf("retry hint == %v %v, want %v", d, ok, 500*time.Millisecond)
+	}
+
+	// The rejection by the service limit has given back the token of client2.
+	clock.Advance(500 * time.Millisecond)
+	if err := l.Allow("svc", "client2"); err != nil {
+		t.Errorf("request rejected :: %v", err)
+	}
+}
+
+func TestLimiter_Middleware(t *testing.T) {
+	called := 0
+	svc := util.NewService("svc", func(c *nano.Ctx, req interface{}) (interface{}, error) {
+		called++
+		return nil, nil
+	})
+	l := New(Options{PerClient: Limit{Rate: 0.001, Burst: 1}})
+	ss := nano.NewServiceSetOpts(nano.ServiceSetOpts{
+		Services: []nano.Service{svc},
+		ServiceMiddlewares: map[string][]nano.Middleware{
+			"svc": {l.Middleware()},
+		},
+	})
+	client := nano.NewClientSet(ss, "test").LookupClient("svc")
+
+	if _, err := client.Request(nil, nil); err != nil {
+		t.Errorf("request rejected :: %v", err)
+	}
+	_, err := client.Request(nil, nil)
+	if code := util.GetErrCode(err); code != config.ErrorCodeRateLimited {
+		t.Errorf("error code == %q, want %q", code, config.ErrorCodeRateLimited)
+	}
+	if called != 1 {
+		t.Errorf("service called %v times, want 1", called)
+	}
+}
+
+func TestLimiter_MaxClients(t *testing.T) {
+	clock := fakeclock.New()
+	l := New(Options{
+		Clock:      clock.Now,
+		PerClient:  Limit{Rate: 1, Burst: 1},
+		Clients:    map[string]Limit{"vip": {Rate: 1, Burst: 1}},
+		MaxClients: 1,
+	})
+	if err := l.Allow("svc", "client1"); err != nil {
+		t.Errorf("client1 rejected :: %v", err)
+	}
+	// client2 and client3 don't fit into MaxClients so they share a bucket.
+	if err := l.Allow("svc", "client2"); err != nil {
+		t.Errorf("client2 rejected :: %v", err)
+	}
+	if err := l.Allow("svc", "client3"); err == nil {
+		t.Error("client3 allowed with an empty shared bucket")
+	}
+	// The listed clients have their own buckets regardless of MaxClients.
+	if err := l.Allow("svc", "vip"); err != nil {
+		t.Errorf("vip rejected :: %v", err)
+	}
+	if len(l.buckets) != 3 {
+		t.Errorf("len(buckets) == %v, want 3", len(l.buckets))
+	}
+
+	// The sweep drops the refilled buckets and client3 gets its own bucket.
+	clock.Advance(sweepInterval)
+	if err := l.Allow("svc", "client3"); err != nil {
+		t.Errorf("client3 rejected after the sweep :: %v", err)
+	}
+	if err := l.Allow("svc", "client2"); err != nil {
+		t.Errorf("client2 rejected :: %v", err)
+	}
+	if err := l.Allow("svc", "client4"); err == nil {
+		t.Error("client4 allowed with an empty shared bucket")
+	}
+	if len(l.buckets) != 2 || l.clients != 1 {
+		t.Errorf("len(buckets) == %v, clients == %v, want 2 and 1",
+			len(l.buckets), l.clients)
+	}
+}
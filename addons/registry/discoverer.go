@@ -0,0 +1,148 @@
+package registry
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pasztorpisti/nano"
+	"github.com/pasztorpisti/nano/addons/discovery"
+	"github.com/pasztorpisti/nano/addons/typed"
+)
+
+// DiscovererOptions controls the caching of the lookups of a Discoverer.
+type DiscovererOptions struct {
+	// CacheTTL is the time for which the results of a lookup are cached.
+	// Defaults to 5 seconds.
+	CacheTTL time.Duration
+	// NegativeTTL is the time for which a service without instances or a
+	// failed lookup is cached. Defaults to 1 second.
+	NegativeTTL time.Duration
+	// Timeout is the timeout of a lookup. Defaults to 5 seconds.
+	Timeout time.Duration
+	// Clock defaults to time.Now.
+	Clock func() time.Time
+}
+
+func (o DiscovererOptions) withDefaults() DiscovererOptions {
+	if o.CacheTTL <= 0 {
+		o.CacheTTL = 5 * time.Second
+	}
+	if o.NegativeTTL <= 0 {
+		o.NegativeTTL = time.Second
+	}
+	if o.Timeout <= 0 {
+		o.Timeout = 5 * time.Second
+	}
+	if o.Clock == nil {
+		o.Clock = time.Now
+	}
+	return o
+}
+
+// Discoverer implements the discovery.InstanceDiscoverer and
+// discovery.Resolver interfaces by looking up the instances in the registry.
+// If a lookup fails then the previous results of the service are used until
+// a later lookup succeeds.
+type Discoverer struct {
+	client nano.Client
+	opts   DiscovererOptions
+
+	mu      sync.Mutex
+	entries map[string]*entry
+}
+
+type entry struct {
+	instances []discovery.Instance
+	err       error
+	expires   time.Time
+}
+
+// NewDiscoverer creates a Discoverer that sends its lookups to the registry
+// through the given client, e.g.: a client of the http transport created
+// with HTTPTransportConfig.
+func NewDiscoverer(client nano.Client, opts DiscovererOptions) *Discoverer {
+	return &Discoverer{
+		client:  client,
+		opts:    opts.withDefaults(),
+		entries: make(map[string]*entry),
+	}
+}
+
+// Discover returns the address of the first instance of the service.
+func (d *Discoverer) Discover(name string) (string, error) {
+	instances, err := d.DiscoverInstances(name)
+	if err != nil {
+		return "", err
+	}
+	return instances[0].Addr, nil
+}
+
+func (d *Discoverer) DiscoverAll(name string) ([]string, error) {
+	instances, err := d.DiscoverInstances(name)
+	if err != nil {
+		return nil, err
+	}
+	addrs := make([]string, len(instances))
+	for i, inst := range instances {
+		addrs[i] = inst.Addr
+	}
+	return addrs, nil
+}
+
+func (d *Discoverer) DiscoverInstances(name string) ([]discovery.Instance, error) {
+	return d.Resolve(context.Background(), name)
+}
+
+// Resolve implements the discovery.Resolver interface. The lookup is sent
+// with the deadline of ctx if it is earlier than the Timeout.
+func (d *Discoverer) Resolve(ctx context.Context, name string) ([]discovery.Instance, error) {
+	d.mu.Lock()
+	e, ok := d.entries[name]
+	if ok && e.expires.After(d.opts.Clock()) {
+		d.mu.Unlock()
+		return e.instances, e.err
+	}
+	d.mu.Unlock()
+
+	instances, err := d.lookup(ctx, name)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	e, ok = d.entries[name]
+	if !ok {
+		e = &entry{}
+		d.entries[name] = e
+	}
+	switch {
+	case err == nil:
+		e.instances, e.err = instances, nil
+		e.expires = d.opts.Clock().Add(d.opts.CacheTTL)
+	case ctx.Err() != nil:
+		// The lookup of the caller has been canceled, the next caller
+		// should try again.
+		return nil, err
+	case e.instances != nil && err != discovery.NotFoundError:
+		// Keeping the previous results is better than failing because the
+		// registry is temporarily unavailable.
+		e.expires = d.opts.Clock().Add(d.opts.NegativeTTL)
+	default:
+		e.instances, e.err = nil, err
+		e.expires = d.opts.Clock().Add(d.opts.NegativeTTL)
+	}
+	return e.instances, e.err
+}
+
+func (d *Discoverer) lookup(ctx context.Context, name string) ([]discovery.Instance, error) {
+	ctx, cancel := context.WithTimeout(ctx, d.opts.Timeout)
+	defer cancel()
+	resp, err := typed.Call[*LookupReq, *LookupResp](d.client,
+		&nano.Ctx{Context: ctx}, &LookupReq{Service: name})
+	if err != nil {
+		return nil, err
+	}
+	if resp == nil || len(resp.Instances) == 0 {
+		return nil, discovery.NotFoundError
+	}
+	return resp.Instances, nil
+}
@@ -0,0 +1,46 @@
+package registry
+
+import (
+	"reflect"
+
+	"github.com/pasztorpisti/nano/addons/transport/http/config"
+)
+
+// HTTPTransportConfig exposes the registry service through the http
+// transport with the json serializer.
+var HTTPTransportConfig = &config.ServiceConfig{
+	ServiceName: ServiceName,
+	Endpoints: []*config.EndpointConfig{
+		{
+			Method:        "POST",
+			Path:          "/register",
+			HasReqContent: true,
+			ReqType:       reflect.TypeOf((*RegisterReq)(nil)).Elem(),
+			RespType:      reflect.TypeOf((*RegisterResp)(nil)).Elem(),
+		},
+		{
+			Method:        "POST",
+			Path:          "/heartbeat",
+			HasReqContent: true,
+			ReqType:       reflect.TypeOf((*HeartbeatReq)(nil)).Elem(),
+			RespType:      reflect.TypeOf((*HeartbeatResp)(nil)).Elem(),
+			Idempotent:    true,
+		},
+		{
+			Method:        "POST",
+			Path:          "/deregister",
+			HasReqContent: true,
+			ReqType:       reflect.TypeOf((*DeregisterReq)(nil)).Elem(),
+			RespType:      reflect.TypeOf((*DeregisterResp)(nil)).Elem(),
+			Idempotent:    true,
+		},
+		{
+			Method:        "POST",
+			Path:          "/lookup",
+			HasReqContent: true,
+			ReqType:       reflect.TypeOf((*LookupReq)(nil)).Elem(),
+			RespType:      reflect.TypeOf((*LookupResp)(nil)).Elem(),
+			Idempotent:    true,
+		},
+	},
+}
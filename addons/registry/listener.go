@@ -0,0 +1,216 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pasztorpisti/nano"
+	"github.com/pasztorpisti/nano/addons/discovery"
+	"github.com/pasztorpisti/nano/addons/log"
+	"github.com/pasztorpisti/nano/addons/transport/http/config"
+	"github.com/pasztorpisti/nano/addons/typed"
+	"github.com/pasztorpisti/nano/addons/util"
+)
+
+// ServiceLister is implemented by the listeners that can list the services
+// they expose, e.g.: by the listener of the http transport.
+type ServiceLister interface {
+	ServiceNames() []string
+}
+
+// ListenerOptions is the configuration of the listener returned by
+// NewListener.
+type ListenerOptions struct {
+	// Client is the client of the registry service. Required.
+	Client nano.Client
+	// Instance is the instance registered for each service. Its Addr is the
+	// "host:port" on which the clients can reach the listener. Required.
+	Instance discovery.Instance
+	// Services is the list of the registered services. Defaults to the
+	// services of the wrapped listener if it implements ServiceLister.
+	Services []string
+	// TTL is the requested TTL of the leases. Zero means the default of
+	// the registry.
+	TTL time.Duration
+	// Timeout is the timeout of the requests sent to the registry.
+	// Defaults to 5 seconds.
+	Timeout time.Duration
+}
+
+// NewListener wraps a listener to register its services in the registry
+// when it starts listening. The leases are renewed with heartbeats until the
+// listener stops and are deregistered at the beginning of the shutdown
+// before the wrapped listener starts draining.
+func NewListener(l nano.Listener, opts ListenerOptions) nano.Listener {
+	if opts.Client == nil {
+		panic("registry client is nil")
+	}
+	if opts.Instance.Addr == "" {
+		panic("instance address is empty")
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = 5 * time.Second
+	}
+	return &listener{
+		Listener: l,
+		opts:     opts,
+		stop:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+}
+
+// listener implements the nano.Listener and nano.ListenerShutdown
+// interfaces.
+type listener struct {
+	nano.Listener
+	opts ListenerOptions
+
+	// started is set to 1 when the registration starts.
+	started  int32
+	stopOnce sync.Once
+	stop     chan struct{}
+	stopped  chan struct{}
+
+	mu sync.Mutex
+	// leases maps the registered services to their lease IDs.
+	leases map[string]string
+	// heartbeat is the interval of the heartbeats. It is set by the first
+	// successful registration.
+	heartbeat time.Duration
+}
+
+func (p *listener) Init(ss nano.ServiceSet) error {
+	if err := p.Listener.Init(ss); err != nil {
+		return err
+	}
+	if len(p.opts.Services) == 0 {
+		sl, ok := p.Listener.(ServiceLister)
+		if !ok {
+			return errors.New("registry: the services of the listener can't be listed")
+		}
+		p.opts.Services = sl.ServiceNames()
+	}
+	p.leases = make(map[string]string, len(p.opts.Services))
+	return nil
+}
+
+func (p *listener) Listen() error {
+	atomic.StoreInt32(&p.started, 1)
+	go p.run()
+	defer p.stopRegistration(context.Background())
+	return p.Listener.Listen()
+}
+
+func (p *listener) Shutdown(ctx context.Context) error {
+	p.stopRegistration(ctx)
+	if ls, ok := p.Listener.(nano.ListenerShutdown); ok {
+		return ls.Shutdown(ctx)
+	}
+	return nil
+}
+
+// stopRegistration stops the heartbeats and deregisters the services.
+func (p *listener) stopRegistration(ctx context.Context) {
+	first := false
+	p.stopOnce.Do(func() {
+		first = true
+		close(p.stop)
+	})
+	if !first || atomic.LoadInt32(&p.started) == 0 {
+		return
+	}
+	<-p.stopped
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for svc, id := range p.leases {
+		_, err := call[*DeregisterReq, *DeregisterResp](p, ctx, &DeregisterReq{LeaseID: id})
+		if err != nil {
+			log.Err(nil, err, "error deregistering service "+svc)
+		}
+		delete(p.leases, svc)
+	}
+}
+
+// run registers the services and sends the heartbeats until p.stop is
+// closed.
+func (p *listener) run() {
+	defer close(p.stopped)
+	// retry is the interval of the registration attempts of the services
+	// that haven't been registered yet.
+	const retry = time.Second
+	for {
+		select {
+		case <-p.stop:
+			return
+		default:
+		}
+
+		p.mu.Lock()
+		for _, svc := range p.opts.Services {
+			if id, ok := p.leases[svc]; ok {
+				p.sendHeartbeat(svc, id)
+			} else {
+				p.register(svc)
+			}
+		}
+		interval := p.heartbeat
+		if interval == 0 || len(p.leases) < len(p.opts.Services) && retry < interval {
+			interval = retry
+		}
+		p.mu.Unlock()
+
+		t := time.NewTimer(interval)
+		select {
+		case <-t.C:
+		case <-p.stop:
+			t.Stop()
+			return
+		}
+	}
+}
+
+// register has to be called while holding p.mu.
+func (p *listener) register(svc string) {
+	resp, err := call[*RegisterReq, *RegisterResp](p, context.Background(), &RegisterReq{
+		Service:  svc,
+		Instance: p.opts.Instance,
+		TTL:      p.opts.TTL,
+	})
+	if err != nil {
+		log.Err(nil, err, "error registering service "+svc)
+		return
+	}
+	p.leases[svc] = resp.LeaseID
+	// Three heartbeats per TTL leave room for a lost one.
+	if hb := resp.TTL / 3; p.heartbeat == 0 || hb < p.heartbeat {
+		p.heartbeat = hb
+	}
+}
+
+// sendHeartbeat has to be called while holding p.mu.
+func (p *listener) sendHeartbeat(svc, id string) {
+	_, err := call[*HeartbeatReq, *HeartbeatResp](p, context.Background(),
+		&HeartbeatReq{LeaseID: id})
+	if err == nil {
+		return
+	}
+	if util.GetErrCode(err) == config.ErrorCodeNotFound {
+		// The lease has expired, e.g.: because the registry has been
+		// restarted.
+		delete(p.leases, svc)
+		p.register(svc)
+		return
+	}
+	log.Err(nil, err, "error sending heartbeat of service "+svc)
+}
+
+// call sends a request to the registry with the timeout of the listener.
+func call[Req, Resp any](p *listener, parent context.Context, req Req) (Resp, error) {
+	ctx, cancel := context.WithTimeout(parent, p.opts.Timeout)
+	defer cancel()
+	return typed.Call[Req, Resp](p.opts.Client, &nano.Ctx{Context: ctx}, req)
+}
@@ -0,0 +1,223 @@
+/*
+Package registry implements a service registry as a nano.Service.
+
+Servers register the instances of their services with leases. A lease expires
+if it isn't renewed with heartbeats within its TTL so the instances of the
+crashed servers disappear from the registry automatically. The registry can be
+exposed with the http listener using HTTPTransportConfig.
+
+NewDiscoverer returns a discovery.Discoverer that looks up the instances in the
+registry and NewListener wraps a listener of a server to register the services
+exposed by the listener.
+*/
+package registry
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pasztorpisti/nano"
+	"github.com/pasztorpisti/nano/addons/discovery"
+	"github.com/pasztorpisti/nano/addons/transport/http/config"
+	"github.com/pasztorpisti/nano/addons/typed"
+	"github.com/pasztorpisti/nano/addons/typed/httpconfig"
+	"github.com/pasztorpisti/nano/addons/util"
+)
+
+// ServiceName is the name of the registry service.
+const ServiceName = "registry"
+
+// RegisterReq registers an instance of a service. Registering an instance
+// with the address of an already registered instance of the same service
+// replaces the old lease.
+type RegisterReq struct {
+	Service  string
+	Instance discovery.Instance
+	// TTL is the requested TTL of the lease. Zero means Options.DefaultTTL.
+	TTL time.Duration
+}
+
+type RegisterResp struct {
+	LeaseID string
+	// TTL is the TTL of the lease. The heartbeats have to arrive within it.
+	TTL time.Duration
+}
+
+// HeartbeatReq renews a lease. It fails with config.ErrorCodeNotFound if the
+// lease has expired. The instance has to be registered again in that case.
+type HeartbeatReq struct {
+	LeaseID string
+}
+
+type HeartbeatResp struct{}
+
+// DeregisterReq removes the instance of a lease from the registry.
+type DeregisterReq struct {
+	LeaseID string
+}
+
+type DeregisterResp struct{}
+
+// LookupReq returns the registered instances of a service.
+type LookupReq struct {
+	Service string
+}
+
+type LookupResp struct {
+	// Instances is empty if the service has no registered instances.
+	Instances []discovery.Instance
+}
+
+// Options limits the TTL of the leases of the registry service.
+type Options struct {
+	// DefaultTTL defaults to 30 seconds.
+	DefaultTTL time.Duration
+	// MinTTL defaults to 1 second.
+	MinTTL time.Duration
+	// MaxTTL defaults to 5 minutes.
+	MaxTTL time.Duration
+	// Clock defaults to time.Now.
+	Clock func() time.Time
+}
+
+func (o Options) withDefaults() Options {
+	if o.DefaultTTL <= 0 {
+		o.DefaultTTL = 30 * time.Second
+	}
+	if o.MinTTL <= 0 {
+		o.MinTTL = time.Second
+	}
+	if o.MaxTTL <= 0 {
+		o.MaxTTL = 5 * time.Minute
+	}
+	if o.Clock == nil {
+		o.Clock = time.Now
+	}
+	return o
+}
+
+// NewService creates the registry service.
+func NewService(opts Options) nano.Service {
+	p := &service{
+		opts:   opts.withDefaults(),
+		leases: make(map[string]*lease),
+	}
+	typed.Handle(&p.Handlers, p.register)
+	typed.Handle(&p.Handlers, p.heartbeat)
+	typed.Handle(&p.Handlers, p.deregister)
+	typed.Handle(&p.Handlers, p.lookup)
+	if err := httpconfig.Check(&p.Handlers, HTTPTransportConfig); err != nil {
+		panic(err)
+	}
+	return p
+}
+
+type service struct {
+	typed.Handlers
+	opts Options
+
+	mu     sync.Mutex
+	leases map[string]*lease
+}
+
+type lease struct {
+	service  string
+	instance discovery.Instance
+	ttl      time.Duration
+	expires  time.Time
+}
+
+func (p *service) Name() string {
+	return ServiceName
+}
+
+func (p *service) register(c *nano.Ctx, req *RegisterReq) (*RegisterResp, error) {
+	if req.Service == "" || req.Instance.Addr == "" {
+		return nil, util.ErrCode(nil, config.ErrorCodeBadRequest,
+			"service and instance address are required")
+	}
+	ttl := req.TTL
+	if ttl == 0 {
+		ttl = p.opts.DefaultTTL
+	}
+	if ttl < p.opts.MinTTL {
+		ttl = p.opts.MinTTL
+	}
+	if ttl > p.opts.MaxTTL {
+		ttl = p.opts.MaxTTL
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for id, l := range p.leases {
+		if l.service == req.Service && l.instance.Addr == req.Instance.Addr {
+			delete(p.leases, id)
+		}
+	}
+	id, err := newLeaseID()
+	if err != nil {
+		return nil, util.Err(err, "error generating lease ID")
+	}
+	p.leases[id] = &lease{
+		service:  req.Service,
+		instance: req.Instance,
+		ttl:      ttl,
+		expires:  p.opts.Clock().Add(ttl),
+	}
+	return &RegisterResp{LeaseID: id, TTL: ttl}, nil
+}
+
+func (p *service) heartbeat(c *nano.Ctx, req *HeartbeatReq) (*HeartbeatResp, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := p.opts.Clock()
+	l, ok := p.leases[req.LeaseID]
+	if !ok || !l.expires.After(now) {
+		delete(p.leases, req.LeaseID)
+		return nil, util.ErrCode(nil, config.ErrorCodeNotFound,
+			"lease "+req.LeaseID+" not found")
+	}
+	l.expires = now.Add(l.ttl)
+	return &HeartbeatResp{}, nil
+}
+
+func (p *service) deregister(c *nano.Ctx, req *DeregisterReq) (*DeregisterResp, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.leases, req.LeaseID)
+	return &DeregisterResp{}, nil
+}
+
+func (p *service) lookup(c *nano.Ctx, req *LookupReq) (*LookupResp, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := p.opts.Clock()
+	resp := &LookupResp{}
+	for id, l := range p.leases {
+		if !l.expires.After(now) {
+			delete(p.leases, id)
+			continue
+		}
+		if l.service == req.Service {
+			resp.Instances = append(resp.Instances, l.instance)
+		}
+	}
+	// Sorting makes the order of the instances stable.
+	sort.Slice(resp.Instances, func(i, j int) bool {
+		return resp.Instances[i].Addr < resp.Instances[j].Addr
+	})
+	return resp, nil
+}
+
+// newLeaseID returns a random lease ID. Random IDs don't collide with the
+// leases handed out before a restart of the registry.
+func newLeaseID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,210 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/pasztorpisti/nano"
+	"github.com/pasztorpisti/nano/addons/discovery"
+	"github.com/pasztorpisti/nano/addons/internal/fakeclock"
+	"github.com/pasztorpisti/nano/addons/transport/http/config"
+	"github.com/pasztorpisti/nano/addons/typed"
+	"github.com/pasztorpisti/nano/addons/util"
+)
+
+func newRegistryClient(opts Options) nano.Client {
+	return nano.NewClient(NewService(opts), "test")
+}
+
+func register(t *testing.T, client nano.Client, svc, addr string) *RegisterResp {
+	resp, err := typed.Call[*RegisterReq, *RegisterResp](client, nil, &RegisterReq{
+		Service:  svc,
+		Instance: discovery.Instance{Addr: addr},
+		TTL:      10 * time.Second,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error :: %v", err)
+	}
+	return resp
+}
+
+func lookup(t *testing.T, client nano.Client, svc string) []string {
+	resp, err := typed.Call[*LookupReq, *LookupResp](client, nil, &LookupReq{Service: svc})
+	if err != nil {
+		t.Fatalf("unexpected error :: %v", err)
+	}
+	var addrs []string
+	for _, inst := range resp.Instances {
+		addrs = append(addrs, inst.Addr)
+	}
+	return addrs
+}
+
+func TestService_Leases(t *testing.T) {
+	clock := fakeclock.New()
+	client := newRegistryClient(Options{Clock: clock.Now})
+
+	a := register(t, client, "svc", "a:80")
+	register(t, client, "svc", "b:80")
+	register(t, client, "other", "c:80")
+	if a.TTL != 10*time.Second {
+		t.Errorf("TTL == %v, want %v", a.TTL, 10*time.Second)
+	}
+	if addrs := lookup(t, client, "svc"); !reflect.DeepEqual(addrs, []string{"a:80", "b:80"}) {
+		t.Errorf("addrs == %v, want [a:80 b:80]", addrs)
+	}
+
+	// Only the lease of a:80 is renewed.
+	clock.Advance(6 * time.Second)
+	if _, err := client.Request(nil, &HeartbeatReq{LeaseID: a.LeaseID}); err != nil {
+		t.Errorf("unexpected error :: %v", err)
+	}
+	clock.Advance(6 * time.Second)
+	if addrs := lookup(t, client, "svc"); !reflect.DeepEqual(addrs, []string{"a:80"}) {
+		t.Errorf("addrs == %v, want [a:80]", addrs)
+	}
+
+	if _, err := client.Request(nil, &DeregisterReq{LeaseID: a.LeaseID}); err != nil {
+		t.Errorf("unexpected error :: %v", err)
+	}
+	if addrs := lookup(t, client, "svc"); len(addrs) != 0 {
+		t.Errorf("addrs == %v, want none", addrs)
+	}
+	_, err := client.Request(nil, &HeartbeatReq{LeaseID: a.LeaseID})
+	if code := util.GetErrCode(err); code != config.ErrorCodeNotFound {
+		t.Errorf("error code == %q, want %q", code, config.ErrorCodeNotFound)
+	}
+}
+
+func TestService_Reregister_Replaces_Lease(t *testing.T) {
+	client := newRegistryClient(Options{})
+	old := register(t, client, "svc", "a:80")
+	register(t, client, "svc", "a:80")
+	if addrs := lookup(t, client, "svc"); !reflect.DeepEqual(addrs, []string{"a:80"}) {
+		t.Errorf("addrs == %v, want [a:80]", addrs)
+	}
+	if _, err := client.Request(nil, &HeartbeatReq{LeaseID: old.LeaseID}); err == nil {
+		t.Error("the old lease is still alive")
+	}
+}
+
+func TestDiscoverer(t *testing.T) {
+	clock := fakeclock.New()
+	registry := newRegistryClient(Options{Clock: clock.Now})
+	lookups := 0
+	var lookupErr error
+	client := nano.NewClient(util.NewService(ServiceName, func(c *nano.Ctx, req interface{}) (interface{}, error) {
+		lookups++
+		if lookupErr != nil {
+			return nil, lookupErr
+		}
+		return registry.Request(c, req)
+	}), "test")
+
+	d := NewDiscoverer(client, DiscovererOptions{
+		CacheTTL: 5 * time.Second,
+		Clock:    clock.Now,
+	})
+	if _, err := d.Discover("svc"); err != discovery.NotFoundError {
+		t.Errorf("err == %v, want %v", err, discovery.NotFoundError)
+	}
+
+	register(t, registry, "svc", "a:80")
+	clock.Advance(time.Second)
+	if addr, err := d.Discover("svc"); err != nil || addr != "a:80" {
+		t.Errorf("Discover() == %q, %v, want a:80", addr, err)
+	}
+	register(t, registry, "svc", "b:80")
+	if addrs, _ := d.DiscoverAll("svc"); !reflect.DeepEqual(addrs, []string{"a:80"}) {
+		t.Errorf("addrs == %v, want the cached [a:80]", addrs)
+	}
+	if lookups != 2 {
+		t.Errorf("%v lookups, want 2", lookups)
+	}
+
+	// The cached results are used if the registry is unavailable.
+	lookupErr = errors.New("registry is down")
+	clock.Advance(5 * time.Second)
+	if addrs, err := d.DiscoverAll("svc"); err != nil || !reflect.DeepEqual(addrs, []string{"a:80"}) {
+		t.Errorf("DiscoverAll() == %v, %v, want [a:80]", addrs, err)
+	}
+
+	lookupErr = nil
+	clock.Advance(time.Second)
+	if addrs, _ := d.DiscoverAll("svc"); !reflect.DeepEqual(addrs, []string{"a:80", "b:80"}) {
+		t.Errorf("addrs == %v, want [a:80 b:80]", addrs)
+	}
+}
+
+// fakeListener implements the nano.Listener, nano.ListenerShutdown and
+// ServiceLister interfaces.
+type fakeListener struct {
+	stop     chan struct{}
+	shutdown func()
+}
+
+func (p *fakeListener) Init(ss nano.ServiceSet) error {
+	return nil
+}
+
+func (p *fakeListener) Listen() error {
+	<-p.stop
+	return nil
+}
+
+func (p *fakeListener) Shutdown(ctx context.Context) error {
+	p.shutdown()
+	close(p.stop)
+	return nil
+}
+
+func (p *fakeListener) ServiceNames() []string {
+	return []string{"svc1", "svc2"}
+}
+
+func TestListener(t *testing.T) {
+	registry := newRegistryClient(Options{})
+	var addrsAtShutdown []string
+	inner := &fakeListener{stop: make(chan struct{})}
+	inner.shutdown = func() {
+		addrsAtShutdown = lookup(t, registry, "svc1")
+	}
+
+	l := NewListener(inner, ListenerOptions{
+		Client:   registry,
+		Instance: discovery.Instance{Addr: "a:80"},
+	})
+	if err := l.Init(nil); err != nil {
+		t.Fatalf("unexpected error :: %v", err)
+	}
+	listenErr := make(chan error)
+	go func() {
+		listenErr <- l.Listen()
+	}()
+
+	deadline := time.Now().Add(time.Second)
+	for len(lookup(t, registry, "svc1")) == 0 || len(lookup(t, registry, "svc2")) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("the services haven't been registered")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if err := l.(nano.ListenerShutdown).Shutdown(context.Background()); err != nil {
+		t.Errorf("unexpected error :: %v", err)
+	}
+	if err := <-listenErr; err != nil {
+		t.Errorf("unexpected error :: %v", err)
+	}
+	// The services are deregistered before the wrapped listener starts
+	// draining.
+	if len(addrsAtShutdown) != 0 {
+		t.Errorf("svc1 was registered at shutdown: %v", addrsAtShutdown)
+	}
+	if addrs := lookup(t, registry, "svc2"); len(addrs) != 0 {
+		t.Errorf("svc2 is still registered: %v", addrs)
+	}
+}
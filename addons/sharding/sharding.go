@@ -0,0 +1,243 @@
+/*
+Package sharding routes the requests of an entity to the same instance of a
+service. This is useful when the instances keep per-entity state in memory.
+
+A request declares its shard key by implementing the ShardKeyer interface.
+The owner of a key is chosen with rendezvous (highest random weight) hashing:
+every instance gets a score for the key and the instance with the highest
+score wins. Adding or removing an instance moves only the keys that the
+instance gains or loses, the other keys stay on their instances.
+
+The http client routes the requests that have a shard key if its
+ClientOptions.ShardRanker is a Ranker and NewService does the same in-process
+for several instances of a service in a ServiceSet.
+*/
+package sharding
+
+import (
+	"context"
+	"errors"
+	"hash/fnv"
+	"math"
+	"sort"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/pasztorpisti/nano"
+	"github.com/pasztorpisti/nano/addons/discovery"
+)
+
+// ShardKeyer is implemented by the requests that have to be routed to the
+// instance that owns their shard key.
+type ShardKeyer interface {
+	// ShardKey returns the key of the entity the request belongs to, e.g.:
+	// a user ID. An empty key means that any instance can serve the request.
+	ShardKey() string
+}
+
+// Key returns the shard key of req. The returned bool is false if req doesn't
+// implement ShardKeyer or its shard key is empty.
+func Key(req interface{}) (string, bool) {
+	sk, ok := req.(ShardKeyer)
+	if !ok {
+		return "", false
+	}
+	key := sk.ShardKey()
+	return key, key != ""
+}
+
+// Ranker implements the http.ShardRanker interface of the http transport
+// with Key and Owner.
+type Ranker struct{}
+
+func (Ranker) Key(req interface{}) (string, bool) {
+	return Key(req)
+}
+
+func (Ranker) Owner(key string, instances []discovery.Instance) int {
+	return Owner(key, instances)
+}
+
+// Rank returns a copy of instances sorted by their preference for the key.
+// The first item is the owner of the key and the rest can be used as
+// fallbacks in order. Instances with higher Weight own proportionally more
+// keys, zero weight is treated as 1.
+func Rank(key string, instances []discovery.Instance) []discovery.Instance {
+	type scored struct {
+		inst  discovery.Instance
+		score float64
+	}
+	s := make([]scored, len(instances))
+	for i, inst := range instances {
+		s[i] = scored{inst, score(key, inst)}
+	}
+	sort.SliceStable(s, func(i, j int) bool {
+		if s[i].score != s[j].score {
+			return s[i].score > s[j].score
+		}
+		return s[i].inst.Addr < s[j].inst.Addr
+	})
+	ranked := make([]discovery.Instance, len(s))
+	for i := range s {
+		ranked[i] = s[i].inst
+	}
+	return ranked
+}
+
+// Owner returns the index of the instance that owns the key. It is the
+// first item of Rank without sorting the instances. instances can't be empty.
+func Owner(key string, instances []discovery.Instance) int {
+	return owner(key, len(instances), func(i int) discovery.Instance {
+		return instances[i]
+	})
+}
+
+// owner returns the index of the owner of the key among n instances.
+func owner(key string, n int, instance func(i int) discovery.Instance) int {
+	best, bestInst := 0, instance(0)
+	bestScore := score(key, bestInst)
+	for i := 1; i < n; i++ {
+		inst := instance(i)
+		s := score(key, inst)
+		if s > bestScore || s == bestScore && inst.Addr < bestInst.Addr {
+			best, bestInst, bestScore = i, inst, s
+		}
+	}
+	return best
+}
+
+// score returns the weighted rendezvous score of the instance for the key.
+func score(key string, inst discovery.Instance) float64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	h.Write([]byte{0})
+	h.Write([]byte(inst.Addr))
+	// FNV mixes the last bytes poorly into the high bits so the hash goes
+	// through the finalizer of MurmurHash3.
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+
+	// u is uniform in (0, 1).
+	u := (float64(x>>11) + 0.5) / (1 << 53)
+	weight := inst.Weight
+	if weight <= 0 {
+		weight = 1
+	}
+	return float64(weight) / -math.Log(u)
+}
+
+// NewService returns a service that routes the requests across several
+// instances (replicas) of the same service in-process. The requests with a
+// shard key are handled by the replica that owns the key and the others are
+// distributed round-robin. The replicas are identified by their position so
+// their order must not change between runs.
+//
+// The returned service calls the optional ServiceInit, ServiceInitFinished,
+// ServiceShutdown and ServiceHealth methods of all replicas.
+func NewService(replicas ...nano.Service) nano.Service {
+	if len(replicas) == 0 {
+		panic("no replicas")
+	}
+	name := replicas[0].Name()
+	instances := make([]discovery.Instance, len(replicas))
+	for i, r := range replicas {
+		if r.Name() != name {
+			panic("replicas have different names: " + name + ", " + r.Name())
+		}
+		instances[i].Addr = strconv.Itoa(i)
+	}
+	return &service{
+		name:      name,
+		replicas:  replicas,
+		instances: instances,
+	}
+}
+
+// service implements the nano.Service, nano.ServiceInit,
+// nano.ServiceInitFinished, nano.ServiceShutdown and nano.ServiceHealth
+// interfaces.
+type service struct {
+	name      string
+	replicas  []nano.Service
+	instances []discovery.Instance
+	next      uint32
+}
+
+func (p *service) Name() string {
+	return p.name
+}
+
+func (p *service) Handle(c *nano.Ctx, req interface{}) (interface{}, error) {
+	return p.replica(req).Handle(c, req)
+}
+
+func (p *service) replica(req interface{}) nano.Service {
+	if key, ok := Key(req); ok {
+		return p.replicas[Owner(key, p.instances)]
+	}
+	n := atomic.AddUint32(&p.next, 1) - 1
+	return p.replicas[n%uint32(len(p.replicas))]
+}
+
+func (p *service) Init(cs nano.ClientSet) error {
+	for _, r := range p.replicas {
+		if si, ok := r.(nano.ServiceInit); ok {
+			if err := si.Init(cs); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (p *service) InitFinished() error {
+	for _, r := range p.replicas {
+		if sif, ok := r.(nano.ServiceInitFinished); ok {
+			if err := sif.InitFinished(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (p *service) Shutdown() error {
+	var errs []error
+	for _, r := range p.replicas {
+		if ss, ok := r.(nano.ServiceShutdown); ok {
+			if err := ss.Shutdown(); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Health returns the worst status of the replicas because the requests of
+// a key can be served only by its own replica. The errors of the replicas are
+// joined.
+func (p *service) Health(ctx context.Context) (nano.HealthStatus, error) {
+	status := nano.HealthOK
+	var errs []error
+	for _, r := range p.replicas {
+		sh, ok := r.(nano.ServiceHealth)
+		if !ok {
+			continue
+		}
+		s, err := sh.Health(ctx)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		switch {
+		case s == nano.HealthNotReady:
+			status = s
+		case s != nano.HealthOK && status == nano.HealthOK:
+			status = s
+		}
+	}
+	return status, errors.Join(errs...)
+}
@@ -0,0 +1,191 @@
+package sharding
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/pasztorpisti/nano"
+	"github.com/pasztorpisti/nano/addons/discovery"
+	"github.com/pasztorpisti/nano/addons/util"
+)
+
+func testInstances(n int) []discovery.Instance {
+	instances := make([]discovery.Instance, n)
+	for i := range instances {
+		instances[i].Addr = "10.0.0." + strconv.Itoa(i) + ":80"
+	}
+	return instances
+}
+
+func owners(instances []discovery.Instance, numKeys int) map[string]string {
+	m := make(map[string]string, numKeys)
+	for i := 0; i < numKeys; i++ {
+		key := "key" + strconv.Itoa(i)
+		m[key] = Rank(key, instances)[0].Addr
+	}
+	return m
+}
+
+func TestRank_Minimal_Reshuffle(t *testing.T) {
+	const numKeys = 10000
+	instances := testInstances(5)
+	before := owners(instances, numKeys)
+
+	// Only the keys of the removed instance move.
+	removed := instances[2].Addr
+	after := owners(append(instances[:2:2], instances[3:]...), numKeys)
+	for key, owner := range before {
+		if owner != removed && after[key] != owner {
+			t.Fatalf("key %v has moved from %v to %v", key, owner, after[key])
+		}
+	}
+
+	// Only the keys gained by the added instance move.
+	added := testInstances(6)
+	after = owners(added, numKeys)
+	moved := 0
+	for key, owner := range before {
+		if after[key] == owner {
+			continue
+		}
+		if after[key] != added[5].Addr {
+			t.Fatalf("key %v has moved from %v to %v", key, owner, after[key])
+		}
+		moved++
+	}
+	// The new instance should get about 1/6 of the keys.
+	if moved < numKeys/6*8/10 || moved > numKeys/6*12/10 {
+		t.Errorf("%v keys moved to the new instance, want about %v", moved, numKeys/6)
+	}
+}
+
+func TestRank_Weight(t *testing.T) {
+	const numKeys = 10000
+	instances := testInstances(2)
+	instances[0].Weight = 3
+	n := 0
+	for _, owner := range owners(instances, numKeys) {
+		if owner == instances[0].Addr {
+			n++
+		}
+	}
+	if n < numKeys*70/100 || n > numKeys*80/100 {
+		t.Errorf("%v of %v keys are owned by the instance with weight 3, want about 75%%", n, numKeys)
+	}
+}
+
+func TestOwner(t *testing.T) {
+	instances := testInstances(5)
+	instances[1].Weight = 2
+	for i := 0; i < 1000; i++ {
+		key := "key" + strconv.Itoa(i)
+		if got, want := instances[Owner(key, instances)], Rank(key, instances)[0]; got.Addr != want.Addr {
+			t.Fatalf("owner of %v == %v, want %v", key, got.Addr, want.Addr)
+		}
+	}
+}
+
+type keyedReq struct {
+	Key string
+}
+
+func (r *keyedReq) ShardKey() string {
+	return r.Key
+}
+
+func TestService(t *testing.T) {
+	// replicasOfKey maps the keys to the replicas that have handled them.
+	replicasOfKey := make(map[string]map[int]bool)
+	calls := make([]int, 3)
+	var replicas []nano.Service
+	for i := range calls {
+		i := i
+		replicas = append(replicas, util.NewService("svc", func(c *nano.Ctx, req interface{}) (interface{}, error) {
+			calls[i]++
+			if key, ok := Key(req); ok {
+				if replicasOfKey[key] == nil {
+					replicasOfKey[key] = make(map[int]bool)
+				}
+				replicasOfKey[key][i] = true
+			}
+			return nil, nil
+		}))
+	}
+	client := nano.NewClient(NewService(replicas...), "test")
+
+	for i := 0; i < 10; i++ {
+		for _, key := range []string{"a", "b", "c", "d"} {
+			if _, err := client.Request(nil, &keyedReq{Key: key}); err != nil {
+				t.Fatalf("unexpected error :: %v", err)
+			}
+		}
+	}
+	if len(replicasOfKey) != 4 {
+		t.Errorf("%v keys have been handled, want 4", len(replicasOfKey))
+	}
+	for key, r := range replicasOfKey {
+		if len(r) != 1 {
+			t.Errorf("the requests of key %v are handled by replicas %v", key, r)
+		}
+	}
+
+	// The requests without a shard key are distributed round-robin.
+	for i := range calls {
+		calls[i] = 0
+	}
+	for i := 0; i < 3; i++ {
+		client.Request(nil, &keyedReq{})
+	}
+	for i, n := range calls {
+		if n != 1 {
+			t.Errorf("replica %v received %v requests, want 1", i, n)
+		}
+	}
+}
+
+type healthSvc struct {
+	nano.Service
+	status nano.HealthStatus
+	err    error
+}
+
+func (p *healthSvc) Health(ctx context.Context) (nano.HealthStatus, error) {
+	return p.status, p.err
+}
+
+func TestService_Health(t *testing.T) {
+	newReplica := func(status nano.HealthStatus, err error) nano.Service {
+		return &healthSvc{
+			Service: util.NewService("svc", func(c *nano.Ctx, req interface{}) (interface{}, error) {
+				return nil, nil
+			}),
+			status: status,
+			err:    err,
+		}
+	}
+	e1 := errors.New("replica 0 is slow")
+	e2 := errors.New("replica 2 is down")
+	svc := NewService(
+		newReplica(nano.HealthDegraded, e1),
+		newReplica(nano.HealthOK, nil),
+		newReplica(nano.HealthNotReady, e2),
+	)
+
+	status, err := svc.(nano.ServiceHealth).Health(context.Background())
+	if status != nano.HealthNotReady {
+		t.Errorf("status == %v, want %v", status, nano.HealthNotReady)
+	}
+	for _, e := range []error{e1, e2} {
+		if err == nil || !strings.Contains(err.Error(), e.Error()) {
+			t.Errorf("err == %v, want an error containing %q", err, e)
+		}
+	}
+
+	svc = NewService(newReplica(nano.HealthOK, nil), newReplica(nano.HealthDegraded, nil))
+	if status, err := svc.(nano.ServiceHealth).Health(context.Background()); status != nano.HealthDegraded || err != nil {
+		t.Errorf("Health() == %v, %v, want %v, nil", status, err, nano.HealthDegraded)
+	}
+}
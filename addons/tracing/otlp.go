@@ -0,0 +1,167 @@
+package tracing
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/pasztorpisti/nano/addons/log"
+)
+
+// OTLPJSONExporter writes the spans in OTLP JSON format (the JSON encoding of
+// the OpenTelemetry TracesData protobuf message), one span per line. This is
+// the format of the file exporter of the OpenTelemetry Collector so the
+// output can be inspected locally or loaded into tools that understand OTLP.
+type OTLPJSONExporter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewOTLPJSONExporter creates an exporter that writes the spans to w.
+func NewOTLPJSONExporter(w io.Writer) *OTLPJSONExporter {
+	return &OTLPJSONExporter{w: w}
+}
+
+// NewOTLPFileExporter creates an exporter that appends the spans to the file
+// at the given path. The file is created if it doesn't exist.
+func NewOTLPFileExporter(path string) (*OTLPJSONExporter, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return NewOTLPJSONExporter(f), nil
+}
+
+func (p *OTLPJSONExporter) ExportSpan(s *Span) {
+	b, err := json.Marshal(otlpTracesData(s))
+	if err != nil {
+		log.Err(nil, err, "error marshaling span")
+		return
+	}
+	b = append(b, '\n')
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, err := p.w.Write(b); err != nil {
+		log.Err(nil, err, "error writing span")
+	}
+}
+
+// Close closes the underlying writer if it implements io.Closer.
+func (p *OTLPJSONExporter) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if c, ok := p.w.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// The following types mirror the JSON mapping of the OTLP protobuf messages.
+// Note that OTLP JSON encodes trace and span IDs as hex strings and 64 bit
+// integers as decimal strings.
+
+type otlpData struct {
+	ResourceSpans []*otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource      `json:"resource"`
+	ScopeSpans []*otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope   `json:"scope"`
+	Spans []*otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              int            `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Status            otlpStatus     `json:"status"`
+}
+
+type otlpKeyValue struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+const (
+	otlpScopeName = "github.com/pasztorpisti/nano/addons/tracing"
+
+	otlpSpanKindServer = 2
+
+	otlpStatusCodeOK    = 1
+	otlpStatusCodeError = 2
+)
+
+func otlpTracesData(s *Span) *otlpData {
+	span := &otlpSpan{
+		TraceID:           s.TraceID,
+		SpanID:            s.SpanID,
+		ParentSpanID:      s.ParentSpanID,
+		Name:              s.Name(),
+		Kind:              otlpSpanKindServer,
+		StartTimeUnixNano: strconv.FormatInt(s.Start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(s.End.UnixNano(), 10),
+		Status:            otlpStatus{Code: otlpStatusCodeOK},
+	}
+	addAttr := func(key, value string) {
+		if value != "" {
+			span.Attributes = append(span.Attributes, otlpKeyValue{
+				Key:   key,
+				Value: otlpValue{StringValue: value},
+			})
+		}
+	}
+	addAttr("nano.client_name", s.ClientName)
+	addAttr("nano.req_id", s.ReqID)
+	addAttr("nano.req_type", s.ReqType)
+	if s.Err != nil {
+		addAttr("nano.error_code", s.ErrCode())
+		span.Status = otlpStatus{
+			Code:    otlpStatusCodeError,
+			Message: s.Err.Error(),
+		}
+	}
+
+	return &otlpData{
+		ResourceSpans: []*otlpResourceSpans{{
+			Resource: otlpResource{
+				Attributes: []otlpKeyValue{{
+					Key:   "service.name",
+					Value: otlpValue{StringValue: s.ServiceName},
+				}},
+			},
+			ScopeSpans: []*otlpScopeSpans{{
+				Scope: otlpScope{Name: otlpScopeName},
+				Spans: []*otlpSpan{span},
+			}},
+		}},
+	}
+}
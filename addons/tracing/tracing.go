@@ -0,0 +1,106 @@
+/*
+Package tracing records the spans of the requests sent through nano clients
+and passes the finished spans to an Exporter.
+
+The span context of the requests (nano.Ctx.Trace) is created by
+nano.Client.Request and propagated by the transport layer. This package only
+records the timing and the outcome of the spans:
+
+	ss := nano.NewServiceSetOpts(nano.ServiceSetOpts{
+		Services:    services,
+		Middlewares: []nano.Middleware{tracing.Middleware(exporter)},
+	})
+*/
+package tracing
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/pasztorpisti/nano"
+	"github.com/pasztorpisti/nano/addons/util"
+)
+
+// Span is a finished span of a request.
+type Span struct {
+	TraceID string
+	SpanID  string
+	// ParentSpanID is empty for the root span of the trace.
+	ParentSpanID string
+
+	// ServiceName is the name of the service that handled the request.
+	ServiceName string
+	// ClientName is the name of the entity that sent the request.
+	ClientName string
+	ReqID      string
+	// ReqType is the go type of the request object.
+	ReqType string
+
+	Start time.Time
+	End   time.Time
+
+	// Err is the error returned by the service. Nil if the request succeeded.
+	Err error
+}
+
+// Name returns the name of the span in "ServiceName ReqType" format.
+func (s *Span) Name() string {
+	return s.ServiceName + " " + s.ReqType
+}
+
+// ErrCode returns the nano error code of Err. Empty if Err has no code.
+func (s *Span) ErrCode() string {
+	return util.GetErrCode(s.Err)
+}
+
+// Exporter receives the finished spans. ExportSpan is called concurrently
+// from the goroutines that handle the requests so implementations have to be
+// thread safe and should return quickly.
+type Exporter interface {
+	ExportSpan(s *Span)
+}
+
+// ExporterFunc is an Exporter implemented by a function.
+type ExporterFunc func(s *Span)
+
+func (f ExporterFunc) ExportSpan(s *Span) {
+	f(s)
+}
+
+// Middleware returns a nano.Middleware that records a span for each request
+// that has a sampled nano.Ctx.Trace and exports it after the request has
+// been handled. The span of a panicking request has an error with the
+// nano.PanicErrorCode code.
+func Middleware(exp Exporter) nano.Middleware {
+	return func(next nano.RequestHandler) nano.RequestHandler {
+		return func(caller, callee *nano.Ctx, req interface{}) (resp interface{}, err error) {
+			sc := callee.Trace
+			if sc == nil || !sc.Sampled {
+				return next(caller, callee, req)
+			}
+
+			span := &Span{
+				TraceID:      sc.TraceID,
+				SpanID:       sc.SpanID,
+				ParentSpanID: sc.ParentSpanID,
+				ServiceName:  callee.Svc.Name(),
+				ClientName:   callee.ClientName,
+				ReqID:        callee.ReqID,
+				ReqType:      fmt.Sprintf("%T", req),
+				Start:        time.Now(),
+			}
+			panicked := true
+			defer func() {
+				span.End = time.Now()
+				span.Err = err
+				if panicked {
+					span.Err = util.ErrCode(nil, nano.PanicErrorCode, "service panicked")
+				}
+				exp.ExportSpan(span)
+			}()
+			resp, err = next(caller, callee, req)
+			panicked = false
+			return
+		}
+	}
+}
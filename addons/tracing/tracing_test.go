@@ -0,0 +1,211 @@
+package tracing
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/pasztorpisti/nano"
+	"github.com/pasztorpisti/nano/addons/util"
+)
+
+type Req struct{}
+
+type recorder struct {
+	mu    sync.Mutex
+	spans []*Span
+}
+
+func (p *recorder) ExportSpan(s *Span) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.spans = append(p.spans, s)
+}
+
+func newTestServiceSet(exp Exporter, svc2Err error) nano.ServiceSet {
+	var svc2Client nano.Client
+	svc1 := util.NewServiceOpts(util.ServiceOpts{
+		Name: "svc1",
+		Handler: func(c *nano.Ctx, req interface{}) (interface{}, error) {
+			return svc2Client.Request(c, req)
+		},
+		Init: func(cs nano.ClientSet) error {
+			svc2Client = cs.LookupClient("svc2")
+			return nil
+		},
+	})
+	svc2 := util.NewService("svc2", func(c *nano.Ctx, req interface{}) (interface{}, error) {
+		return nil, svc2Err
+	})
+	return nano.NewServiceSetOpts(nano.ServiceSetOpts{
+		Services:    []nano.Service{svc1, svc2},
+		Middlewares: []nano.Middleware{Middleware(exp)},
+	})
+}
+
+func TestMiddleware(t *testing.T) {
+	rec := &recorder{}
+	ss := newTestServiceSet(rec, nil)
+	_, err := nano.NewClientSet(ss, "test").LookupClient("svc1").Request(nil, &Req{})
+	if err != nil {
+		t.Errorf("unexpected error :: %v", err)
+	}
+
+	if len(rec.spans) != 2 {
+		t.Errorf("got %v spans, want 2", len(rec.spans))
+		t.FailNow()
+	}
+	// The spans are exported when they finish so the child comes first.
+	child, parent := rec.spans[0], rec.spans[1]
+	if parent.ServiceName != "svc1" || parent.ClientName != "test" {
+		t.Errorf("unexpected parent span: %+v", parent)
+	}
+	if child.ServiceName != "svc2" || child.ClientName != "svc1" {
+		t.Errorf("unexpected child span: %+v", child)
+	}
+	if parent.ParentSpanID != "" {
+		t.Errorf("parent.ParentSpanID == %q, want empty", parent.ParentSpanID)
+	}
+	if child.ParentSpanID != parent.SpanID {
+		t.Errorf("child.ParentSpanID == %q, want %q", child.ParentSpanID, parent.SpanID)
+	}
+	if child.TraceID != parent.TraceID {
+		t.Errorf("child.TraceID == %q, want %q", child.TraceID, parent.TraceID)
+	}
+	if child.ReqID != parent.ReqID {
+		t.Errorf("child.ReqID == %q, want %q", child.ReqID, parent.ReqID)
+	}
+	if child.ReqType != "*tracing.Req" {
+		t.Errorf("child.ReqType == %q, want %q", child.ReqType, "*tracing.Req")
+	}
+	if parent.Start.After(child.Start) || parent.End.Before(child.End) {
+		t.Error("the parent span doesn't contain the child span")
+	}
+}
+
+func TestMiddleware_Not_Sampled(t *testing.T) {
+	rec := &recorder{}
+	ss := newTestServiceSet(rec, nil)
+	c := &nano.Ctx{
+		Trace: &nano.SpanContext{
+			TraceID: "0af7651916cd43dd8448eb211c80319c",
+			SpanID:  "b7ad6b7169203331",
+		},
+	}
+	_, err := nano.NewClientSet(ss, "test").LookupClient("svc1").Request(c, &Req{})
+	if err != nil {
+		t.Errorf("unexpected error :: %v", err)
+	}
+	if len(rec.spans) != 0 {
+		t.Errorf("got %v spans, want 0", len(rec.spans))
+	}
+}
+
+func TestMiddleware_Panic(t *testing.T) {
+	rec := &recorder{}
+	ss := nano.NewServiceSetOpts(nano.ServiceSetOpts{
+		Services: []nano.Service{util.NewService("svc", func(c *nano.Ctx, req interface{}) (interface{}, error) {
+			panic("test panic")
+		})},
+		Middlewares: []nano.Middleware{Middleware(rec)},
+	})
+	origLogPanic := nano.LogPanic
+	defer func() {
+		nano.LogPanic = origLogPanic
+	}()
+	nano.LogPanic = func(c *nano.Ctx, err *nano.PanicError) {}
+
+	_, err := nano.NewClientSet(ss, "test").LookupClient("svc").Request(nil, &Req{})
+	if util.GetErrCode(err) != nano.PanicErrorCode {
+		t.Errorf("err == %v, want a %v error", err, nano.PanicErrorCode)
+	}
+	if len(rec.spans) != 1 {
+		t.Errorf("got %v spans, want 1", len(rec.spans))
+		t.FailNow()
+	}
+	if code := rec.spans[0].ErrCode(); code != nano.PanicErrorCode {
+		t.Errorf("span error code == %q, want %q", code, nano.PanicErrorCode)
+	}
+}
+
+func TestOTLPJSONExporter(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	ss := newTestServiceSet(NewOTLPJSONExporter(buf),
+		util.ErrCode(nil, "C-TEST", "test error"))
+	_, err := nano.NewClientSet(ss, "test").LookupClient("svc1").Request(nil, &Req{})
+	if err == nil {
+		t.Error("unexpected success")
+	}
+
+	lines := bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n"))
+	if len(lines) != 2 {
+		t.Errorf("got %v lines, want 2", len(lines))
+		t.FailNow()
+	}
+	var data struct {
+		ResourceSpans []struct {
+			Resource struct {
+				Attributes []struct {
+					Key   string
+					Value struct{ StringValue string }
+				}
+			}
+			ScopeSpans []struct {
+				Spans []struct {
+					TraceID           string
+					SpanID            string
+					ParentSpanID      string
+					Name              string
+					StartTimeUnixNano string
+					Status            struct {
+						Code    int
+						Message string
+					}
+				}
+			}
+		}
+	}
+	err = json.Unmarshal(lines[0], &data)
+	if err != nil {
+		t.Errorf("error unmarshaling %q :: %v", lines[0], err)
+		t.FailNow()
+	}
+	rs := data.ResourceSpans[0]
+	if rs.Resource.Attributes[0].Key != "service.name" ||
+		rs.Resource.Attributes[0].Value.StringValue != "svc2" {
+		t.Errorf("unexpected resource: %+v", rs.Resource)
+	}
+	span := rs.ScopeSpans[0].Spans[0]
+	if len(span.TraceID) != 32 || len(span.SpanID) != 16 || len(span.ParentSpanID) != 16 {
+		t.Errorf("invalid IDs: %+v", span)
+	}
+	if span.Name != "svc2 *tracing.Req" {
+		t.Errorf("span.Name == %q", span.Name)
+	}
+	if span.StartTimeUnixNano == "" {
+		t.Error("missing StartTimeUnixNano")
+	}
+	if span.Status.Code != otlpStatusCodeError || span.Status.Message != "test error" {
+		t.Errorf("span.Status == %+v", span.Status)
+	}
+}
+
+func TestOTLPJSONExporter_Close(t *testing.T) {
+	c := &closer{}
+	err := NewOTLPJSONExporter(c).Close()
+	if err != errClose {
+		t.Errorf("err == %v, want %v", err, errClose)
+	}
+}
+
+var errClose = errors.New("close")
+
+type closer struct {
+	bytes.Buffer
+}
+
+func (*closer) Close() error {
+	return errClose
+}
@@ -2,6 +2,7 @@ package http
 
 import (
 	"bytes"
+	"context"
 	"io"
 	"net/http"
 	"reflect"
@@ -14,10 +15,84 @@ import (
 )
 
 type ClientOptions struct {
-	Client        *http.Client
-	Discoverer    discovery.Discoverer
+	Client     *http.Client
+	Discoverer discovery.Discoverer
+	// Resolver locates the instances of the target services within the
+	// deadline of the request. Defaults to discovery.NewResolver(Discoverer).
+	// The Scheme and PathPrefix of the instances are used to build the URLs
+	// so a client can send requests to both TLS and plaintext instances.
+	Resolver      discovery.Resolver
 	Serializer    *serialization.ClientSideSerializer
 	PrefixURLPath bool
+
+	// NewCircuitBreaker creates the circuit breaker of the target service
+	// (e.g.: a *circuitbreaker.Breaker of the circuitbreaker addon). Nil
+	// means no circuit breaker. Transport errors and 502, 503 and 504
+	// responses are counted as failures.
+	NewCircuitBreaker func(service string) CircuitBreaker
+
+	// Retry is the retry policy of the client. Nil means no retries.
+	// EndpointConfig.Retry overrides it.
+	Retry *config.RetryPolicy
+	// RetryBudget limits the number of retries. Nil means no limit.
+	RetryBudget *RetryBudget
+
+	// NewLoadBalancer creates the load balancer of the target service
+	// (e.g.: a *loadbalancer.Balancer of the loadbalancer addon) that
+	// balances the requests across the instances returned by the Resolver.
+	// Nil means no load balancer: the client sends each request to a
+	// random instance. The failures reported to the load balancer are the
+	// same as the ones reported to the circuit breaker.
+	NewLoadBalancer func(service string) LoadBalancer
+	// Zone enables zone-aware routing if it isn't nil.
+	Zone *ZoneOptions
+	// ShardRanker routes the requests that have a shard key to the instance
+	// that owns the key (e.g.: sharding.Ranker of the sharding addon). Nil
+	// means that the shard keys are ignored.
+	ShardRanker ShardRanker
+}
+
+// CircuitBreaker makes the requests to an unavailable target service fail
+// fast.
+type CircuitBreaker interface {
+	// Allow returns nil and a done func if the request can be sent. The
+	// done func has to be called exactly once with the outcome of the
+	// request. The client returns the error of a rejected request as it is.
+	Allow() (done func(config.Outcome), err error)
+	// Closed returns false while the breaker rejects some or all requests.
+	Closed() bool
+}
+
+// LoadBalancer balances the requests across the instances of the target
+// service.
+type LoadBalancer interface {
+	// Pick chooses an instance from the instances accepted by filter. A nil
+	// filter accepts all instances. The instances parameter contains all
+	// instances of the target service. The done func has to be called
+	// exactly once with the outcome of the request when it has finished.
+	Pick(instances []discovery.Instance, filter func(discovery.Instance) bool) (
+		inst discovery.Instance, done func(config.Outcome), err error)
+	// Load returns the number of the outstanding requests of the instance
+	// with the given address and whether the instance is ejected.
+	Load(addr string) (outstanding int, ejected bool)
+}
+
+// ShardRanker chooses the instance of the target service that owns the
+// shard key of a request.
+type ShardRanker interface {
+	// Key returns the shard key of req. The returned bool is false if the
+	// request can be sent to any instance.
+	Key(req interface{}) (string, bool)
+	// Owner returns the index of the instance that owns the key. The
+	// instances parameter isn't empty.
+	Owner(key string, instances []discovery.Instance) int
+}
+
+// CircuitBreakerClient is implemented by the services returned by NewClient.
+type CircuitBreakerClient interface {
+	// CircuitBreaker returns the circuit breaker of the client. Nil if the
+	// circuit breaker isn't enabled in the ClientOptions.
+	CircuitBreaker() CircuitBreaker
 }
 
 // DefaultClientOptions is used by NewClient when its opts parameter is nil.
@@ -40,10 +115,36 @@ func NewClient(opts *ClientOptions, cfg *config.ServiceConfig) nano.Service {
 		endpoints[ep.ReqType] = ep
 	}
 
+	var breaker CircuitBreaker
+	if opts.NewCircuitBreaker != nil {
+		breaker = opts.NewCircuitBreaker(cfg.ServiceName)
+	}
+
+	var balancer LoadBalancer
+	if opts.NewLoadBalancer != nil {
+		balancer = opts.NewLoadBalancer(cfg.ServiceName)
+	}
+
+	var zone *zoneRouter
+	if opts.Zone != nil {
+		zone = newZoneRouter(cfg.ServiceName, *opts.Zone)
+	}
+
+	hedging := make(map[*config.EndpointConfig]*hedger)
+	for _, ep := range cfg.Endpoints {
+		if ep.Hedging != nil && ep.IsIdempotent() {
+			hedging[ep] = newHedger(ep.Hedging)
+		}
+	}
+
 	return &client{
 		svcName:   cfg.ServiceName,
 		endpoints: endpoints,
 		opts:      opts,
+		breaker:   breaker,
+		balancer:  balancer,
+		zone:      zone,
+		hedging:   hedging,
 	}
 }
 
@@ -54,11 +155,23 @@ func (p *ClientOptions) client() *http.Client {
 	return p.Client
 }
 
-// client implements the nano.Service interface.
+func (p *ClientOptions) resolver() discovery.Resolver {
+	if p.Resolver == nil {
+		return discovery.NewResolver(p.Discoverer)
+	}
+	return p.Resolver
+}
+
+// client implements the nano.Service, nano.ServiceHealth,
+// CircuitBreakerClient and ZoneRoutingClient interfaces.
 type client struct {
 	svcName   string
 	endpoints map[reflect.Type]*config.EndpointConfig
 	opts      *ClientOptions
+	breaker   CircuitBreaker
+	balancer  LoadBalancer
+	zone      *zoneRouter
+	hedging   map[*config.EndpointConfig]*hedger
 }
 
 func (p *client) Name() string {
@@ -69,6 +182,25 @@ func (p *client) Init(cs nano.ClientSet) error {
 	return nil
 }
 
+func (p *client) CircuitBreaker() CircuitBreaker {
+	return p.breaker
+}
+
+func (p *client) ZoneStats() ZoneStats {
+	if p.zone == nil {
+		return ZoneStats{}
+	}
+	return p.zone.stats()
+}
+
+// Health reports the client degraded while its circuit breaker is open.
+func (p *client) Health(ctx context.Context) (nano.HealthStatus, error) {
+	if p.breaker != nil && !p.breaker.Closed() {
+		return nano.HealthDegraded, p.Err(nil, "circuit breaker isn't closed")
+	}
+	return nano.HealthOK, nil
+}
+
 func (p *client) Handle(c *nano.Ctx, req interface{}) (resp interface{}, err error) {
 	reqType := reflect.TypeOf(req)
 	if reqType.Kind() != reflect.Ptr {
@@ -79,42 +211,247 @@ func (p *client) Handle(c *nano.Ctx, req interface{}) (resp interface{}, err err
 		return nil, p.Err(nil, "can't handle request type "+reqType.String())
 	}
 
-	addr, err := p.opts.Discoverer.Discover(p.svcName)
+	policy := p.retryPolicy(ec)
+	if policy == nil {
+		resp, _, err = p.attempt(c, ec, req)
+		return
+	}
+
+	if p.opts.RetryBudget != nil {
+		p.opts.RetryBudget.deposit()
+	}
+	for attempt := 1; ; attempt++ {
+		var transportErr bool
+		resp, transportErr, err = p.attempt(c, ec, req)
+		if err == nil || !p.shouldRetry(policy, attempt, transportErr, err) {
+			return
+		}
+		if !waitBackoff(c, policy, attempt, err) {
+			return
+		}
+	}
+}
+
+// attempt sends the request through the circuit breaker (if any).
+// transportErr is true if err is a transport error.
+func (p *client) attempt(c *nano.Ctx, ec *config.EndpointConfig, req interface{},
+) (resp interface{}, transportErr bool, err error) {
+	if p.breaker == nil {
+		r := p.send(c, ec, req)
+		return r.resp, r.transportErr, r.err
+	}
+
+	done, err := p.breaker.Allow()
 	if err != nil {
-		return nil, err
+		return nil, false, err
+	}
+	// The deferred done func frees the trial slot of a half-open breaker
+	// even if send panics. A panic says nothing about the target so it is
+	// ignored.
+	o := config.OutcomeIgnored
+	defer func() {
+		done(o)
+	}()
+	r := p.send(c, ec, req)
+	o = outcome(c, r)
+	return r.resp, r.transportErr, r.err
+}
+
+// outcome classifies the result of a request for the circuit breaker and the
+// load balancer.
+func outcome(c *nano.Ctx, r sendResult) config.Outcome {
+	if c != nil && c.Context != nil && c.Context.Err() != nil {
+		// The caller has given up so the error says nothing about the target.
+		return config.OutcomeIgnored
 	}
-	url := "http://" + addr
+	switch r.status {
+	case 0:
+		if r.err != nil {
+			return config.OutcomeFailure
+		}
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return config.OutcomeFailure
+	}
+	return config.OutcomeSuccess
+}
+
+// sendResult is the result of sending a request to an instance of the
+// target service.
+type sendResult struct {
+	resp interface{}
+	err  error
+	// status is the status code of the response, zero if no response has
+	// been received.
+	status int
+	// transportErr is true if err is a transport error that is worth
+	// retrying.
+	transportErr bool
+}
+
+// send sends the request to the target service. The requests with a shard
+// key are sent to the owner of the key, the others are hedged if the
+// endpoint has a HedgingPolicy.
+func (p *client) send(c *nano.Ctx, ec *config.EndpointConfig, req interface{}) sendResult {
+	if p.opts.ShardRanker != nil {
+		if key, ok := p.opts.ShardRanker.Key(req); ok {
+			return p.sendSharded(c, ec, req, key)
+		}
+	}
+	if h, ok := p.hedging[ec]; ok {
+		return p.sendHedged(c, ec, req, h)
+	}
+	instances, filter, err := p.resolve(c)
+	if err != nil {
+		return sendResult{err: err, transportErr: true}
+	}
+	if p.balancer == nil {
+		candidates := accepted(instances, filter)
+		inst := candidates[int(randFloat64()*float64(len(candidates)))]
+		return p.sendTo(c, ec, req, inst)
+	}
+	inst, done, err := p.balancer.Pick(instances, filter)
+	if err != nil {
+		return sendResult{err: p.Err(err, "load balancer failure"), transportErr: true}
+	}
+	return p.sendToPicked(c, ec, req, inst, done)
+}
+
+// sendSharded sends the request to the owner of the shard key. If the
+// load balancer has ejected the owner then the request is sent to the next
+// instance in the rank of the key. Zone-aware routing doesn't apply because
+// the owner of a key has to be the same for all clients.
+func (p *client) sendSharded(c *nano.Ctx, ec *config.EndpointConfig, req interface{},
+	key string) sendResult {
+	instances, err := p.resolveAll(c)
+	if err != nil {
+		return sendResult{err: err, transportErr: true}
+	}
+	if p.balancer == nil {
+		return p.sendTo(c, ec, req, instances[p.opts.ShardRanker.Owner(key, instances)])
+	}
+
+	candidates := accepted(instances, func(inst discovery.Instance) bool {
+		_, ejected := p.balancer.Load(inst.Addr)
+		return !ejected
+	})
+	if len(candidates) == 0 {
+		candidates = instances
+	}
+	owner := candidates[p.opts.ShardRanker.Owner(key, candidates)]
+	inst, done, err := p.balancer.Pick(instances, func(inst discovery.Instance) bool {
+		return inst.Addr == owner.Addr
+	})
+	if err != nil {
+		return sendResult{err: p.Err(err, "load balancer failure"), transportErr: true}
+	}
+	return p.sendToPicked(c, ec, req, inst, done)
+}
+
+// resolve returns all instances of the target service and the filter of the
+// instances the next request can be sent to. A nil filter accepts all
+// instances.
+func (p *client) resolve(c *nano.Ctx) (
+	instances []discovery.Instance, filter func(discovery.Instance) bool, err error) {
+	instances, err = p.resolveAll(c)
+	if err != nil || p.zone == nil {
+		return instances, nil, err
+	}
+	return instances, p.zone.filter(instances, p.balancer), nil
+}
+
+// resolveAll returns all instances of the target service.
+func (p *client) resolveAll(c *nano.Ctx) ([]discovery.Instance, error) {
+	ctx := context.Background()
+	if c != nil && c.Context != nil {
+		ctx = c.Context
+	}
+	return p.opts.resolver().Resolve(ctx, p.svcName)
+}
+
+// accepted returns the instances accepted by filter. A nil filter accepts
+// all instances.
+func accepted(instances []discovery.Instance,
+	filter func(discovery.Instance) bool) []discovery.Instance {
+	if filter == nil {
+		return instances
+	}
+	res := make([]discovery.Instance, 0, len(instances))
+	for _, inst := range instances {
+		if filter(inst) {
+			res = append(res, inst)
+		}
+	}
+	return res
+}
+
+// sendToPicked sends the request to an instance picked by the load balancer
+// and reports the outcome of the request to the done func of the pick. A
+// panic is ignored like by the circuit breaker.
+func (p *client) sendToPicked(c *nano.Ctx, ec *config.EndpointConfig, req interface{},
+	inst discovery.Instance, done func(config.Outcome)) sendResult {
+	o := config.OutcomeIgnored
+	defer func() {
+		done(o)
+	}()
+	r := p.sendTo(c, ec, req, inst)
+	o = outcome(c, r)
+	return r
+}
+
+// sendTo sends the request to the given instance.
+func (p *client) sendTo(c *nano.Ctx, ec *config.EndpointConfig, req interface{},
+	inst discovery.Instance) sendResult {
+	scheme := inst.Scheme
+	if scheme == "" {
+		scheme = "http"
+	}
+	url := scheme + "://" + inst.Addr + inst.PathPrefix
 	if p.opts.PrefixURLPath {
 		url += "/" + p.svcName
 	}
 	url += ec.Path
+	if p.zone != nil {
+		p.zone.count(inst)
+	}
 
 	var reqBody io.Reader
 	var header http.Header
 	h, body, err := p.opts.Serializer.SerializeRequest(ec, c, req)
 	if err != nil {
-		return nil, p.Err(err, "error serializing request")
+		return sendResult{err: p.Err(err, "error serializing request")}
 	}
 	reqBody = bytes.NewReader(body)
 	header = h
 
-	httpReq, err := http.NewRequest(ec.Method, url, reqBody)
+	ctx := context.Background()
+	if c != nil && c.Context != nil {
+		ctx = c.Context
+	}
+	if err := ctx.Err(); err != nil {
+		return sendResult{err: p.Err(err, "request context is done")}
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, ec.Method, url, reqBody)
 	if err != nil {
-		return nil, p.Err(err, "error creating request")
+		return sendResult{err: p.Err(err, "error creating request")}
 	}
 	httpReq.Header = header
 
 	httpResp, err := p.opts.client().Do(httpReq)
 	if err != nil {
-		return nil, p.Err(err, "http request failure")
+		return sendResult{err: p.Err(err, "http request failure"), transportErr: true}
 	}
 	defer httpResp.Body.Close()
+	status := httpResp.StatusCode
 
 	respObj, respErr, err := p.opts.Serializer.DeserializeResponse(ec, c, httpResp)
 	if err != nil {
-		return nil, p.Err(err, "error deserializing response")
+		return sendResult{err: p.Err(err, "error deserializing response"), status: status}
+	}
+	if respErr != nil {
+		respErr = withRetryAfter(httpResp.Header, respErr)
 	}
-	return respObj, respErr
+	return sendResult{resp: respObj, err: respErr, status: status}
 }
 
 func (p *client) Err(cause error, msg string) error {
@@ -3,16 +3,26 @@ package http
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"reflect"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/pasztorpisti/nano"
+	"github.com/pasztorpisti/nano/addons/circuitbreaker"
+	"github.com/pasztorpisti/nano/addons/discovery"
 	"github.com/pasztorpisti/nano/addons/discovery/static"
+	"github.com/pasztorpisti/nano/addons/loadbalancer"
+	"github.com/pasztorpisti/nano/addons/sharding"
 	"github.com/pasztorpisti/nano/addons/transport/http/config"
+	"github.com/pasztorpisti/nano/addons/transport/http/serialization"
 	json_ser "github.com/pasztorpisti/nano/addons/transport/http/serialization/json"
 	"github.com/pasztorpisti/nano/addons/util"
 )
@@ -72,6 +82,12 @@ var clientCFG = &config.ServiceConfig{
 }
 
 func newClient(prefixURLPath bool, h http.HandlerFunc) (client nano.Service, cleanup func()) {
+	return newClientOpts(&ClientOptions{PrefixURLPath: prefixURLPath}, h)
+}
+
+// newClientOpts creates a client with the given options after setting the
+// Client, Discoverer and Serializer fields of opts.
+func newClientOpts(opts *ClientOptions, h http.HandlerFunc) (client nano.Service, cleanup func()) {
 	server := httptest.NewServer(h)
 
 	// transport that routes all traffic to the test server
@@ -81,12 +97,9 @@ func newClient(prefixURLPath bool, h http.HandlerFunc) (client nano.Service, cle
 		},
 	}
 
-	opts := &ClientOptions{
-		Client:        &http.Client{Transport: transport},
-		Discoverer:    static.Discoverer{clientSVCName: clientSVCName + ":8000"},
-		Serializer:    json_ser.ClientSideSerializer,
-		PrefixURLPath: prefixURLPath,
-	}
+	opts.Client = &http.Client{Transport: transport}
+	opts.Discoverer = static.Discoverer{clientSVCName: clientSVCName + ":8000"}
+	opts.Serializer = json_ser.ClientSideSerializer
 
 	client = NewClient(opts, clientCFG)
 	cleanup = server.Close
@@ -362,3 +375,841 @@ func TestClient_ClientErrorResponse(t *testing.T) {
 func TestClient_ServerErrorResponse(t *testing.T) {
 	testClient_ErrorResponse(t, "S-MYERROR")
 }
+
+func TestClient_Timeout(t *testing.T) {
+	var timeoutHeader string
+	client, cleanup := newClient(true, func(w http.ResponseWriter, r *http.Request) {
+		timeoutHeader = r.Header.Get(json_ser.HeaderTimeout)
+	})
+	defer cleanup()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	c := newCtx(client)
+	c.Context = ctx
+	_, err := client.Handle(c, &ClientGetDirReq{})
+	if err != nil {
+		t.Errorf("client error :: %v", err)
+		t.FailNow()
+	}
+
+	ms, err := strconv.Atoi(timeoutHeader)
+	if err != nil || ms <= 0 || ms > 60000 {
+		t.Errorf("timeout header == %q, want a value in (0, 60000]", timeoutHeader)
+	}
+}
+
+func TestClient_ContextDone(t *testing.T) {
+	called := false
+	client, cleanup := newClient(true, func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	})
+	defer cleanup()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	c := newCtx(client)
+	c.Context = ctx
+	_, err := client.Handle(c, &ClientGetDirReq{})
+	if err == nil {
+		t.Error("unexpected success")
+	}
+	if called {
+		t.Error("the request has been sent with a canceled context")
+	}
+}
+
+func TestClient_RetryAfter(t *testing.T) {
+	client, cleanup := newClient(true, func(w http.ResponseWriter, r *http.Request) {
+		respBody, _ := json.Marshal(&json_ser.ErrorResponse{
+			Code: config.ErrorCodeRateLimited,
+			Msg:  "rate limited",
+		})
+		w.Header().Set("Content-Type", clientJSONContentType)
+		w.Header().Set("Retry-After", "3")
+		w.WriteHeader(config.ErrorCodeToHTTPStatus(config.ErrorCodeRateLimited))
+		w.Write(respBody)
+	})
+	defer cleanup()
+
+	_, err := client.Handle(newCtx(client), &ClientGetDirReq{})
+	if v := util.GetErrCode(err); v != config.ErrorCodeRateLimited {
+		t.Errorf("error code == %q, want %q", v, config.ErrorCodeRateLimited)
+	}
+	d, ok := util.GetRetryAfter(err)
+	if !ok || d != 3*time.Second {
+		t.Errorf("retry hint == %v %v, want %v", d, ok, 3*time.Second)
+	}
+}
+
+func newBreaker(opts circuitbreaker.Options) func(service string) CircuitBreaker {
+	return func(service string) CircuitBreaker {
+		return circuitbreaker.New(service, opts)
+	}
+}
+
+func TestClient_CircuitBreaker(t *testing.T) {
+	calls := 0
+	client, cleanup := newClientOpts(&ClientOptions{
+		NewCircuitBreaker: newBreaker(circuitbreaker.Options{
+			FailureThreshold: 2,
+			OpenTimeout:      time.Hour,
+		}),
+	}, func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	defer cleanup()
+
+	for i := 0; i < 2; i++ {
+		_, err := client.Handle(newCtx(client), &ClientGetDirReq{})
+		if err == nil {
+			t.Error("unexpected success")
+		}
+	}
+	_, err := client.Handle(newCtx(client), &ClientGetDirReq{})
+	if code := util.GetErrCode(err); code != config.ErrorCodeCircuitOpen {
+		t.Errorf("error code == %q, want %q", code, config.ErrorCodeCircuitOpen)
+	}
+	if calls != 2 {
+		t.Errorf("server called %v times, want 2", calls)
+	}
+
+	breaker := client.(CircuitBreakerClient).CircuitBreaker().(*circuitbreaker.Breaker)
+	if s := breaker.State(); s != circuitbreaker.Open {
+		t.Errorf("breaker state == %v, want %v", s, circuitbreaker.Open)
+	}
+	status, err := client.(nano.ServiceHealth).Health(context.Background())
+	if status != nano.HealthDegraded || err == nil {
+		t.Errorf("health == %v %v, want %v and an error", status, err, nano.HealthDegraded)
+	}
+}
+
+func TestClient_CircuitBreaker_Ignores_Canceled_Requests(t *testing.T) {
+	client, cleanup := newClientOpts(&ClientOptions{
+		NewCircuitBreaker: newBreaker(circuitbreaker.Options{FailureThreshold: 1}),
+	}, func(w http.ResponseWriter, r *http.Request) {})
+	defer cleanup()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	c := newCtx(client)
+	c.Context = ctx
+	client.Handle(c, &ClientGetDirReq{})
+
+	breaker := client.(CircuitBreakerClient).CircuitBreaker().(*circuitbreaker.Breaker)
+	if s := breaker.State(); s != circuitbreaker.Closed {
+		t.Errorf("breaker state == %v, want %v", s, circuitbreaker.Closed)
+	}
+}
+
+type panicReqSerializer struct{}
+
+func (panicReqSerializer) SerializeRequest(ec *config.EndpointConfig, c *nano.Ctx,
+	req interface{}) (http.Header, []byte, error) {
+	panic("test")
+}
+
+func TestClient_CircuitBreaker_Panic(t *testing.T) {
+	opts := &ClientOptions{
+		NewCircuitBreaker: newBreaker(circuitbreaker.Options{
+			FailureThreshold: 1,
+			OpenTimeout:      time.Millisecond,
+		}),
+	}
+	client, cleanup := newClientOpts(opts, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	defer cleanup()
+
+	client.Handle(newCtx(client), &ClientGetDirReq{})
+	time.Sleep(2 * time.Millisecond)
+
+	// The trial request of the half-open breaker panics.
+	serializer := opts.Serializer
+	opts.Serializer = &serialization.ClientSideSerializer{
+		ReqSerializer:    panicReqSerializer{},
+		RespDeserializer: serializer.RespDeserializer,
+	}
+	func() {
+		defer func() {
+			if v := recover(); v == nil {
+				t.Error("request didn't panic")
+			}
+		}()
+		client.Handle(newCtx(client), &ClientGetDirReq{})
+	}()
+	opts.Serializer = serializer
+
+	// The panicking request has freed its trial slot.
+	_, err := client.Handle(newCtx(client), &ClientGetDirReq{})
+	if code := util.GetErrCode(err); code == config.ErrorCodeCircuitOpen {
+		t.Errorf("unexpected error :: %v", err)
+	}
+}
+
+// newRetryTestClient returns a client whose server responds with the error
+// codes of the given list in order and with success after the list runs out.
+func newRetryTestClient(opts *ClientOptions, errCodes ...string) (client nano.Service, calls *int, cleanup func()) {
+	calls = new(int)
+	client, cleanup = newClientOpts(opts, func(w http.ResponseWriter, r *http.Request) {
+		*calls++
+		if *calls > len(errCodes) {
+			w.Header().Set("Content-Type", clientJSONContentType)
+			w.Write([]byte("{}"))
+			return
+		}
+		code := errCodes[*calls-1]
+		respBody, _ := json.Marshal(&json_ser.ErrorResponse{Code: code, Msg: code})
+		w.Header().Set("Content-Type", clientJSONContentType)
+		w.WriteHeader(config.ErrorCodeToHTTPStatus(code))
+		w.Write(respBody)
+	})
+	return
+}
+
+var testRetryPolicy = &config.RetryPolicy{
+	MaxAttempts:    3,
+	InitialBackoff: time.Millisecond,
+}
+
+func TestClient_Retry(t *testing.T) {
+	client, calls, cleanup := newRetryTestClient(&ClientOptions{Retry: testRetryPolicy},
+		config.ErrorCodeOverloaded, config.ErrorCodeOverloaded)
+	defer cleanup()
+
+	_, err := client.Handle(newCtx(client), &ClientGetReq{})
+	if err != nil {
+		t.Errorf("unexpected error :: %v", err)
+	}
+	if *calls != 3 {
+		t.Errorf("server called %v times, want 3", *calls)
+	}
+}
+
+func TestClient_Retry_MaxAttempts(t *testing.T) {
+	client, calls, cleanup := newRetryTestClient(&ClientOptions{Retry: testRetryPolicy},
+		config.ErrorCodeOverloaded, config.ErrorCodeOverloaded, config.ErrorCodeOverloaded)
+	defer cleanup()
+
+	_, err := client.Handle(newCtx(client), &ClientGetReq{})
+	if code := util.GetErrCode(err); code != config.ErrorCodeOverloaded {
+		t.Errorf("error code == %q, want %q", code, config.ErrorCodeOverloaded)
+	}
+	if *calls != 3 {
+		t.Errorf("server called %v times, want 3", *calls)
+	}
+}
+
+func TestClient_Retry_Not_Retryable(t *testing.T) {
+	// non-retryable error code
+	client, calls, cleanup := newRetryTestClient(&ClientOptions{Retry: testRetryPolicy},
+		config.ErrorCodeServerError)
+	defer cleanup()
+	client.Handle(newCtx(client), &ClientGetReq{})
+	if *calls != 1 {
+		t.Errorf("server called %v times, want 1", *calls)
+	}
+
+	// non-idempotent endpoint
+	client, calls, cleanup = newRetryTestClient(&ClientOptions{Retry: testRetryPolicy},
+		config.ErrorCodeOverloaded)
+	defer cleanup()
+	client.Handle(newCtx(client), &ClientReq{})
+	if *calls != 1 {
+		t.Errorf("server called %v times, want 1", *calls)
+	}
+}
+
+func TestClient_Retry_Deadline(t *testing.T) {
+	client, calls, cleanup := newRetryTestClient(&ClientOptions{
+		Retry: &config.RetryPolicy{
+			MaxAttempts:    3,
+			InitialBackoff: time.Hour,
+			MaxBackoff:     time.Hour,
+		},
+	}, config.ErrorCodeOverloaded)
+	defer cleanup()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	c := newCtx(client)
+	c.Context = ctx
+	_, err := client.Handle(c, &ClientGetReq{})
+	if code := util.GetErrCode(err); code != config.ErrorCodeOverloaded {
+		t.Errorf("error code == %q, want %q", code, config.ErrorCodeOverloaded)
+	}
+	if *calls != 1 {
+		t.Errorf("server called %v times, want 1", *calls)
+	}
+}
+
+func TestClient_Retry_Budget(t *testing.T) {
+	client, calls, cleanup := newRetryTestClient(&ClientOptions{
+		Retry:       testRetryPolicy,
+		RetryBudget: NewRetryBudget(0, 1),
+	}, config.ErrorCodeOverloaded, config.ErrorCodeOverloaded, config.ErrorCodeOverloaded)
+	defer cleanup()
+
+	client.Handle(newCtx(client), &ClientGetReq{})
+	if *calls != 2 {
+		t.Errorf("server called %v times, want 2", *calls)
+	}
+}
+
+func TestClient_Retry_Transport_Error(t *testing.T) {
+	calls := 0
+	client := NewClient(&ClientOptions{
+		Client: &http.Client{Transport: roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+			calls++
+			return nil, errors.New("connection refused")
+		})},
+		Discoverer: static.Discoverer{clientSVCName: clientSVCName + ":8000"},
+		Serializer: json_ser.ClientSideSerializer,
+		Retry:      testRetryPolicy,
+	}, clientCFG)
+
+	_, err := client.Handle(newCtx(client), &ClientGetReq{})
+	if err == nil {
+		t.Error("unexpected success")
+	}
+	if calls != 3 {
+		t.Errorf("transport called %v times, want 3", calls)
+	}
+}
+
+type roundTripperFunc func(r *http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+// hedgedClientCFG returns a copy of clientCFG in which ClientGetReq is hedged.
+func hedgedClientCFG(maxDelay time.Duration) *config.ServiceConfig {
+	cfg := *clientCFG
+	cfg.Endpoints = make([]*config.EndpointConfig, len(clientCFG.Endpoints))
+	for i, ep := range clientCFG.Endpoints {
+		ep2 := *ep
+		if ep2.ReqType == reflect.TypeOf(ClientGetReq{}) {
+			ep2.Hedging = &config.HedgingPolicy{MaxDelay: maxDelay}
+		}
+		cfg.Endpoints[i] = &ep2
+	}
+	return &cfg
+}
+
+// newHedgingTestClient returns a client of a service with two instances:
+// "slow:8000" and "fast:8000". Only ClientGetReq is hedged.
+func newHedgingTestClient(h http.HandlerFunc) (client nano.Service, cleanup func()) {
+	server := httptest.NewServer(h)
+	transport := &http.Transport{
+		Proxy: func(req *http.Request) (*url.URL, error) {
+			return url.Parse(server.URL)
+		},
+	}
+
+	origRand := randFloat64
+	randFloat64 = func() float64 { return 0 }
+	client = NewClient(&ClientOptions{
+		Client: &http.Client{Transport: transport},
+		Discoverer: static.MultiDiscoverer{
+			clientSVCName: {"slow:8000", "fast:8000"},
+		},
+		Serializer: json_ser.ClientSideSerializer,
+	}, hedgedClientCFG(10*time.Millisecond))
+	cleanup = func() {
+		randFloat64 = origRand
+		server.Close()
+	}
+	return
+}
+
+func TestClient_Hedging(t *testing.T) {
+	slowCanceled := make(chan struct{})
+	client, cleanup := newHedgingTestClient(func(w http.ResponseWriter, r *http.Request) {
+		if r.Host == "slow:8000" {
+			select {
+			case <-r.Context().Done():
+				close(slowCanceled)
+			case <-time.After(5 * time.Second):
+			}
+			return
+		}
+		w.Header().Set("Content-Type", clientJSONContentType)
+		w.Write([]byte(`{"S":"fast"}`))
+	})
+	defer cleanup()
+
+	resp, err := client.Handle(newCtx(client), &ClientGetReq{})
+	if err != nil {
+		t.Errorf("unexpected error :: %v", err)
+		t.FailNow()
+	}
+	if s := resp.(*ClientGetResp).S; s != "fast" {
+		t.Errorf("response == %q, want %q", s, "fast")
+	}
+
+	select {
+	case <-slowCanceled:
+	case <-time.After(time.Second):
+		t.Error("the slow request hasn't been canceled")
+	}
+}
+
+func TestClient_Hedging_Transport_Error(t *testing.T) {
+	var calls int32
+	client := NewClient(&ClientOptions{
+		Client: &http.Client{Transport: roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+			atomic.AddInt32(&calls, 1)
+			return nil, errors.New("connection refused")
+		})},
+		Discoverer: static.MultiDiscoverer{
+			clientSVCName: {"a:8000", "b:8000"},
+		},
+		Serializer: json_ser.ClientSideSerializer,
+	}, hedgedClientCFG(time.Hour))
+
+	start := time.Now()
+	_, err := client.Handle(newCtx(client), &ClientGetReq{})
+	if err == nil {
+		t.Error("unexpected success")
+	}
+	// The second request is sent without waiting for the delay.
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("request took %v", d)
+	}
+	if n := atomic.LoadInt32(&calls); n != 2 {
+		t.Errorf("transport called %v times, want 2", n)
+	}
+}
+
+// failingBalancer fails all picks after the first one.
+type failingBalancer struct {
+	picks int
+}
+
+func (b *failingBalancer) Pick(instances []discovery.Instance,
+	filter func(discovery.Instance) bool) (discovery.Instance, func(config.Outcome), error) {
+	b.picks++
+	if b.picks > 1 {
+		return discovery.Instance{}, nil, errors.New("test")
+	}
+	return accepted(instances, filter)[0], func(config.Outcome) {}, nil
+}
+
+func (b *failingBalancer) Load(addr string) (int, bool) {
+	return 0, false
+}
+
+func TestClient_Hedging_Pick_Error(t *testing.T) {
+	var calls int32
+	client := NewClient(&ClientOptions{
+		Client: &http.Client{Transport: roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+			atomic.AddInt32(&calls, 1)
+			return nil, errors.New("connection refused")
+		})},
+		Discoverer: static.MultiDiscoverer{
+			clientSVCName: {"a:8000", "b:8000"},
+		},
+		Serializer: json_ser.ClientSideSerializer,
+		NewLoadBalancer: func(service string) LoadBalancer {
+			return &failingBalancer{}
+		},
+	}, hedgedClientCFG(time.Hour))
+
+	_, err := client.Handle(newCtx(client), &ClientGetReq{})
+	if err == nil || !strings.Contains(err.Error(), "load balancer failure") {
+		t.Errorf("err == %v, want a load balancer failure", err)
+	}
+	// The hedged request isn't sent without an instance.
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Errorf("transport called %v times, want 1", n)
+	}
+}
+
+func TestClient_No_Hedging_Without_Policy(t *testing.T) {
+	var calls int32
+	client, cleanup := newHedgingTestClient(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		time.Sleep(50 * time.Millisecond)
+		w.Header().Set("Content-Type", clientJSONContentType)
+		w.Write([]byte(`{"B0":true}`))
+	})
+	defer cleanup()
+
+	if _, err := client.Handle(newCtx(client), &ClientReq{}); err != nil {
+		t.Errorf("unexpected error :: %v", err)
+	}
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Errorf("server called %v times, want 1", n)
+	}
+}
+
+func TestHedger_Delay(t *testing.T) {
+	h := newHedger(&config.HedgingPolicy{
+		Percentile: 90,
+		MinDelay:   5 * time.Millisecond,
+		MaxDelay:   time.Second,
+	})
+	if d := h.delay(); d != time.Second {
+		t.Errorf("delay without samples == %v, want %v", d, time.Second)
+	}
+	for i := 1; i <= 100; i++ {
+		h.observe(time.Duration(i) * time.Millisecond)
+	}
+	if d := h.delay(); d != 90*time.Millisecond {
+		t.Errorf("delay == %v, want %v", d, 90*time.Millisecond)
+	}
+	for i := 0; i < latencySamples; i++ {
+		h.observe(time.Millisecond)
+	}
+	if d := h.delay(); d != 5*time.Millisecond {
+		t.Errorf("delay == %v, want the MinDelay", d)
+	}
+}
+
+func newBalancer(opts loadbalancer.Options) func(service string) LoadBalancer {
+	return func(service string) LoadBalancer {
+		return loadbalancer.New(opts)
+	}
+}
+
+func TestClient_LoadBalancer(t *testing.T) {
+	var hosts []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hosts = append(hosts, r.Host)
+		w.Header().Set("Content-Type", clientJSONContentType)
+		if r.Host == "bad:8000" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		w.Write([]byte("{}"))
+	}))
+	defer server.Close()
+
+	client := NewClient(&ClientOptions{
+		Client: &http.Client{Transport: &http.Transport{
+			Proxy: func(req *http.Request) (*url.URL, error) {
+				return url.Parse(server.URL)
+			},
+		}},
+		Discoverer: static.InstanceDiscoverer{
+			clientSVCName: {{Addr: "good:8000"}, {Addr: "bad:8000"}},
+		},
+		Serializer: json_ser.ClientSideSerializer,
+		NewLoadBalancer: newBalancer(loadbalancer.Options{
+			Policy:            loadbalancer.RoundRobin(),
+			EjectionThreshold: 1,
+		}),
+	}, clientCFG)
+
+	for i := 0; i < 4; i++ {
+		client.Handle(newCtx(client), &ClientGetReq{})
+	}
+	// bad:8000 is ejected after its first failure.
+	want := []string{"good:8000", "bad:8000", "good:8000", "good:8000"}
+	if !reflect.DeepEqual(hosts, want) {
+		t.Errorf("hosts == %v, want %v", hosts, want)
+	}
+}
+
+// outcomeBalancer records the outcomes reported to its LoadBalancer.
+type outcomeBalancer struct {
+	LoadBalancer
+	outcomes []config.Outcome
+}
+
+func (b *outcomeBalancer) Pick(instances []discovery.Instance, filter func(discovery.Instance) bool) (
+	discovery.Instance, func(config.Outcome), error) {
+	inst, done, err := b.LoadBalancer.Pick(instances, filter)
+	if err != nil {
+		return inst, done, err
+	}
+	return inst, func(o config.Outcome) {
+		b.outcomes = append(b.outcomes, o)
+		done(o)
+	}, nil
+}
+
+func TestClient_LoadBalancer_Panic(t *testing.T) {
+	b := &outcomeBalancer{LoadBalancer: loadbalancer.New(loadbalancer.Options{})}
+	opts := &ClientOptions{
+		NewLoadBalancer: func(service string) LoadBalancer {
+			return b
+		},
+	}
+	svc, cleanup := newClientOpts(opts, func(w http.ResponseWriter, r *http.Request) {})
+	defer cleanup()
+	opts.Serializer = &serialization.ClientSideSerializer{
+		ReqSerializer:    panicReqSerializer{},
+		RespDeserializer: opts.Serializer.RespDeserializer,
+	}
+
+	func() {
+		defer func() {
+			if v := recover(); v == nil {
+				t.Error("request didn't panic")
+			}
+		}()
+		svc.Handle(newCtx(svc), &ClientGetDirReq{})
+	}()
+
+	// The panicking request has finished in the load balancer.
+	addr := clientSVCName + ":8000"
+	if outstanding, _ := svc.(*client).balancer.Load(addr); outstanding != 0 {
+		t.Errorf("Load(%v) == %v, want no outstanding requests", addr, outstanding)
+	}
+	// The panic is ignored like by the circuit breaker.
+	if want := []config.Outcome{config.OutcomeIgnored}; !reflect.DeepEqual(b.outcomes, want) {
+		t.Errorf("outcomes == %v, want %v", b.outcomes, want)
+	}
+}
+
+type resolverFunc func(ctx context.Context, name string) ([]discovery.Instance, error)
+
+func (f resolverFunc) Resolve(ctx context.Context, name string) ([]discovery.Instance, error) {
+	return f(ctx, name)
+}
+
+func TestClient_Resolver(t *testing.T) {
+	var path string
+	var deadline time.Time
+	client, cleanup := newClientOpts(&ClientOptions{
+		PrefixURLPath: true,
+		Resolver: resolverFunc(func(ctx context.Context, name string) ([]discovery.Instance, error) {
+			deadline, _ = ctx.Deadline()
+			return []discovery.Instance{{Addr: "a:8000", PathPrefix: "/api"}}, nil
+		}),
+	}, func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		w.Header().Set("Content-Type", clientJSONContentType)
+		w.Write([]byte("{}"))
+	})
+	defer cleanup()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	c := newCtx(client)
+	c.Context = ctx
+	if _, err := client.Handle(c, &ClientGetReq{}); err != nil {
+		t.Fatalf("unexpected error :: %v", err)
+	}
+	if want := "/api/" + clientSVCName + "/"; path != want {
+		t.Errorf("req path == %q, want %q", path, want)
+	}
+	if want, _ := ctx.Deadline(); !deadline.Equal(want) {
+		t.Errorf("resolver deadline == %v, want %v", deadline, want)
+	}
+}
+
+// blockingDiscoverer blocks until its channel is closed.
+type blockingDiscoverer chan struct{}
+
+func (d blockingDiscoverer) Discover(name string) (string, error) {
+	<-d
+	return "", discovery.NotFoundError
+}
+
+func TestClient_Discovery_Respects_Deadline(t *testing.T) {
+	d := make(blockingDiscoverer)
+	defer close(d)
+	client := NewClient(&ClientOptions{
+		Discoverer: d,
+		Serializer: json_ser.ClientSideSerializer,
+	}, clientCFG)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	c := newCtx(client)
+	c.Context = ctx
+	if _, err := client.Handle(c, &ClientGetReq{}); err != context.DeadlineExceeded {
+		t.Errorf("err == %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestClient_Zone(t *testing.T) {
+	var hosts []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hosts = append(hosts, r.Host)
+		w.Header().Set("Content-Type", clientJSONContentType)
+		if r.Host == "b:8000" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		w.Write([]byte("{}"))
+	}))
+	defer server.Close()
+
+	zone := func(z string) map[string]string {
+		return map[string]string{discovery.MetadataZone: z}
+	}
+	counters := testCounters{}
+	client := NewClient(&ClientOptions{
+		Client: &http.Client{Transport: &http.Transport{
+			Proxy: func(req *http.Request) (*url.URL, error) {
+				return url.Parse(server.URL)
+			},
+		}},
+		Discoverer: static.InstanceDiscoverer{clientSVCName: {
+			{Addr: "a:8000", Metadata: zone("z1")},
+			{Addr: "b:8000", Metadata: zone("z1")},
+			{Addr: "c:8000", Metadata: zone("z2")},
+		}},
+		Serializer: json_ser.ClientSideSerializer,
+		NewLoadBalancer: newBalancer(loadbalancer.Options{
+			Policy:            loadbalancer.RoundRobin(),
+			EjectionThreshold: 1,
+		}),
+		Zone: &ZoneOptions{Zone: "z1", MinHealthyPercent: 100, Metrics: counters},
+	}, clientCFG)
+
+	for i := 0; i < 4; i++ {
+		client.Handle(newCtx(client), &ClientGetReq{})
+	}
+	// The requests spill over to z2 after the ejection of b:8000.
+	want := []string{"a:8000", "b:8000", "a:8000", "c:8000"}
+	if !reflect.DeepEqual(hosts, want) {
+		t.Errorf("hosts == %v, want %v", hosts, want)
+	}
+	stats := client.(ZoneRoutingClient).ZoneStats()
+	if stats != (ZoneStats{Local: 3, CrossZone: 1}) {
+		t.Errorf("stats == %+v, want 3 local and 1 cross-zone requests", stats)
+	}
+	if share := stats.CrossZoneShare(); share != 0.25 {
+		t.Errorf("cross-zone share == %v, want 0.25", share)
+	}
+	wantCounters := testCounters{
+		ZoneRequestsMetric + " service=" + clientSVCName + " zone=local": 3,
+		ZoneRequestsMetric + " service=" + clientSVCName + " zone=cross": 1,
+	}
+	if !reflect.DeepEqual(counters, wantCounters) {
+		t.Errorf("counters == %v, want %v", counters, wantCounters)
+	}
+}
+
+// testCounters maps the names and labels of the counters to their values.
+type testCounters map[string]int
+
+func (c testCounters) IncCounter(name string, labels ...string) {
+	for i := 0; i < len(labels); i += 2 {
+		name += " " + labels[i] + "=" + labels[i+1]
+	}
+	c[name]++
+}
+
+func TestZoneRouter_MaxOutstanding(t *testing.T) {
+	z := newZoneRouter(clientSVCName, ZoneOptions{Zone: "z1", MaxOutstanding: 2})
+	b := loadbalancer.New(loadbalancer.Options{})
+	instances := []discovery.Instance{
+		{Addr: "a:8000", Metadata: map[string]string{discovery.MetadataZone: "z1"}},
+		{Addr: "b:8000", Metadata: map[string]string{discovery.MetadataZone: "z2"}},
+	}
+
+	var dones []func(loadbalancer.Outcome)
+	for i := 0; i < 2; i++ {
+		if n := len(accepted(instances, z.filter(instances, b))); n != 1 {
+			t.Fatalf("%v instances with %v outstanding requests, want only the local one", n, i)
+		}
+		_, done, _ := b.Pick(instances, z.filter(instances, b))
+		dones = append(dones, done)
+	}
+	if n := len(accepted(instances, z.filter(instances, b))); n != 2 {
+		t.Errorf("%v instances when the local one is overloaded, want 2", n)
+	}
+	for _, done := range dones {
+		done(loadbalancer.Success)
+	}
+	if n := len(accepted(instances, z.filter(instances, b))); n != 1 {
+		t.Errorf("%v instances after the requests have finished, want 1", n)
+	}
+}
+
+type ClientShardedReq struct {
+	Key string
+}
+
+func (r *ClientShardedReq) ShardKey() string {
+	return r.Key
+}
+
+var shardedClientCFG = &config.ServiceConfig{
+	ServiceName: clientSVCName,
+	Endpoints: []*config.EndpointConfig{
+		{
+			Method:   "GET",
+			Path:     "/",
+			ReqType:  reflect.TypeOf((*ClientShardedReq)(nil)).Elem(),
+			RespType: reflect.TypeOf((*ClientGetResp)(nil)).Elem(),
+		},
+	},
+}
+
+func TestClient_Sharding(t *testing.T) {
+	var hosts []string
+	var failing string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hosts = append(hosts, r.Host)
+		w.Header().Set("Content-Type", clientJSONContentType)
+		if r.Host == failing {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		w.Write([]byte("{}"))
+	}))
+	defer server.Close()
+
+	instances := []discovery.Instance{{Addr: "a:8000"}, {Addr: "b:8000"}, {Addr: "c:8000"}}
+	client := NewClient(&ClientOptions{
+		Client: &http.Client{Transport: &http.Transport{
+			Proxy: func(req *http.Request) (*url.URL, error) {
+				return url.Parse(server.URL)
+			},
+		}},
+		Discoverer:      static.InstanceDiscoverer{clientSVCName: instances},
+		Serializer:      json_ser.ClientSideSerializer,
+		NewLoadBalancer: newBalancer(loadbalancer.Options{EjectionThreshold: 1}),
+		ShardRanker:     sharding.Ranker{},
+	}, shardedClientCFG)
+
+	ranked := sharding.Rank("key", instances)
+	for i := 0; i < 3; i++ {
+		client.Handle(newCtx(client), &ClientShardedReq{Key: "key"})
+	}
+	// The owner is ejected after its first failure and the requests of the
+	// key go to the next instance in the rank of the key.
+	failing = ranked[0].Addr
+	for i := 0; i < 2; i++ {
+		client.Handle(newCtx(client), &ClientShardedReq{Key: "key"})
+	}
+	want := []string{ranked[0].Addr, ranked[0].Addr, ranked[0].Addr, ranked[0].Addr, ranked[1].Addr}
+	if !reflect.DeepEqual(hosts, want) {
+		t.Errorf("hosts == %v, want %v", hosts, want)
+	}
+}
+
+func TestClient_Sharding_Disabled(t *testing.T) {
+	hosts := make(map[string]bool)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hosts[r.Host] = true
+		w.Header().Set("Content-Type", clientJSONContentType)
+		w.Write([]byte("{}"))
+	}))
+	defer server.Close()
+
+	instances := []discovery.Instance{{Addr: "a:8000"}, {Addr: "b:8000"}}
+	client := NewClient(&ClientOptions{
+		Client: &http.Client{Transport: &http.Transport{
+			Proxy: func(req *http.Request) (*url.URL, error) {
+				return url.Parse(server.URL)
+			},
+		}},
+		Discoverer:      static.InstanceDiscoverer{clientSVCName: instances},
+		Serializer:      json_ser.ClientSideSerializer,
+		NewLoadBalancer: newBalancer(loadbalancer.Options{Policy: loadbalancer.RoundRobin()}),
+	}, shardedClientCFG)
+
+	// Without a ShardRanker the shard keys are ignored.
+	for i := 0; i < 2; i++ {
+		client.Handle(newCtx(client), &ClientShardedReq{Key: "key"})
+	}
+	if len(hosts) != 2 {
+		t.Errorf("hosts == %v, want both instances", hosts)
+	}
+}
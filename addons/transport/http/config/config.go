@@ -13,4 +13,26 @@ type EndpointConfig struct {
 	HasReqContent bool
 	ReqType       reflect.Type
 	RespType      reflect.Type
+
+	// Idempotent marks the endpoint safe to retry even if its Method isn't
+	// idempotent (e.g.: a POST endpoint that deduplicates its requests).
+	Idempotent bool
+	// Retry overrides the RetryPolicy of the http client for this endpoint.
+	// Optional.
+	Retry *RetryPolicy
+	// Hedging enables hedged requests for this endpoint. It is ignored if the
+	// endpoint isn't idempotent. Optional.
+	Hedging *HedgingPolicy
+}
+
+// IsIdempotent returns true if the requests of the endpoint can be retried.
+func (p *EndpointConfig) IsIdempotent() bool {
+	if p.Idempotent {
+		return true
+	}
+	switch p.Method {
+	case "GET", "HEAD", "OPTIONS", "PUT", "DELETE":
+		return true
+	}
+	return false
 }
@@ -6,8 +6,12 @@ const (
 	ErrorCodeBadRequest            = "C-BAD-REQUEST"
 	ErrorCodeNotFound              = "C-NOT-FOUND"
 	ErrorCodeBadRequestContentType = "C-BAD-CONTENT-TYPE"
+	ErrorCodeRateLimited           = "C-RATE-LIMITED"
 
 	ErrorCodeServerError = "S-ERROR"
+	ErrorCodeOverloaded  = "S-OVERLOADED"
+	ErrorCodeCircuitOpen = "S-CIRCUIT-OPEN"
+	ErrorCodePanic       = "S-PANIC"
 
 	ClientErrorCodePrefix = "C-"
 	ServerErrorCodePrefix = "S-"
@@ -17,6 +21,10 @@ var codeToStatus = map[string]int{
 	ErrorCodeBadRequest:            400,
 	ErrorCodeNotFound:              404,
 	ErrorCodeBadRequestContentType: 415,
+	ErrorCodeRateLimited:           429,
+	ErrorCodePanic:                 500,
+	ErrorCodeOverloaded:            503,
+	ErrorCodeCircuitOpen:           503,
 }
 
 var ErrorCodeToHTTPStatus = func(code string) int {
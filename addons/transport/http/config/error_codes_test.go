@@ -0,0 +1,13 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/pasztorpisti/nano"
+)
+
+func TestErrorCodePanic(t *testing.T) {
+	if ErrorCodePanic != nano.PanicErrorCode {
+		t.Errorf("ErrorCodePanic == %q, want %q", ErrorCodePanic, nano.PanicErrorCode)
+	}
+}
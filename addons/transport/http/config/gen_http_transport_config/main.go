@@ -104,6 +104,7 @@ type EndpointConfig struct {
 	HasReqContent bool   `json:"has_req_content"`
 	ReqType       string `json:"req_type"`
 	RespType      string `json:"resp_type"`
+	Idempotent    bool   `json:"idempotent"`
 }
 
 var tpl *template.Template
@@ -130,6 +131,9 @@ var HTTPTransportConfig = &config.ServiceConfig{
 			HasReqContent: {{ $ep.HasReqContent }},
 			ReqType:       reflect.TypeOf((*{{ $ep.ReqType }})(nil)).Elem(),
 			RespType:      reflect.TypeOf((*{{ $ep.RespType }})(nil)).Elem(),
+			{{- if $ep.Idempotent }}
+			Idempotent:    true,
+			{{- end }}
 		},
 		{{- end }}
 	},
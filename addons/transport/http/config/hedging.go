@@ -0,0 +1,22 @@
+package config
+
+import "time"
+
+// HedgingPolicy describes how the http client sends hedged requests: if the
+// response to a request hasn't arrived within a delay then the client sends
+// the same request to another instance of the target service and uses the
+// response that arrives first. The delay is a percentile of the recently
+// observed latencies of the endpoint so only the slowest requests are hedged.
+type HedgingPolicy struct {
+	// MaxRequests is the maximum number of requests sent to different
+	// instances including the first one. Defaults to 2.
+	MaxRequests int
+	// Percentile of the observed latencies used as the hedging delay.
+	// Defaults to 95.
+	Percentile float64
+	// MinDelay is the lower bound of the hedging delay. Optional.
+	MinDelay time.Duration
+	// MaxDelay is the upper bound of the hedging delay. It is also the delay
+	// used until enough latencies have been observed. Defaults to 1 second.
+	MaxDelay time.Duration
+}
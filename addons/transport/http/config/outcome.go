@@ -0,0 +1,14 @@
+package config
+
+// Outcome is the result of a request sent by the http client to an instance
+// of the target service. The client reports it to its circuit breaker.
+type Outcome int
+
+const (
+	OutcomeSuccess Outcome = iota
+	// OutcomeFailure means a transport error or a 502, 503 or 504 response.
+	OutcomeFailure
+	// OutcomeIgnored means that the request doesn't tell anything about the
+	// health of the target, e.g.: the caller has canceled it.
+	OutcomeIgnored
+)
@@ -0,0 +1,81 @@
+package config
+
+import "time"
+
+// RetryPolicy describes how the http client retries failed requests.
+// Only the requests of idempotent endpoints are retried: endpoints with
+// idempotent HTTP methods (GET, HEAD, OPTIONS, PUT, DELETE) or with
+// EndpointConfig.Idempotent set. A request is retried on transport errors
+// (e.g.: connection refused) and on errors with a RetryableCodes error code.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of attempts including the first one.
+	// Values less than 2 disable retries.
+	MaxAttempts int
+	// InitialBackoff is the wait time before the first retry.
+	// Defaults to 50ms.
+	InitialBackoff time.Duration
+	// MaxBackoff is the maximum wait time between attempts.
+	// Defaults to 1 second.
+	MaxBackoff time.Duration
+	// Multiplier is the growth factor of the backoff. Defaults to 2.
+	Multiplier float64
+	// Jitter is the randomised fraction of the backoff between 0 and 1.
+	// E.g.: 0.2 means that the actual backoff is between 80% and 100% of
+	// the calculated value. Zero means no jitter.
+	Jitter float64
+	// RetryableCodes are the NanoError codes that are worth retrying.
+	// Nil means DefaultRetryableCodes.
+	RetryableCodes []string
+}
+
+// DefaultRetryableCodes are the error codes retried by default.
+var DefaultRetryableCodes = []string{
+	ErrorCodeOverloaded,
+}
+
+// DefaultRetryPolicy is a reasonable RetryPolicy to start with.
+var DefaultRetryPolicy = &RetryPolicy{
+	MaxAttempts:    3,
+	InitialBackoff: 50 * time.Millisecond,
+	MaxBackoff:     time.Second,
+	Multiplier:     2,
+	Jitter:         0.2,
+}
+
+// Backoff returns the wait time before the given retry (the first retry is
+// retry 1) without jitter.
+func (p *RetryPolicy) Backoff(retry int) time.Duration {
+	backoff := p.InitialBackoff
+	if backoff <= 0 {
+		backoff = 50 * time.Millisecond
+	}
+	maxBackoff := p.MaxBackoff
+	if maxBackoff <= 0 {
+		maxBackoff = time.Second
+	}
+	multiplier := p.Multiplier
+	if multiplier <= 0 {
+		multiplier = 2
+	}
+	for i := 1; i < retry && backoff < maxBackoff; i++ {
+		backoff = time.Duration(float64(backoff) * multiplier)
+	}
+	if backoff > maxBackoff {
+		backoff = maxBackoff
+	}
+	return backoff
+}
+
+// IsRetryableCode returns true if the error code is worth retrying.
+func (p *RetryPolicy) IsRetryableCode(code string) bool {
+	codes := p.RetryableCodes
+	if codes == nil {
+		codes = DefaultRetryableCodes
+	}
+	for _, c := range codes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
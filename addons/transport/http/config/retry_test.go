@@ -0,0 +1,44 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRetryPolicy_Backoff(t *testing.T) {
+	p := &RetryPolicy{
+		InitialBackoff: 10 * time.Millisecond,
+		MaxBackoff:     50 * time.Millisecond,
+		Multiplier:     3,
+	}
+	want := []time.Duration{
+		10 * time.Millisecond,
+		30 * time.Millisecond,
+		50 * time.Millisecond,
+		50 * time.Millisecond,
+	}
+	for i, w := range want {
+		if d := p.Backoff(i + 1); d != w {
+			t.Errorf("Backoff(%v) == %v, want %v", i+1, d, w)
+		}
+	}
+}
+
+func TestEndpointConfig_IsIdempotent(t *testing.T) {
+	tests := []struct {
+		ec   EndpointConfig
+		want bool
+	}{
+		{EndpointConfig{Method: "GET"}, true},
+		{EndpointConfig{Method: "PUT"}, true},
+		{EndpointConfig{Method: "DELETE"}, true},
+		{EndpointConfig{Method: "POST"}, false},
+		{EndpointConfig{Method: "POST", Idempotent: true}, true},
+		{EndpointConfig{Method: "PATCH"}, false},
+	}
+	for _, test := range tests {
+		if v := test.ec.IsIdempotent(); v != test.want {
+			t.Errorf("%+v: IsIdempotent == %v, want %v", test.ec, v, test.want)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/pasztorpisti/nano"
+	"github.com/pasztorpisti/nano/addons/log"
+)
+
+// HealthResponse is the JSON body of the liveness and readiness endpoints.
+type HealthResponse struct {
+	Status nano.HealthStatus `json:"status"`
+	// Draining is true if the listener is being shut down.
+	Draining bool                                 `json:"draining,omitempty"`
+	Services map[string]*nano.ServiceHealthReport `json:"services,omitempty"`
+	Error    string                               `json:"error,omitempty"`
+}
+
+// livenessHandler reports whether the process is alive and able to serve
+// http requests. It doesn't query the health of the services because a
+// failing dependency isn't a reason to restart the process.
+func (p *listener) livenessHandler(w http.ResponseWriter, r *http.Request,
+	_ httprouter.Params) {
+	writeHealthResponse(w, http.StatusOK, &HealthResponse{
+		Status:   nano.HealthOK,
+		Draining: atomic.LoadInt32(&p.draining) != 0,
+	})
+}
+
+// readinessHandler reports whether the listener should receive requests.
+// It responds with 503 while the listener is draining, if any of the
+// services of the ServiceSet is not ready or if the health checks don't
+// finish within the ReadinessTimeout. Degraded services are ready.
+func (p *listener) readinessHandler(w http.ResponseWriter, r *http.Request,
+	_ httprouter.Params) {
+	resp := &HealthResponse{Status: nano.HealthOK}
+	if hc, ok := p.ss.(nano.HealthChecker); ok {
+		timeout := p.opts.ReadinessTimeout
+		if timeout <= 0 {
+			timeout = DefaultReadinessTimeout
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+
+		// A health check that ignores ctx can't block the probe because
+		// the report is awaited only until ctx is done. Buffered to let
+		// the health check finish later.
+		reports := make(chan *nano.HealthReport, 1)
+		go func() {
+			reports <- hc.Health(ctx)
+		}()
+		select {
+		case report := <-reports:
+			resp.Status = report.Status
+			resp.Services = report.Services
+		case <-ctx.Done():
+			resp.Status = nano.HealthNotReady
+			resp.Error = "health check timed out"
+		}
+	}
+	if atomic.LoadInt32(&p.draining) != 0 {
+		resp.Status = nano.HealthNotReady
+		resp.Draining = true
+	}
+
+	status := http.StatusOK
+	if resp.Status == nano.HealthNotReady {
+		status = http.StatusServiceUnavailable
+	}
+	writeHealthResponse(w, status, resp)
+}
+
+func writeHealthResponse(w http.ResponseWriter, status int, resp *HealthResponse) {
+	body, err := json.Marshal(resp)
+	if err != nil {
+		log.Err(nil, err, "error marshaling health response")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+func stringOrDefault(s, def string) string {
+	if s == "" {
+		return def
+	}
+	return s
+}
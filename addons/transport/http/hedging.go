@@ -0,0 +1,194 @@
+package http
+
+import (
+	"context"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pasztorpisti/nano"
+	"github.com/pasztorpisti/nano/addons/discovery"
+	"github.com/pasztorpisti/nano/addons/transport/http/config"
+)
+
+const (
+	// latencySamples is the number of recent latencies kept per endpoint.
+	latencySamples = 256
+	// minLatencySamples is the number of latencies needed to calculate the
+	// hedging delay. HedgingPolicy.MaxDelay is used until then.
+	minLatencySamples = 20
+)
+
+// hedger holds the hedging state of an endpoint.
+type hedger struct {
+	maxRequests int
+	percentile  float64
+	minDelay    time.Duration
+	maxDelay    time.Duration
+
+	mu        sync.Mutex
+	latencies []time.Duration
+	next      int
+}
+
+func newHedger(policy *config.HedgingPolicy) *hedger {
+	h := &hedger{
+		maxRequests: policy.MaxRequests,
+		percentile:  policy.Percentile,
+		minDelay:    policy.MinDelay,
+		maxDelay:    policy.MaxDelay,
+		latencies:   make([]time.Duration, 0, latencySamples),
+	}
+	if h.maxRequests <= 0 {
+		h.maxRequests = 2
+	}
+	if h.percentile <= 0 || h.percentile > 100 {
+		h.percentile = 95
+	}
+	if h.maxDelay <= 0 {
+		h.maxDelay = time.Second
+	}
+	return h
+}
+
+func (p *hedger) observe(latency time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.latencies) < latencySamples {
+		p.latencies = append(p.latencies, latency)
+		return
+	}
+	p.latencies[p.next] = latency
+	p.next = (p.next + 1) % latencySamples
+}
+
+// delay returns the time to wait for a response before sending the next
+// hedged request.
+func (p *hedger) delay() time.Duration {
+	p.mu.Lock()
+	if len(p.latencies) < minLatencySamples {
+		p.mu.Unlock()
+		return p.maxDelay
+	}
+	sorted := make([]time.Duration, len(p.latencies))
+	copy(sorted, p.latencies)
+	p.mu.Unlock()
+
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	i := int(math.Ceil(p.percentile/100*float64(len(sorted)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	d := sorted[i]
+	if d < p.minDelay {
+		d = p.minDelay
+	}
+	if d > p.maxDelay {
+		d = p.maxDelay
+	}
+	return d
+}
+
+// sendHedged sends the request to an instance of the target service and
+// sends it to another instance each time the delay of the hedger elapses
+// without a response. It returns the first response and cancels the other
+// requests. A transport error is returned only if all requests have failed.
+func (p *client) sendHedged(c *nano.Ctx, ec *config.EndpointConfig, req interface{},
+	h *hedger) sendResult {
+	instances, filter, err := p.resolve(c)
+	if err != nil {
+		return sendResult{err: err, transportErr: true}
+	}
+	candidates := accepted(instances, filter)
+	maxRequests := h.maxRequests
+	if maxRequests > len(candidates) {
+		maxRequests = len(candidates)
+	}
+
+	parent := context.Background()
+	if c != nil && c.Context != nil {
+		parent = c.Context
+	}
+	ctx, cancel := context.WithCancel(parent)
+	// Cancels the requests that have lost the race.
+	defer cancel()
+	var hc *nano.Ctx
+	if c != nil {
+		hc = c.WithContext(ctx)
+	} else {
+		hc = &nano.Ctx{Context: ctx}
+	}
+
+	// Buffered to let the losers finish without blocking.
+	results := make(chan sendResult, maxRequests)
+	// Without a load balancer starting at a random instance spreads the
+	// first requests.
+	offset := int(randFloat64() * float64(len(candidates)))
+	// tried holds the addresses of the instances that have received the
+	// request. The load balancer picks from the others.
+	tried := make(map[string]bool, maxRequests)
+	untried := func(inst discovery.Instance) bool {
+		return !tried[inst.Addr] && (filter == nil || filter(inst))
+	}
+	sent := 0
+	sendNext := func() {
+		inst := candidates[(offset+sent)%len(candidates)]
+		var done func(config.Outcome)
+		if p.balancer != nil {
+			var err error
+			inst, done, err = p.balancer.Pick(instances, untried)
+			if err != nil {
+				sent++
+				results <- sendResult{err: p.Err(err, "load balancer failure"), transportErr: true}
+				return
+			}
+		}
+		tried[inst.Addr] = true
+		sent++
+		go func() {
+			// A panic is ignored like by the circuit breaker.
+			o := config.OutcomeIgnored
+			if done != nil {
+				defer func() {
+					done(o)
+				}()
+			}
+			start := time.Now()
+			r := p.sendTo(hc, ec, req, inst)
+			o = outcome(hc, r)
+			if r.status != 0 {
+				h.observe(time.Since(start))
+			}
+			results <- r
+		}()
+	}
+
+	sendNext()
+	delay := h.delay()
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	received := 0
+	for {
+		select {
+		case r := <-results:
+			received++
+			if !r.transportErr || received == maxRequests {
+				return r
+			}
+			if received == sent {
+				// All sent requests have failed, there is no point waiting.
+				sendNext()
+				if !timer.Stop() {
+					<-timer.C
+				}
+				timer.Reset(delay)
+			}
+		case <-timer.C:
+			if sent < maxRequests {
+				sendNext()
+				timer.Reset(delay)
+			}
+		}
+	}
+}
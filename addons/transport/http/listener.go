@@ -1,9 +1,13 @@
 package http
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"reflect"
+	"runtime/debug"
+	"sync/atomic"
+	"time"
 
 	"github.com/julienschmidt/httprouter"
 	"github.com/pasztorpisti/nano"
@@ -17,8 +21,46 @@ type ListenerOptions struct {
 	BindAddr      string
 	Serializer    *serialization.ServerSideSerializer
 	PrefixURLPath bool
+
+	// LivenessPath is the URL path of the liveness endpoint.
+	// Defaults to DefaultLivenessPath.
+	LivenessPath string
+	// ReadinessPath is the URL path of the readiness endpoint.
+	// Defaults to DefaultReadinessPath.
+	ReadinessPath string
+	// ReadinessTimeout is the time limit of the health checks of the
+	// readiness endpoint. The endpoint reports not ready if the health
+	// checks don't finish in time. Defaults to DefaultReadinessTimeout.
+	ReadinessTimeout time.Duration
+	// ShutdownDrainDelay is the time the listener keeps serving requests
+	// after reporting not ready on the readiness endpoint at the beginning
+	// of the shutdown. This gives the load balancers time to notice the
+	// change and to stop routing new requests to this listener.
+	ShutdownDrainDelay time.Duration
+
+	// MetricsHandler serves the metrics of the server (e.g.: a
+	// *metrics.Registry of the metrics addon). Optional.
+	MetricsHandler http.Handler
+	// MetricsPath is the URL path of the MetricsHandler.
+	// Defaults to DefaultMetricsPath.
+	MetricsPath string
+
+	// Middlewares are applied only to the requests received by the listener
+	// (unlike the middlewares of the ServiceSet that are applied also to the
+	// in-process requests). The first item is the outermost middleware.
+	// The caller parameter of the middlewares is nil and the Svc and
+	// ClientName fields of the callee Ctx are set but the request hasn't
+	// yet passed through the client of the ServiceSet.
+	Middlewares []nano.Middleware
 }
 
+const (
+	DefaultLivenessPath     = "/health/live"
+	DefaultReadinessPath    = "/health/ready"
+	DefaultReadinessTimeout = 5 * time.Second
+	DefaultMetricsPath      = "/metrics"
+)
+
 var DefaultListenerOptions *ListenerOptions
 
 func NewListener(opts *ListenerOptions, cfgs ...*config.ServiceConfig) nano.Listener {
@@ -34,15 +76,32 @@ func NewListener(opts *ListenerOptions, cfgs ...*config.ServiceConfig) nano.List
 	}
 }
 
+// listener implements the nano.Listener, nano.ListenerShutdown and
+// registry.ServiceLister interfaces.
 type listener struct {
 	cfgs   []*config.ServiceConfig
 	opts   *ListenerOptions
 	router *httprouter.Router
+	server *http.Server
+	ss     nano.ServiceSet
+	// draining is set to 1 at the beginning of Shutdown.
+	draining int32
 }
 
 func (p *listener) Init(srv nano.ServiceSet) error {
 	p.router = httprouter.New()
-	p.router.GET("/health-check", func(http.ResponseWriter, *http.Request, httprouter.Params) {})
+	p.server = &http.Server{
+		Addr:    p.opts.BindAddr,
+		Handler: p.router,
+	}
+	p.ss = srv
+	p.router.GET("/health-check", p.livenessHandler)
+	p.router.GET(stringOrDefault(p.opts.LivenessPath, DefaultLivenessPath), p.livenessHandler)
+	p.router.GET(stringOrDefault(p.opts.ReadinessPath, DefaultReadinessPath), p.readinessHandler)
+	if p.opts.MetricsHandler != nil {
+		p.router.Handler("GET", stringOrDefault(p.opts.MetricsPath, DefaultMetricsPath),
+			p.opts.MetricsHandler)
+	}
 
 	duplicateCheck := map[string]struct{}{}
 	for _, cfg := range p.cfgs {
@@ -64,9 +123,11 @@ func (p *listener) Init(srv nano.ServiceSet) error {
 			duplicateCheck[id] = struct{}{}
 
 			ep := &endpoint{
-				cfg:        ec,
-				svc:        svc,
-				Serializer: p.opts.Serializer,
+				cfg:         ec,
+				ss:          srv,
+				svc:         svc,
+				middlewares: p.opts.Middlewares,
+				Serializer:  p.opts.Serializer,
 			}
 			p.router.Handle(ec.Method, path, ep.Handler)
 		}
@@ -76,17 +137,59 @@ func (p *listener) Init(srv nano.ServiceSet) error {
 }
 
 func (p *listener) Listen() error {
-	return http.ListenAndServe(p.opts.BindAddr, p.router)
+	err := p.server.ListenAndServe()
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
+}
+
+func (p *listener) Shutdown(ctx context.Context) error {
+	atomic.StoreInt32(&p.draining, 1)
+	if p.opts.ShutdownDrainDelay > 0 {
+		t := time.NewTimer(p.opts.ShutdownDrainDelay)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+		}
+	}
+	return p.server.Shutdown(ctx)
 }
 
 type endpoint struct {
-	cfg        *config.EndpointConfig
-	svc        nano.Service
-	Serializer *serialization.ServerSideSerializer
+	cfg         *config.EndpointConfig
+	ss          nano.ServiceSet
+	svc         nano.Service
+	middlewares []nano.Middleware
+	Serializer  *serialization.ServerSideSerializer
 }
 
 func (p *endpoint) Handler(w http.ResponseWriter, r *http.Request,
 	rp httprouter.Params) {
+	// c is nil until the request has been deserialised.
+	var c *nano.Ctx
+	if nano.RecoverPanics {
+		// Panics of the service are recovered by nano.Client.Request.
+		// This handles the panics of the transport layer.
+		defer func() {
+			if v := recover(); v != nil {
+				pe := &nano.PanicError{
+					Value: v,
+					Stack: debug.Stack(),
+				}
+				log.Errm(c, pe, "panic in http endpoint handler", log.Map{
+					"path":  r.URL.Path,
+					"stack": string(pe.Stack),
+				})
+				err := p.Serializer.SerializeResponse(p.cfg, c, w, r, nil, pe)
+				if err != nil {
+					log.Err(nil, err, "error serialising panic response")
+				}
+			}
+		}()
+	}
+
 	req, ri, err := p.Serializer.DeserializeRequest(p.cfg, r)
 	if err != nil {
 		log.Err(nil, err, "error deserialising request")
@@ -97,12 +200,34 @@ func (p *endpoint) Handler(w http.ResponseWriter, r *http.Request,
 		return
 	}
 
-	client := nano.NewClient(p.svc, ri.ClientName)
+	// Obtaining the client through the ServiceSet makes the request go
+	// through the middlewares of the ServiceSet.
+	client := nano.NewClientSet(p.ss, ri.ClientName).LookupClient(p.svc.Name())
+	handler := func(caller, callee *nano.Ctx, req interface{}) (interface{}, error) {
+		return client.Request(callee, req)
+	}
+	for i := len(p.middlewares) - 1; i >= 0; i-- {
+		handler = p.middlewares[i](handler)
+	}
+
+	// The context of the request is bound to the connection of the client
+	// and to the time budget sent by the client.
+	ctx := r.Context()
+	if ri.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, ri.Timeout)
+		defer cancel()
+	}
 
-	c := &nano.Ctx{
-		ReqID: ri.ReqID,
+	c = &nano.Ctx{
+		ReqID:      ri.ReqID,
+		Context:    ctx,
+		Metadata:   ri.Metadata,
+		Trace:      ri.Trace,
+		Svc:        p.svc,
+		ClientName: ri.ClientName,
 	}
-	resp, err := client.Request(c, req)
+	resp, err := handler(nil, c, req)
 
 	if err != nil {
 		resp = nil
@@ -123,6 +248,9 @@ func (p *endpoint) Handler(w http.ResponseWriter, r *http.Request,
 		}
 	}
 
+	if err != nil {
+		setRetryAfter(w.Header(), err)
+	}
 	err = p.Serializer.SerializeResponse(p.cfg, c, w, r, resp, err)
 	if err != nil {
 		log.Err(c, err, "error serialising response")
@@ -132,3 +260,12 @@ func (p *endpoint) Handler(w http.ResponseWriter, r *http.Request,
 
 var serverError = util.ErrCode(nil, config.ErrorCodeServerError,
 	"Internal Server Error")
+
+// ServiceNames returns the names of the services exposed by the listener.
+func (p *listener) ServiceNames() []string {
+	names := make([]string, len(p.cfgs))
+	for i, cfg := range p.cfgs {
+		names[i] = cfg.ServiceName
+	}
+	return names
+}
@@ -1,12 +1,14 @@
 package http
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/pasztorpisti/nano"
 	"github.com/pasztorpisti/nano/addons/transport/http/config"
@@ -376,3 +378,361 @@ func TestListen_ClientErrorResponse(t *testing.T) {
 func TestListen_ServerErrorResponse(t *testing.T) {
 	testListen_ErrorResponse(t, "S-MYERROR", 500)
 }
+
+func TestListen_Shutdown(t *testing.T) {
+	l := NewListener(&ListenerOptions{
+		BindAddr:   "127.0.0.1:0",
+		Serializer: json_ser.ServerSideSerializer,
+	}, listenCFG)
+
+	svc := util.NewService(listenSVCName, func(c *nano.Ctx, req interface{}) (interface{}, error) {
+		return nil, nil
+	})
+	err := l.Init(nano.NewServiceSet(svc))
+	if err != nil {
+		t.Errorf("listener init failed :: %v", err)
+		t.FailNow()
+	}
+
+	listenErr := make(chan error, 1)
+	go func() {
+		listenErr <- l.Listen()
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	err = l.(nano.ListenerShutdown).Shutdown(ctx)
+	if err != nil {
+		t.Errorf("shutdown failed :: %v", err)
+	}
+
+	select {
+	case err := <-listenErr:
+		if err != nil {
+			t.Errorf("Listen returned an error after shutdown :: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Error("Listen hasn't returned after shutdown")
+	}
+}
+
+func TestListen_ServiceSetMiddlewares(t *testing.T) {
+	l := NewListener(&ListenerOptions{
+		Serializer:    json_ser.ServerSideSerializer,
+		PrefixURLPath: true,
+	}, listenCFG)
+
+	svc := util.NewService(listenSVCName, func(c *nano.Ctx, req interface{}) (interface{}, error) {
+		return nil, nil
+	})
+	var callerName string
+	ss := nano.NewServiceSetOpts(nano.ServiceSetOpts{
+		Services: []nano.Service{svc},
+		Middlewares: []nano.Middleware{func(next nano.RequestHandler) nano.RequestHandler {
+			return func(caller, callee *nano.Ctx, req interface{}) (interface{}, error) {
+				callerName = callee.ClientName
+				return next(caller, callee, req)
+			}
+		}},
+	})
+	err := l.Init(ss)
+	if err != nil {
+		t.Errorf("listener init failed :: %v", err)
+		t.FailNow()
+	}
+
+	req := httptest.NewRequest("GET", "/"+listenSVCName+"/dir", nil)
+	req.Header.Set(json_ser.HeaderReqID, listenTestReqID)
+	req.Header.Set(json_ser.HeaderClientName, listenTestClientName)
+
+	resp := httptest.NewRecorder()
+	l.(*listener).router.ServeHTTP(resp, req)
+
+	if resp.Code != 200 {
+		t.Errorf("resp.Code == %v, want %v", resp.Code, 200)
+	}
+	if callerName != listenTestClientName {
+		t.Errorf("middleware saw client name %q, want %q", callerName, listenTestClientName)
+	}
+}
+
+func TestListen_Timeout(t *testing.T) {
+	var deadline time.Time
+	hasDeadline := false
+	h := newListener(true, func(c *nano.Ctx, req interface{}) (interface{}, error) {
+		deadline, hasDeadline = c.Context.Deadline()
+		return nil, nil
+	})
+
+	req := httptest.NewRequest("GET", "/"+listenSVCName+"/dir", nil)
+	req.Header.Set(json_ser.HeaderTimeout, "60000")
+
+	before := time.Now()
+	resp := httptest.NewRecorder()
+	h.ServeHTTP(resp, req)
+
+	if resp.Code != 200 {
+		t.Errorf("resp.Code == %v, want %v", resp.Code, 200)
+	}
+	if !hasDeadline {
+		t.Error("the context of the request has no deadline")
+	} else if d := deadline.Sub(before); d < time.Minute || d > 2*time.Minute {
+		t.Errorf("the context of the request expires in %v, want ~%v", d, time.Minute)
+	}
+}
+
+func TestListen_PanicErrorResponse(t *testing.T) {
+	origLogPanic := nano.LogPanic
+	defer func() {
+		nano.LogPanic = origLogPanic
+	}()
+	nano.LogPanic = func(*nano.Ctx, *nano.PanicError) {}
+
+	h := newListener(true, func(c *nano.Ctx, req interface{}) (interface{}, error) {
+		panic("test panic")
+	})
+
+	req := httptest.NewRequest("GET", "/"+listenSVCName+"/", nil)
+	resp := httptest.NewRecorder()
+	h.ServeHTTP(resp, req)
+
+	if resp.Code != 500 {
+		t.Errorf("resp.Code == %v, want %v", resp.Code, 500)
+	}
+	respObj := new(json_ser.ErrorResponse)
+	err := json.Unmarshal(resp.Body.Bytes(), respObj)
+	if err != nil {
+		t.Errorf("error unmarshaling error response content :: %v", err)
+		t.FailNow()
+	}
+	if respObj.Code != config.ErrorCodePanic {
+		t.Errorf("error code == %q, want %q", respObj.Code, config.ErrorCodePanic)
+	}
+}
+
+type healthTestSvc struct {
+	nano.Service
+	status nano.HealthStatus
+	// block blocks Health until it is closed if it isn't nil.
+	block chan struct{}
+}
+
+func (p *healthTestSvc) Health(ctx context.Context) (nano.HealthStatus, error) {
+	if p.block != nil {
+		<-p.block
+	}
+	return p.status, nil
+}
+
+func newHealthTestListener(t *testing.T, status nano.HealthStatus) *listener {
+	l := NewListener(&ListenerOptions{
+		BindAddr:   "127.0.0.1:0",
+		Serializer: json_ser.ServerSideSerializer,
+	}, listenCFG)
+
+	svc := &healthTestSvc{
+		Service: util.NewService(listenSVCName, func(c *nano.Ctx, req interface{}) (interface{}, error) {
+			return nil, nil
+		}),
+		status: status,
+	}
+	err := l.Init(nano.NewServiceSet(svc))
+	if err != nil {
+		t.Errorf("listener init failed :: %v", err)
+		t.FailNow()
+	}
+	return l.(*listener)
+}
+
+func getHealth(t *testing.T, l *listener, path string) (int, *HealthResponse) {
+	r := httptest.NewRequest("GET", path, nil)
+	w := httptest.NewRecorder()
+	l.router.ServeHTTP(w, r)
+
+	var resp HealthResponse
+	err := json.Unmarshal(w.Body.Bytes(), &resp)
+	if err != nil {
+		t.Errorf("error unmarshaling health response %q :: %v", w.Body.String(), err)
+	}
+	return w.Code, &resp
+}
+
+func TestListen_Readiness(t *testing.T) {
+	tests := []struct {
+		status     nano.HealthStatus
+		wantStatus int
+	}{
+		{nano.HealthOK, http.StatusOK},
+		{nano.HealthDegraded, http.StatusOK},
+		{nano.HealthNotReady, http.StatusServiceUnavailable},
+	}
+	for _, test := range tests {
+		l := newHealthTestListener(t, test.status)
+		code, resp := getHealth(t, l, DefaultReadinessPath)
+		if code != test.wantStatus {
+			t.Errorf("%v: status code == %v, want %v", test.status, code, test.wantStatus)
+		}
+		if resp.Status != test.status {
+			t.Errorf("%v: status == %q", test.status, resp.Status)
+		}
+		sr := resp.Services[listenSVCName]
+		if sr == nil || sr.Status != test.status {
+			t.Errorf("%v: service report == %+v", test.status, sr)
+		}
+	}
+}
+
+func TestListen_Readiness_Timeout(t *testing.T) {
+	l := newHealthTestListener(t, nano.HealthOK)
+	l.opts.ReadinessTimeout = 10 * time.Millisecond
+	svc, _ := l.ss.LookupService(listenSVCName)
+	block := make(chan struct{})
+	defer close(block)
+	svc.(*healthTestSvc).block = block
+
+	code, resp := getHealth(t, l, DefaultReadinessPath)
+	if code != http.StatusServiceUnavailable {
+		t.Errorf("status code == %v, want %v", code, http.StatusServiceUnavailable)
+	}
+	if resp.Status != nano.HealthNotReady || resp.Error == "" {
+		t.Errorf("unexpected response: %+v", resp)
+	}
+}
+
+func TestListen_Liveness(t *testing.T) {
+	l := newHealthTestListener(t, nano.HealthNotReady)
+	for _, path := range []string{DefaultLivenessPath, "/health-check"} {
+		code, resp := getHealth(t, l, path)
+		if code != http.StatusOK {
+			t.Errorf("%v: status code == %v, want %v", path, code, http.StatusOK)
+		}
+		if resp.Status != nano.HealthOK {
+			t.Errorf("%v: status == %q, want %q", path, resp.Status, nano.HealthOK)
+		}
+	}
+}
+
+func TestListen_Readiness_Draining(t *testing.T) {
+	l := newHealthTestListener(t, nano.HealthOK)
+	l.opts.ShutdownDrainDelay = time.Minute
+
+	listenErr := make(chan error, 1)
+	go func() {
+		listenErr <- l.Listen()
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	shutdownErr := make(chan error, 1)
+	go func() {
+		shutdownErr <- l.Shutdown(ctx)
+	}()
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		code, resp := getHealth(t, l, DefaultReadinessPath)
+		if code == http.StatusServiceUnavailable {
+			if !resp.Draining || resp.Status != nano.HealthNotReady {
+				t.Errorf("unexpected draining response: %+v", resp)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Error("readiness hasn't turned false during shutdown")
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// Canceling the context cuts the drain delay short.
+	cancel()
+	select {
+	case <-shutdownErr:
+	case <-time.After(time.Second):
+		t.Error("Shutdown hasn't returned after canceling its context")
+	}
+	select {
+	case <-listenErr:
+	case <-time.After(time.Second):
+		t.Error("Listen hasn't returned after shutdown")
+	}
+}
+
+func TestListen_Metrics(t *testing.T) {
+	l := NewListener(&ListenerOptions{
+		Serializer: json_ser.ServerSideSerializer,
+		MetricsHandler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("metrics"))
+		}),
+	}, listenCFG)
+	svc := util.NewService(listenSVCName, func(c *nano.Ctx, req interface{}) (interface{}, error) {
+		return nil, nil
+	})
+	err := l.Init(nano.NewServiceSet(svc))
+	if err != nil {
+		t.Errorf("listener init failed :: %v", err)
+		t.FailNow()
+	}
+
+	r := httptest.NewRequest("GET", DefaultMetricsPath, nil)
+	w := httptest.NewRecorder()
+	l.(*listener).router.ServeHTTP(w, r)
+	if w.Code != http.StatusOK || w.Body.String() != "metrics" {
+		t.Errorf("unexpected metrics response: %v %q", w.Code, w.Body.String())
+	}
+}
+
+func TestListen_RetryAfter(t *testing.T) {
+	h := newListener(true, func(c *nano.Ctx, req interface{}) (interface{}, error) {
+		err := util.ErrCode(nil, config.ErrorCodeRateLimited, "rate limited")
+		return nil, util.WithRetryAfter(err, 1500*time.Millisecond)
+	})
+
+	r := httptest.NewRequest("GET", "/"+listenSVCName+"/dir", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	if w.Code != http.StatusTooManyRequests {
+		t.Errorf("status code == %v, want %v", w.Code, http.StatusTooManyRequests)
+	}
+	if v := w.Header().Get("Retry-After"); v != "2" {
+		t.Errorf("Retry-After == %q, want %q", v, "2")
+	}
+}
+
+func TestListen_ListenerMiddlewares(t *testing.T) {
+	var events []string
+	l := NewListener(&ListenerOptions{
+		Serializer:    json_ser.ServerSideSerializer,
+		PrefixURLPath: true,
+		Middlewares: []nano.Middleware{
+			func(next nano.RequestHandler) nano.RequestHandler {
+				return func(caller, callee *nano.Ctx, req interface{}) (interface{}, error) {
+					events = append(events, "listener:"+callee.Svc.Name()+":"+callee.ClientName)
+					return nil, util.ErrCode(nil, config.ErrorCodeOverloaded, "overloaded")
+				}
+			},
+		},
+	}, listenCFG)
+	svc := util.NewService(listenSVCName, func(c *nano.Ctx, req interface{}) (interface{}, error) {
+		events = append(events, "svc")
+		return nil, nil
+	})
+	err := l.Init(nano.NewServiceSet(svc))
+	if err != nil {
+		t.Errorf("listener init failed :: %v", err)
+		t.FailNow()
+	}
+
+	r := httptest.NewRequest("GET", "/"+listenSVCName+"/dir", nil)
+	r.Header.Set(json_ser.HeaderClientName, listenTestClientName)
+	w := httptest.NewRecorder()
+	l.(*listener).router.ServeHTTP(w, r)
+
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("status code == %v, want %v", w.Code, http.StatusServiceUnavailable)
+	}
+	want := []string{"listener:" + listenSVCName + ":" + listenTestClientName}
+	if !reflect.DeepEqual(events, want) {
+		t.Errorf("events == %v, want %v", events, want)
+	}
+}
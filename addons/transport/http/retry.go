@@ -0,0 +1,118 @@
+package http
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/pasztorpisti/nano"
+	"github.com/pasztorpisti/nano/addons/transport/http/config"
+	"github.com/pasztorpisti/nano/addons/util"
+)
+
+// RetryBudget limits the number of retries to a fraction of the requests
+// so retries can't multiply the load of an overloaded cluster. A single
+// RetryBudget can be shared by all clients of a server executable to have
+// a global budget.
+//
+// Every request deposits Ratio tokens into the budget and every retry
+// withdraws one. A retry is allowed only if there is at least one token.
+type RetryBudget struct {
+	ratio     float64
+	maxTokens float64
+
+	mu     sync.Mutex
+	tokens float64
+}
+
+// NewRetryBudget creates a budget that allows ratio retries per request
+// (e.g.: 0.1 allows 10% retries) and at most maxTokens retries in a burst.
+// The budget starts full.
+func NewRetryBudget(ratio float64, maxTokens int) *RetryBudget {
+	return &RetryBudget{
+		ratio:     ratio,
+		maxTokens: float64(maxTokens),
+		tokens:    float64(maxTokens),
+	}
+}
+
+func (p *RetryBudget) deposit() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.tokens += p.ratio
+	if p.tokens > p.maxTokens {
+		p.tokens = p.maxTokens
+	}
+}
+
+func (p *RetryBudget) withdraw() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.tokens < 1 {
+		return false
+	}
+	p.tokens--
+	return true
+}
+
+// randFloat64 returns a random number in [0, 1). Tests can replace it.
+var randFloat64 = rand.Float64
+
+// retryPolicy returns the RetryPolicy of the endpoint or nil if its requests
+// can't be retried.
+func (p *client) retryPolicy(ec *config.EndpointConfig) *config.RetryPolicy {
+	policy := p.opts.Retry
+	if ec.Retry != nil {
+		policy = ec.Retry
+	}
+	if policy == nil || policy.MaxAttempts < 2 || !ec.IsIdempotent() {
+		return nil
+	}
+	return policy
+}
+
+// shouldRetry decides whether the failed attempt should be retried.
+// transportErr is true if err is a transport error.
+func (p *client) shouldRetry(policy *config.RetryPolicy, attempt int,
+	transportErr bool, err error) bool {
+	if attempt >= policy.MaxAttempts {
+		return false
+	}
+	if !transportErr && !policy.IsRetryableCode(util.GetErrCode(err)) {
+		return false
+	}
+	return p.opts.RetryBudget == nil || p.opts.RetryBudget.withdraw()
+}
+
+// waitBackoff waits before the given retry. It returns false without
+// waiting if the retry wouldn't finish before the deadline of the request
+// and it returns false if the context of the request is done while waiting.
+// err is the error of the previous attempt, its retry hint (if any) is used
+// if it is longer than the backoff of the policy.
+func waitBackoff(c *nano.Ctx, policy *config.RetryPolicy, retry int, err error) bool {
+	backoff := policy.Backoff(retry)
+	if policy.Jitter > 0 {
+		backoff -= time.Duration(randFloat64() * policy.Jitter * float64(backoff))
+	}
+	if hint, ok := util.GetRetryAfter(err); ok && hint > backoff {
+		backoff = hint
+	}
+
+	ctx := context.Background()
+	if c != nil && c.Context != nil {
+		ctx = c.Context
+	}
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) <= backoff {
+		return false
+	}
+
+	t := time.NewTimer(backoff)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
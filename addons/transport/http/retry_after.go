@@ -0,0 +1,50 @@
+package http
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/pasztorpisti/nano/addons/util"
+)
+
+const headerRetryAfter = "Retry-After"
+
+// setRetryAfter sets the Retry-After header if err has a retry hint.
+// The header contains the hint in whole seconds rounded up.
+func setRetryAfter(h http.Header, err error) {
+	d, ok := util.GetRetryAfter(err)
+	if !ok {
+		return
+	}
+	seconds := int64((d + time.Second - 1) / time.Second)
+	if seconds < 0 {
+		seconds = 0
+	}
+	h.Set(headerRetryAfter, strconv.FormatInt(seconds, 10))
+}
+
+// withRetryAfter attaches the retry hint of the Retry-After header of h to
+// err. The header can contain either delay-seconds or an HTTP-date.
+// It returns err unchanged if h has no valid Retry-After header.
+func withRetryAfter(h http.Header, err error) error {
+	v := h.Get(headerRetryAfter)
+	if v == "" {
+		return err
+	}
+	var d time.Duration
+	if seconds, err2 := strconv.ParseInt(v, 10, 64); err2 == nil {
+		if seconds < 0 {
+			return err
+		}
+		d = time.Duration(seconds) * time.Second
+	} else if t, err2 := http.ParseTime(v); err2 == nil {
+		d = time.Until(t)
+		if d < 0 {
+			d = 0
+		}
+	} else {
+		return err
+	}
+	return util.WithRetryAfter(err, d)
+}
@@ -1,25 +1,15 @@
-// Code generated by protoc-gen-gogo.
+// Code generated by protoc-gen-gogo. DO NOT EDIT.
 // source: Transport.proto
-// DO NOT EDIT!
 
-/*
-	Package gogo_proto is a generated protocol buffer package.
-
-	It is generated from these files:
-		Transport.proto
-
-	It has these top-level messages:
-		Request
-		RequestMeta
-		ErrorResponse
-*/
 package gogo_proto
 
-import proto "github.com/gogo/protobuf/proto"
-import fmt "fmt"
-import math "math"
-
-import io "io"
+import (
+	fmt "fmt"
+	proto "github.com/gogo/protobuf/proto"
+	io "io"
+	math "math"
+	math_bits "math/bits"
+)
 
 // Reference imports to suppress errors if they are not otherwise used.
 var _ = proto.Marshal
@@ -30,17 +20,45 @@ var _ = math.Inf
 // is compatible with the proto package it is being compiled against.
 // A compilation error at this line likely means your copy of the
 // proto package needs to be updated.
-const _ = proto.GoGoProtoPackageIsVersion2 // please upgrade the proto package
+const _ = proto.GoGoProtoPackageIsVersion3 // please upgrade the proto package
 
 type Request struct {
-	Meta    *RequestMeta `protobuf:"bytes,1,opt,name=meta" json:"meta,omitempty"`
+	Meta    *RequestMeta `protobuf:"bytes,1,opt,name=meta,proto3" json:"meta,omitempty"`
 	Payload []byte       `protobuf:"bytes,2,opt,name=payload,proto3" json:"payload,omitempty"`
 }
 
-func (m *Request) Reset()                    { *m = Request{} }
-func (m *Request) String() string            { return proto.CompactTextString(m) }
-func (*Request) ProtoMessage()               {}
-func (*Request) Descriptor() ([]byte, []int) { return fileDescriptorTransport, []int{0} }
+func (m *Request) Reset()         { *m = Request{} }
+func (m *Request) String() string { return proto.CompactTextString(m) }
+func (*Request) ProtoMessage()    {}
+func (*Request) Descriptor() ([]byte, []int) {
+	return fileDescriptor_33fd10235b722e82, []int{0}
+}
+func (m *Request) XXX_Unmarshal(b []byte) error {
+	return m.Unmarshal(b)
+}
+func (m *Request) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	if deterministic {
+		return xxx_messageInfo_Request.Marshal(b, m, deterministic)
+	} else {
+		b = b[:cap(b)]
+		n, err := m.MarshalToSizedBuffer(b)
+		if err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	}
+}
+func (m *Request) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Request.Merge(m, src)
+}
+func (m *Request) XXX_Size() int {
+	return m.Size()
+}
+func (m *Request) XXX_DiscardUnknown() {
+	xxx_messageInfo_Request.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Request proto.InternalMessageInfo
 
 func (m *Request) GetMeta() *RequestMeta {
 	if m != nil {
@@ -57,14 +75,49 @@ func (m *Request) GetPayload() []byte {
 }
 
 type RequestMeta struct {
-	ReqId      string `protobuf:"bytes,1,opt,name=req_id,json=reqId,proto3" json:"req_id,omitempty"`
-	ClientName string `protobuf:"bytes,3,opt,name=client_name,json=clientName,proto3" json:"client_name,omitempty"`
+	ReqId      string            `protobuf:"bytes,1,opt,name=req_id,json=reqId,proto3" json:"req_id,omitempty"`
+	ClientName string            `protobuf:"bytes,3,opt,name=client_name,json=clientName,proto3" json:"client_name,omitempty"`
+	Metadata   map[string]string `protobuf:"bytes,4,rep,name=metadata,proto3" json:"metadata,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	// The remaining time budget of the request in milliseconds.
+	// Zero means no deadline.
+	TimeoutMs int64 `protobuf:"varint,5,opt,name=timeout_ms,json=timeoutMs,proto3" json:"timeout_ms,omitempty"`
+	// W3C traceparent of the span of the client. Empty if the client isn't
+	// part of a trace.
+	Traceparent string `protobuf:"bytes,6,opt,name=traceparent,proto3" json:"traceparent,omitempty"`
+}
+
+func (m *RequestMeta) Reset()         { *m = RequestMeta{} }
+func (m *RequestMeta) String() string { return proto.CompactTextString(m) }
+func (*RequestMeta) ProtoMessage()    {}
+func (*RequestMeta) Descriptor() ([]byte, []int) {
+	return fileDescriptor_33fd10235b722e82, []int{1}
+}
+func (m *RequestMeta) XXX_Unmarshal(b []byte) error {
+	return m.Unmarshal(b)
+}
+func (m *RequestMeta) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	if deterministic {
+		return xxx_messageInfo_RequestMeta.Marshal(b, m, deterministic)
+	} else {
+		b = b[:cap(b)]
+		n, err := m.MarshalToSizedBuffer(b)
+		if err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	}
+}
+func (m *RequestMeta) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_RequestMeta.Merge(m, src)
+}
+func (m *RequestMeta) XXX_Size() int {
+	return m.Size()
+}
+func (m *RequestMeta) XXX_DiscardUnknown() {
+	xxx_messageInfo_RequestMeta.DiscardUnknown(m)
 }
 
-func (m *RequestMeta) Reset()                    { *m = RequestMeta{} }
-func (m *RequestMeta) String() string            { return proto.CompactTextString(m) }
-func (*RequestMeta) ProtoMessage()               {}
-func (*RequestMeta) Descriptor() ([]byte, []int) { return fileDescriptorTransport, []int{1} }
+var xxx_messageInfo_RequestMeta proto.InternalMessageInfo
 
 func (m *RequestMeta) GetReqId() string {
 	if m != nil {
@@ -80,15 +133,64 @@ func (m *RequestMeta) GetClientName() string {
 	return ""
 }
 
+func (m *RequestMeta) GetMetadata() map[string]string {
+	if m != nil {
+		return m.Metadata
+	}
+	return nil
+}
+
+func (m *RequestMeta) GetTimeoutMs() int64 {
+	if m != nil {
+		return m.TimeoutMs
+	}
+	return 0
+}
+
+func (m *RequestMeta) GetTraceparent() string {
+	if m != nil {
+		return m.Traceparent
+	}
+	return ""
+}
+
 type ErrorResponse struct {
 	Code string `protobuf:"bytes,1,opt,name=code,proto3" json:"code,omitempty"`
 	Msg  string `protobuf:"bytes,2,opt,name=msg,proto3" json:"msg,omitempty"`
 }
 
-func (m *ErrorResponse) Reset()                    { *m = ErrorResponse{} }
-func (m *ErrorResponse) String() string            { return proto.CompactTextString(m) }
-func (*ErrorResponse) ProtoMessage()               {}
-func (*ErrorResponse) Descriptor() ([]byte, []int) { return fileDescriptorTransport, []int{2} }
+func (m *ErrorResponse) Reset()         { *m = ErrorResponse{} }
+func (m *ErrorResponse) String() string { return proto.CompactTextString(m) }
+func (*ErrorResponse) ProtoMessage()    {}
+func (*ErrorResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_33fd10235b722e82, []int{2}
+}
+func (m *ErrorResponse) XXX_Unmarshal(b []byte) error {
+	return m.Unmarshal(b)
+}
+func (m *ErrorResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	if deterministic {
+		return xxx_messageInfo_ErrorResponse.Marshal(b, m, deterministic)
+	} else {
+		b = b[:cap(b)]
+		n, err := m.MarshalToSizedBuffer(b)
+		if err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	}
+}
+func (m *ErrorResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ErrorResponse.Merge(m, src)
+}
+func (m *ErrorResponse) XXX_Size() int {
+	return m.Size()
+}
+func (m *ErrorResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_ErrorResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ErrorResponse proto.InternalMessageInfo
 
 func (m *ErrorResponse) GetCode() string {
 	if m != nil {
@@ -107,12 +209,41 @@ func (m *ErrorResponse) GetMsg() string {
 func init() {
 	proto.RegisterType((*Request)(nil), "gogo_proto.Request")
 	proto.RegisterType((*RequestMeta)(nil), "gogo_proto.RequestMeta")
+	proto.RegisterMapType((map[string]string)(nil), "gogo_proto.RequestMeta.MetadataEntry")
 	proto.RegisterType((*ErrorResponse)(nil), "gogo_proto.ErrorResponse")
 }
+
+func init() { proto.RegisterFile("Transport.proto", fileDescriptor_33fd10235b722e82) }
+
+var fileDescriptor_33fd10235b722e82 = []byte{
+	// 331 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x74, 0x90, 0xcf, 0x4a, 0xf3, 0x40,
+	0x14, 0xc5, 0x3b, 0x4d, 0xff, 0x7c, 0xbd, 0xf9, 0x8a, 0x32, 0x28, 0x0e, 0x82, 0x31, 0x14, 0x84,
+	0x80, 0x90, 0x45, 0x45, 0x10, 0x5d, 0x29, 0x74, 0xe1, 0xa2, 0x22, 0x83, 0xfb, 0x30, 0x36, 0x97,
+	0x52, 0x6c, 0x32, 0xe9, 0xcc, 0xad, 0xd0, 0x17, 0x70, 0xed, 0x63, 0xb9, 0xec, 0xd2, 0xa5, 0xb4,
+	0x2f, 0x22, 0x99, 0x46, 0xad, 0x0b, 0x77, 0xe7, 0x9e, 0xfc, 0xce, 0xc9, 0xbd, 0x03, 0x3b, 0x0f,
+	0x46, 0xe5, 0xb6, 0xd0, 0x86, 0xe2, 0xc2, 0x68, 0xd2, 0x1c, 0xc6, 0x7a, 0xac, 0x13, 0xa7, 0x7b,
+	0xf7, 0xd0, 0x96, 0x38, 0x9b, 0xa3, 0x25, 0x7e, 0x0a, 0x8d, 0x0c, 0x49, 0x09, 0x16, 0xb2, 0xc8,
+	0xef, 0x1f, 0xc4, 0x3f, 0x54, 0x5c, 0x21, 0x43, 0x24, 0x25, 0x1d, 0xc4, 0x05, 0xb4, 0x0b, 0xb5,
+	0x98, 0x6a, 0x95, 0x8a, 0x7a, 0xc8, 0xa2, 0xff, 0xf2, 0x6b, 0xec, 0xbd, 0xd4, 0xc1, 0xdf, 0xe2,
+	0xf9, 0x3e, 0xb4, 0x0c, 0xce, 0x92, 0x49, 0xea, 0x8a, 0x3b, 0xb2, 0x69, 0x70, 0x76, 0x9b, 0xf2,
+	0x63, 0xf0, 0x47, 0xd3, 0x09, 0xe6, 0x94, 0xe4, 0x2a, 0x43, 0xe1, 0xb9, 0x6f, 0xb0, 0xb1, 0xee,
+	0x54, 0x86, 0xfc, 0x1a, 0xfe, 0x95, 0x7f, 0x4a, 0x15, 0x29, 0xd1, 0x08, 0xbd, 0xc8, 0xef, 0x9f,
+	0xfc, 0xb1, 0x52, 0x3c, 0xac, 0xb8, 0x41, 0x4e, 0x66, 0x21, 0xbf, 0x63, 0xfc, 0x08, 0x80, 0x26,
+	0x19, 0xea, 0x39, 0x25, 0x99, 0x15, 0xcd, 0x90, 0x45, 0x9e, 0xec, 0x54, 0xce, 0xd0, 0xf2, 0x10,
+	0x7c, 0x32, 0x6a, 0x84, 0x85, 0x32, 0x98, 0x93, 0x68, 0xb9, 0x15, 0xb6, 0xad, 0xc3, 0x2b, 0xe8,
+	0xfe, 0xea, 0xe6, 0xbb, 0xe0, 0x3d, 0xe1, 0xa2, 0xba, 0xa4, 0x94, 0x7c, 0x0f, 0x9a, 0xcf, 0x6a,
+	0x3a, 0x47, 0xf7, 0x0c, 0x1d, 0xb9, 0x19, 0x2e, 0xeb, 0x17, 0xac, 0x77, 0x0e, 0xdd, 0x81, 0x31,
+	0xda, 0x48, 0xb4, 0x85, 0xce, 0x2d, 0x72, 0x0e, 0x8d, 0x91, 0x4e, 0xb1, 0x4a, 0x3b, 0x5d, 0x16,
+	0x66, 0x76, 0x5c, 0x85, 0x4b, 0x79, 0x23, 0xde, 0x56, 0x01, 0x5b, 0xae, 0x02, 0xf6, 0xb1, 0x0a,
+	0xd8, 0xeb, 0x3a, 0xa8, 0x2d, 0xd7, 0x41, 0xed, 0x7d, 0x1d, 0xd4, 0x1e, 0x5b, 0xee, 0xf2, 0xb3,
+	0xcf, 0x01, 0x00, 0x3c, 0x7b, 0xd1, 0xf1, 0xd1, 0x01, 0x00, 0x00,
+}
+
 func (m *Request) Marshal() (dAtA []byte, err error) {
 	size := m.Size()
 	dAtA = make([]byte, size)
-	n, err := m.MarshalTo(dAtA)
+	n, err := m.MarshalToSizedBuffer(dAtA[:size])
 	if err != nil {
 		return nil, err
 	}
@@ -120,33 +251,41 @@ func (m *Request) Marshal() (dAtA []byte, err error) {
 }
 
 func (m *Request) MarshalTo(dAtA []byte) (int, error) {
-	var i int
+	size := m.Size()
+	return m.MarshalToSizedBuffer(dAtA[:size])
+}
+
+func (m *Request) MarshalToSizedBuffer(dAtA []byte) (int, error) {
+	i := len(dAtA)
 	_ = i
 	var l int
 	_ = l
-	if m.Meta != nil {
-		dAtA[i] = 0xa
-		i++
-		i = encodeVarintTransport(dAtA, i, uint64(m.Meta.Size()))
-		n1, err := m.Meta.MarshalTo(dAtA[i:])
-		if err != nil {
-			return 0, err
-		}
-		i += n1
-	}
 	if len(m.Payload) > 0 {
-		dAtA[i] = 0x12
-		i++
+		i -= len(m.Payload)
+		copy(dAtA[i:], m.Payload)
 		i = encodeVarintTransport(dAtA, i, uint64(len(m.Payload)))
-		i += copy(dAtA[i:], m.Payload)
+		i--
+		dAtA[i] = 0x12
 	}
-	return i, nil
+	if m.Meta != nil {
+		{
+			size, err := m.Meta.MarshalToSizedBuffer(dAtA[:i])
+			if err != nil {
+				return 0, err
+			}
+			i -= size
+			i = encodeVarintTransport(dAtA, i, uint64(size))
+		}
+		i--
+		dAtA[i] = 0xa
+	}
+	return len(dAtA) - i, nil
 }
 
 func (m *RequestMeta) Marshal() (dAtA []byte, err error) {
 	size := m.Size()
 	dAtA = make([]byte, size)
-	n, err := m.MarshalTo(dAtA)
+	n, err := m.MarshalToSizedBuffer(dAtA[:size])
 	if err != nil {
 		return nil, err
 	}
@@ -154,29 +293,67 @@ func (m *RequestMeta) Marshal() (dAtA []byte, err error) {
 }
 
 func (m *RequestMeta) MarshalTo(dAtA []byte) (int, error) {
-	var i int
+	size := m.Size()
+	return m.MarshalToSizedBuffer(dAtA[:size])
+}
+
+func (m *RequestMeta) MarshalToSizedBuffer(dAtA []byte) (int, error) {
+	i := len(dAtA)
 	_ = i
 	var l int
 	_ = l
-	if len(m.ReqId) > 0 {
-		dAtA[i] = 0xa
-		i++
-		i = encodeVarintTransport(dAtA, i, uint64(len(m.ReqId)))
-		i += copy(dAtA[i:], m.ReqId)
+	if len(m.Traceparent) > 0 {
+		i -= len(m.Traceparent)
+		copy(dAtA[i:], m.Traceparent)
+		i = encodeVarintTransport(dAtA, i, uint64(len(m.Traceparent)))
+		i--
+		dAtA[i] = 0x32
+	}
+	if m.TimeoutMs != 0 {
+		i = encodeVarintTransport(dAtA, i, uint64(m.TimeoutMs))
+		i--
+		dAtA[i] = 0x28
+	}
+	if len(m.Metadata) > 0 {
+		for k := range m.Metadata {
+			v := m.Metadata[k]
+			baseI := i
+			i -= len(v)
+			copy(dAtA[i:], v)
+			i = encodeVarintTransport(dAtA, i, uint64(len(v)))
+			i--
+			dAtA[i] = 0x12
+			i -= len(k)
+			copy(dAtA[i:], k)
+			i = encodeVarintTransport(dAtA, i, uint64(len(k)))
+			i--
+			dAtA[i] = 0xa
+			i = encodeVarintTransport(dAtA, i, uint64(baseI-i))
+			i--
+			dAtA[i] = 0x22
+		}
 	}
 	if len(m.ClientName) > 0 {
-		dAtA[i] = 0x1a
-		i++
+		i -= len(m.ClientName)
+		copy(dAtA[i:], m.ClientName)
 		i = encodeVarintTransport(dAtA, i, uint64(len(m.ClientName)))
-		i += copy(dAtA[i:], m.ClientName)
+		i--
+		dAtA[i] = 0x1a
 	}
-	return i, nil
+	if len(m.ReqId) > 0 {
+		i -= len(m.ReqId)
+		copy(dAtA[i:], m.ReqId)
+		i = encodeVarintTransport(dAtA, i, uint64(len(m.ReqId)))
+		i--
+		dAtA[i] = 0xa
+	}
+	return len(dAtA) - i, nil
 }
 
 func (m *ErrorResponse) Marshal() (dAtA []byte, err error) {
 	size := m.Size()
 	dAtA = make([]byte, size)
-	n, err := m.MarshalTo(dAtA)
+	n, err := m.MarshalToSizedBuffer(dAtA[:size])
 	if err != nil {
 		return nil, err
 	}
@@ -184,53 +361,47 @@ func (m *ErrorResponse) Marshal() (dAtA []byte, err error) {
 }
 
 func (m *ErrorResponse) MarshalTo(dAtA []byte) (int, error) {
-	var i int
+	size := m.Size()
+	return m.MarshalToSizedBuffer(dAtA[:size])
+}
+
+func (m *ErrorResponse) MarshalToSizedBuffer(dAtA []byte) (int, error) {
+	i := len(dAtA)
 	_ = i
 	var l int
 	_ = l
-	if len(m.Code) > 0 {
-		dAtA[i] = 0xa
-		i++
-		i = encodeVarintTransport(dAtA, i, uint64(len(m.Code)))
-		i += copy(dAtA[i:], m.Code)
-	}
 	if len(m.Msg) > 0 {
-		dAtA[i] = 0x12
-		i++
+		i -= len(m.Msg)
+		copy(dAtA[i:], m.Msg)
 		i = encodeVarintTransport(dAtA, i, uint64(len(m.Msg)))
-		i += copy(dAtA[i:], m.Msg)
+		i--
+		dAtA[i] = 0x12
+	}
+	if len(m.Code) > 0 {
+		i -= len(m.Code)
+		copy(dAtA[i:], m.Code)
+		i = encodeVarintTransport(dAtA, i, uint64(len(m.Code)))
+		i--
+		dAtA[i] = 0xa
 	}
-	return i, nil
+	return len(dAtA) - i, nil
 }
 
-func encodeFixed64Transport(dAtA []byte, offset int, v uint64) int {
-	dAtA[offset] = uint8(v)
-	dAtA[offset+1] = uint8(v >> 8)
-	dAtA[offset+2] = uint8(v >> 16)
-	dAtA[offset+3] = uint8(v >> 24)
-	dAtA[offset+4] = uint8(v >> 32)
-	dAtA[offset+5] = uint8(v >> 40)
-	dAtA[offset+6] = uint8(v >> 48)
-	dAtA[offset+7] = uint8(v >> 56)
-	return offset + 8
-}
-func encodeFixed32Transport(dAtA []byte, offset int, v uint32) int {
-	dAtA[offset] = uint8(v)
-	dAtA[offset+1] = uint8(v >> 8)
-	dAtA[offset+2] = uint8(v >> 16)
-	dAtA[offset+3] = uint8(v >> 24)
-	return offset + 4
-}
 func encodeVarintTransport(dAtA []byte, offset int, v uint64) int {
+	offset -= sovTransport(v)
+	base := offset
 	for v >= 1<<7 {
 		dAtA[offset] = uint8(v&0x7f | 0x80)
 		v >>= 7
 		offset++
 	}
 	dAtA[offset] = uint8(v)
-	return offset + 1
+	return base
 }
 func (m *Request) Size() (n int) {
+	if m == nil {
+		return 0
+	}
 	var l int
 	_ = l
 	if m.Meta != nil {
@@ -245,6 +416,9 @@ func (m *Request) Size() (n int) {
 }
 
 func (m *RequestMeta) Size() (n int) {
+	if m == nil {
+		return 0
+	}
 	var l int
 	_ = l
 	l = len(m.ReqId)
@@ -255,10 +429,28 @@ func (m *RequestMeta) Size() (n int) {
 	if l > 0 {
 		n += 1 + l + sovTransport(uint64(l))
 	}
+	if len(m.Metadata) > 0 {
+		for k, v := range m.Metadata {
+			_ = k
+			_ = v
+			mapEntrySize := 1 + len(k) + sovTransport(uint64(len(k))) + 1 + len(v) + sovTransport(uint64(len(v)))
+			n += mapEntrySize + 1 + sovTransport(uint64(mapEntrySize))
+		}
+	}
+	if m.TimeoutMs != 0 {
+		n += 1 + sovTransport(uint64(m.TimeoutMs))
+	}
+	l = len(m.Traceparent)
+	if l > 0 {
+		n += 1 + l + sovTransport(uint64(l))
+	}
 	return n
 }
 
 func (m *ErrorResponse) Size() (n int) {
+	if m == nil {
+		return 0
+	}
 	var l int
 	_ = l
 	l = len(m.Code)
@@ -273,14 +465,7 @@ func (m *ErrorResponse) Size() (n int) {
 }
 
 func sovTransport(x uint64) (n int) {
-	for {
-		n++
-		x >>= 7
-		if x == 0 {
-			break
-		}
-	}
-	return n
+	return (math_bits.Len64(x|1) + 6) / 7
 }
 func sozTransport(x uint64) (n int) {
 	return sovTransport(uint64((x << 1) ^ uint64((int64(x) >> 63))))
@@ -300,7 +485,7 @@ func (m *Request) Unmarshal(dAtA []byte) error {
 			}
 			b := dAtA[iNdEx]
 			iNdEx++
-			wire |= (uint64(b) & 0x7F) << shift
+			wire |= uint64(b&0x7F) << shift
 			if b < 0x80 {
 				break
 			}
@@ -328,7 +513,7 @@ func (m *Request) Unmarshal(dAtA []byte) error {
 				}
 				b := dAtA[iNdEx]
 				iNdEx++
-				msglen |= (int(b) & 0x7F) << shift
+				msglen |= int(b&0x7F) << shift
 				if b < 0x80 {
 					break
 				}
@@ -337,6 +522,9 @@ func (m *Request) Unmarshal(dAtA []byte) error {
 				return ErrInvalidLengthTransport
 			}
 			postIndex := iNdEx + msglen
+			if postIndex < 0 {
+				return ErrInvalidLengthTransport
+			}
 			if postIndex > l {
 				return io.ErrUnexpectedEOF
 			}
@@ -361,7 +549,7 @@ func (m *Request) Unmarshal(dAtA []byte) error {
 				}
 				b := dAtA[iNdEx]
 				iNdEx++
-				byteLen |= (int(b) & 0x7F) << shift
+				byteLen |= int(b&0x7F) << shift
 				if b < 0x80 {
 					break
 				}
@@ -370,6 +558,9 @@ func (m *Request) Unmarshal(dAtA []byte) error {
 				return ErrInvalidLengthTransport
 			}
 			postIndex := iNdEx + byteLen
+			if postIndex < 0 {
+				return ErrInvalidLengthTransport
+			}
 			if postIndex > l {
 				return io.ErrUnexpectedEOF
 			}
@@ -384,7 +575,7 @@ func (m *Request) Unmarshal(dAtA []byte) error {
 			if err != nil {
 				return err
 			}
-			if skippy < 0 {
+			if (skippy < 0) || (iNdEx+skippy) < 0 {
 				return ErrInvalidLengthTransport
 			}
 			if (iNdEx + skippy) > l {
@@ -414,7 +605,7 @@ func (m *RequestMeta) Unmarshal(dAtA []byte) error {
 			}
 			b := dAtA[iNdEx]
 			iNdEx++
-			wire |= (uint64(b) & 0x7F) << shift
+			wire |= uint64(b&0x7F) << shift
 			if b < 0x80 {
 				break
 			}
@@ -442,7 +633,7 @@ func (m *RequestMeta) Unmarshal(dAtA []byte) error {
 				}
 				b := dAtA[iNdEx]
 				iNdEx++
-				stringLen |= (uint64(b) & 0x7F) << shift
+				stringLen |= uint64(b&0x7F) << shift
 				if b < 0x80 {
 					break
 				}
@@ -452,6 +643,9 @@ func (m *RequestMeta) Unmarshal(dAtA []byte) error {
 				return ErrInvalidLengthTransport
 			}
 			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthTransport
+			}
 			if postIndex > l {
 				return io.ErrUnexpectedEOF
 			}
@@ -471,7 +665,7 @@ func (m *RequestMeta) Unmarshal(dAtA []byte) error {
 				}
 				b := dAtA[iNdEx]
 				iNdEx++
-				stringLen |= (uint64(b) & 0x7F) << shift
+				stringLen |= uint64(b&0x7F) << shift
 				if b < 0x80 {
 					break
 				}
@@ -481,18 +675,199 @@ func (m *RequestMeta) Unmarshal(dAtA []byte) error {
 				return ErrInvalidLengthTransport
 			}
 			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthTransport
+			}
 			if postIndex > l {
 				return io.ErrUnexpectedEOF
 			}
 			m.ClientName = string(dAtA[iNdEx:postIndex])
 			iNdEx = postIndex
+		case 4:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Metadata", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowTransport
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthTransport
+			}
+			postIndex := iNdEx + msglen
+			if postIndex < 0 {
+				return ErrInvalidLengthTransport
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			if m.Metadata == nil {
+				m.Metadata = make(map[string]string)
+			}
+			var mapkey string
+			var mapvalue string
+			for iNdEx < postIndex {
+				entryPreIndex := iNdEx
+				var wire uint64
+				for shift := uint(0); ; shift += 7 {
+					if shift >= 64 {
+						return ErrIntOverflowTransport
+					}
+					if iNdEx >= l {
+						return io.ErrUnexpectedEOF
+					}
+					b := dAtA[iNdEx]
+					iNdEx++
+					wire |= uint64(b&0x7F) << shift
+					if b < 0x80 {
+						break
+					}
+				}
+				fieldNum := int32(wire >> 3)
+				if fieldNum == 1 {
+					var stringLenmapkey uint64
+					for shift := uint(0); ; shift += 7 {
+						if shift >= 64 {
+							return ErrIntOverflowTransport
+						}
+						if iNdEx >= l {
+							return io.ErrUnexpectedEOF
+						}
+						b := dAtA[iNdEx]
+						iNdEx++
+						stringLenmapkey |= uint64(b&0x7F) << shift
+						if b < 0x80 {
+							break
+						}
+					}
+					intStringLenmapkey := int(stringLenmapkey)
+					if intStringLenmapkey < 0 {
+						return ErrInvalidLengthTransport
+					}
+					postStringIndexmapkey := iNdEx + intStringLenmapkey
+					if postStringIndexmapkey < 0 {
+						return ErrInvalidLengthTransport
+					}
+					if postStringIndexmapkey > l {
+						return io.ErrUnexpectedEOF
+					}
+					mapkey = string(dAtA[iNdEx:postStringIndexmapkey])
+					iNdEx = postStringIndexmapkey
+				} else if fieldNum == 2 {
+					var stringLenmapvalue uint64
+					for shift := uint(0); ; shift += 7 {
+						if shift >= 64 {
+							return ErrIntOverflowTransport
+						}
+						if iNdEx >= l {
+							return io.ErrUnexpectedEOF
+						}
+						b := dAtA[iNdEx]
+						iNdEx++
+						stringLenmapvalue |= uint64(b&0x7F) << shift
+						if b < 0x80 {
+							break
+						}
+					}
+					intStringLenmapvalue := int(stringLenmapvalue)
+					if intStringLenmapvalue < 0 {
+						return ErrInvalidLengthTransport
+					}
+					postStringIndexmapvalue := iNdEx + intStringLenmapvalue
+					if postStringIndexmapvalue < 0 {
+						return ErrInvalidLengthTransport
+					}
+					if postStringIndexmapvalue > l {
+						return io.ErrUnexpectedEOF
+					}
+					mapvalue = string(dAtA[iNdEx:postStringIndexmapvalue])
+					iNdEx = postStringIndexmapvalue
+				} else {
+					iNdEx = entryPreIndex
+					skippy, err := skipTransport(dAtA[iNdEx:])
+					if err != nil {
+						return err
+					}
+					if (skippy < 0) || (iNdEx+skippy) < 0 {
+						return ErrInvalidLengthTransport
+					}
+					if (iNdEx + skippy) > postIndex {
+						return io.ErrUnexpectedEOF
+					}
+					iNdEx += skippy
+				}
+			}
+			m.Metadata[mapkey] = mapvalue
+			iNdEx = postIndex
+		case 5:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field TimeoutMs", wireType)
+			}
+			m.TimeoutMs = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowTransport
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.TimeoutMs |= int64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 6:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Traceparent", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowTransport
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthTransport
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthTransport
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Traceparent = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
 		default:
 			iNdEx = preIndex
 			skippy, err := skipTransport(dAtA[iNdEx:])
 			if err != nil {
 				return err
 			}
-			if skippy < 0 {
+			if (skippy < 0) || (iNdEx+skippy) < 0 {
 				return ErrInvalidLengthTransport
 			}
 			if (iNdEx + skippy) > l {
@@ -522,7 +897,7 @@ func (m *ErrorResponse) Unmarshal(dAtA []byte) error {
 			}
 			b := dAtA[iNdEx]
 			iNdEx++
-			wire |= (uint64(b) & 0x7F) << shift
+			wire |= uint64(b&0x7F) << shift
 			if b < 0x80 {
 				break
 			}
@@ -550,7 +925,7 @@ func (m *ErrorResponse) Unmarshal(dAtA []byte) error {
 				}
 				b := dAtA[iNdEx]
 				iNdEx++
-				stringLen |= (uint64(b) & 0x7F) << shift
+				stringLen |= uint64(b&0x7F) << shift
 				if b < 0x80 {
 					break
 				}
@@ -560,6 +935,9 @@ func (m *ErrorResponse) Unmarshal(dAtA []byte) error {
 				return ErrInvalidLengthTransport
 			}
 			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthTransport
+			}
 			if postIndex > l {
 				return io.ErrUnexpectedEOF
 			}
@@ -579,7 +957,7 @@ func (m *ErrorResponse) Unmarshal(dAtA []byte) error {
 				}
 				b := dAtA[iNdEx]
 				iNdEx++
-				stringLen |= (uint64(b) & 0x7F) << shift
+				stringLen |= uint64(b&0x7F) << shift
 				if b < 0x80 {
 					break
 				}
@@ -589,6 +967,9 @@ func (m *ErrorResponse) Unmarshal(dAtA []byte) error {
 				return ErrInvalidLengthTransport
 			}
 			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthTransport
+			}
 			if postIndex > l {
 				return io.ErrUnexpectedEOF
 			}
@@ -600,7 +981,7 @@ func (m *ErrorResponse) Unmarshal(dAtA []byte) error {
 			if err != nil {
 				return err
 			}
-			if skippy < 0 {
+			if (skippy < 0) || (iNdEx+skippy) < 0 {
 				return ErrInvalidLengthTransport
 			}
 			if (iNdEx + skippy) > l {
@@ -618,6 +999,7 @@ func (m *ErrorResponse) Unmarshal(dAtA []byte) error {
 func skipTransport(dAtA []byte) (n int, err error) {
 	l := len(dAtA)
 	iNdEx := 0
+	depth := 0
 	for iNdEx < l {
 		var wire uint64
 		for shift := uint(0); ; shift += 7 {
@@ -649,10 +1031,8 @@ func skipTransport(dAtA []byte) (n int, err error) {
 					break
 				}
 			}
-			return iNdEx, nil
 		case 1:
 			iNdEx += 8
-			return iNdEx, nil
 		case 2:
 			var length int
 			for shift := uint(0); ; shift += 7 {
@@ -669,74 +1049,34 @@ func skipTransport(dAtA []byte) (n int, err error) {
 					break
 				}
 			}
-			iNdEx += length
 			if length < 0 {
 				return 0, ErrInvalidLengthTransport
 			}
-			return iNdEx, nil
+			iNdEx += length
 		case 3:
-			for {
-				var innerWire uint64
-				var start int = iNdEx
-				for shift := uint(0); ; shift += 7 {
-					if shift >= 64 {
-						return 0, ErrIntOverflowTransport
-					}
-					if iNdEx >= l {
-						return 0, io.ErrUnexpectedEOF
-					}
-					b := dAtA[iNdEx]
-					iNdEx++
-					innerWire |= (uint64(b) & 0x7F) << shift
-					if b < 0x80 {
-						break
-					}
-				}
-				innerWireType := int(innerWire & 0x7)
-				if innerWireType == 4 {
-					break
-				}
-				next, err := skipTransport(dAtA[start:])
-				if err != nil {
-					return 0, err
-				}
-				iNdEx = start + next
-			}
-			return iNdEx, nil
+			depth++
 		case 4:
-			return iNdEx, nil
+			if depth == 0 {
+				return 0, ErrUnexpectedEndOfGroupTransport
+			}
+			depth--
 		case 5:
 			iNdEx += 4
-			return iNdEx, nil
 		default:
 			return 0, fmt.Errorf("proto: illegal wireType %d", wireType)
 		}
+		if iNdEx < 0 {
+			return 0, ErrInvalidLengthTransport
+		}
+		if depth == 0 {
+			return iNdEx, nil
+		}
 	}
-	panic("unreachable")
+	return 0, io.ErrUnexpectedEOF
 }
 
 var (
-	ErrInvalidLengthTransport = fmt.Errorf("proto: negative length found during unmarshaling")
-	ErrIntOverflowTransport   = fmt.Errorf("proto: integer overflow")
+	ErrInvalidLengthTransport        = fmt.Errorf("proto: negative length found during unmarshaling")
+	ErrIntOverflowTransport          = fmt.Errorf("proto: integer overflow")
+	ErrUnexpectedEndOfGroupTransport = fmt.Errorf("proto: unexpected end of group")
 )
-
-func init() { proto.RegisterFile("Transport.proto", fileDescriptorTransport) }
-
-var fileDescriptorTransport = []byte{
-	// 228 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x09, 0x6e, 0x88, 0x02, 0xff, 0xe2, 0xe2, 0x0f, 0x29, 0x4a, 0xcc,
-	0x2b, 0x2e, 0xc8, 0x2f, 0x2a, 0xd1, 0x2b, 0x28, 0xca, 0x2f, 0xc9, 0x17, 0xe2, 0x4a, 0xcf, 0x4f,
-	0xcf, 0x8f, 0x07, 0xb3, 0x95, 0x02, 0xb8, 0xd8, 0x83, 0x52, 0x0b, 0x4b, 0x53, 0x8b, 0x4b, 0x84,
-	0xb4, 0xb9, 0x58, 0x72, 0x53, 0x4b, 0x12, 0x25, 0x18, 0x15, 0x18, 0x35, 0xb8, 0x8d, 0xc4, 0xf5,
-	0x10, 0xaa, 0xf4, 0xa0, 0x4a, 0x7c, 0x53, 0x4b, 0x12, 0x83, 0xc0, 0x8a, 0x84, 0x24, 0xb8, 0xd8,
-	0x0b, 0x12, 0x2b, 0x73, 0xf2, 0x13, 0x53, 0x24, 0x98, 0x14, 0x18, 0x35, 0x78, 0x82, 0x60, 0x5c,
-	0x25, 0x57, 0x2e, 0x6e, 0x24, 0xe5, 0x42, 0xa2, 0x5c, 0x6c, 0x45, 0xa9, 0x85, 0xf1, 0x99, 0x29,
-	0x60, 0x73, 0x39, 0x83, 0x58, 0x8b, 0x52, 0x0b, 0x3d, 0x53, 0x84, 0xe4, 0xb9, 0xb8, 0x93, 0x73,
-	0x32, 0x53, 0xf3, 0x4a, 0xe2, 0xf3, 0x12, 0x73, 0x53, 0x25, 0x98, 0xc1, 0x72, 0x5c, 0x10, 0x21,
-	0xbf, 0xc4, 0xdc, 0x54, 0x25, 0x53, 0x2e, 0x5e, 0xd7, 0xa2, 0xa2, 0xfc, 0xa2, 0xa0, 0xd4, 0xe2,
-	0x82, 0xfc, 0xbc, 0xe2, 0x54, 0x21, 0x21, 0x2e, 0x96, 0xe4, 0xfc, 0x94, 0x54, 0xa8, 0x31, 0x60,
-	0xb6, 0x90, 0x00, 0x17, 0x73, 0x6e, 0x71, 0x3a, 0xd8, 0x05, 0x9c, 0x41, 0x20, 0xa6, 0x93, 0xc0,
-	0x89, 0x47, 0x72, 0x8c, 0x17, 0x1e, 0xc9, 0x31, 0x3e, 0x78, 0x24, 0xc7, 0x38, 0xe1, 0xb1, 0x1c,
-	0x43, 0x12, 0x1b, 0xd8, 0x0b, 0xc6, 0x80, 0x00, 0x00, 0x00, 0xff, 0xff, 0x4a, 0x3b, 0xa1, 0x17,
-	0x07, 0x01, 0x00, 0x00,
-}
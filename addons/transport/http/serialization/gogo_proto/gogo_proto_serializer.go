@@ -5,6 +5,7 @@ import (
 	"mime"
 	"net/http"
 	"reflect"
+	"time"
 
 	"github.com/gogo/protobuf/proto"
 	"github.com/pasztorpisti/nano"
@@ -40,8 +41,11 @@ func (reqSerializer) SerializeRequest(ec *config.EndpointConfig,
 
 	request := &Request{
 		Meta: &RequestMeta{
-			ReqId:      c.ReqID,
-			ClientName: c.ClientName,
+			ReqId:       c.ReqID,
+			ClientName:  c.ClientName,
+			Metadata:    c.Metadata,
+			TimeoutMs:   serialization.DurationToMs(serialization.Timeout(c)),
+			Traceparent: serialization.FormatTraceparent(c.Trace),
 		},
 		Payload: payload,
 	}
@@ -107,6 +111,17 @@ func (reqDeserializer) DeserializeRequest(ec *config.EndpointConfig,
 
 	ri.ReqID = request.Meta.ReqId
 	ri.ClientName = request.Meta.ClientName
+	ri.Metadata = request.Meta.Metadata
+	if request.Meta.TimeoutMs < 0 {
+		err = util.ErrCodef(nil, config.ErrorCodeBadRequest,
+			"invalid request timeout: %v", request.Meta.TimeoutMs)
+		return
+	}
+	ri.Timeout = time.Duration(request.Meta.TimeoutMs) * time.Millisecond
+	if request.Meta.Traceparent != "" {
+		// An invalid traceparent is ignored as the W3C specification requires.
+		ri.Trace, _ = serialization.ParseTraceparent(request.Meta.Traceparent)
+	}
 	return
 }
 
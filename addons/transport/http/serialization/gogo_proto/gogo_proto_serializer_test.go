@@ -0,0 +1,86 @@
+package gogo_proto
+
+import (
+	"bytes"
+	"context"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/pasztorpisti/nano"
+	"github.com/pasztorpisti/nano/addons/transport/http/config"
+)
+
+// The ErrorResponse message is used as the request type of the test endpoint
+// because it is a proto.Message.
+var endpointConfig = &config.EndpointConfig{
+	Method:        "POST",
+	Path:          "/path",
+	HasReqContent: true,
+	ReqType:       reflect.TypeOf((*ErrorResponse)(nil)).Elem(),
+	RespType:      nil,
+}
+
+const (
+	testReqID      = "TestReqID"
+	testClientName = "test"
+)
+
+func TestReqSerialization(t *testing.T) {
+	metadata := map[string]string{
+		"tenant_id": "tenant1",
+		"locale":    "en-GB",
+	}
+	c := &nano.Ctx{
+		ReqID:      testReqID,
+		Context:    context.Background(),
+		ClientName: testClientName,
+		Metadata:   metadata,
+		Trace: &nano.SpanContext{
+			TraceID:      "4bf92f3577b34da6a3ce929d0e0e4736",
+			SpanID:       "00f067aa0ba902b7",
+			ParentSpanID: "b7ad6b7169203331",
+			Sampled:      true,
+		},
+	}
+	ec := endpointConfig
+	inputReq := &ErrorResponse{Code: "code", Msg: "msg"}
+	h, body, err := ClientSideSerializer.ReqSerializer.SerializeRequest(ec, c, inputReq)
+	if err != nil {
+		t.Errorf("SerializeRequest failed :: %v", err)
+		t.FailNow()
+	}
+
+	r := httptest.NewRequest(ec.Method, ec.Path, bytes.NewReader(body))
+	r.Header = h
+	reqObj, ri, err := ServerSideSerializer.ReqDeserializer.DeserializeRequest(ec, r)
+	if err != nil {
+		t.Errorf("DeserializeRequest failed :: %v", err)
+		t.FailNow()
+	}
+	req, ok := reqObj.(*ErrorResponse)
+	if !ok {
+		t.Errorf("deserialised req type == %T, want %T", reqObj, inputReq)
+		t.FailNow()
+	}
+	if req.Code != inputReq.Code || req.Msg != inputReq.Msg {
+		t.Errorf("deserialised req == %#v, want %#v", req, inputReq)
+	}
+	if ri.ReqID != testReqID {
+		t.Errorf("deserialised req id == %q, want %q", ri.ReqID, testReqID)
+	}
+	if ri.ClientName != testClientName {
+		t.Errorf("deserialised req client name == %q, want %q", ri.ClientName, testClientName)
+	}
+	if !reflect.DeepEqual(ri.Metadata, metadata) {
+		t.Errorf("deserialised metadata == %v, want %v", ri.Metadata, metadata)
+	}
+	wantTrace := nano.SpanContext{
+		TraceID: c.Trace.TraceID,
+		SpanID:  c.Trace.SpanID,
+		Sampled: true,
+	}
+	if ri.Trace == nil || *ri.Trace != wantTrace {
+		t.Errorf("deserialised trace == %+v, want %+v", ri.Trace, wantTrace)
+	}
+}
@@ -6,8 +6,11 @@ import (
 	"io/ioutil"
 	"mime"
 	"net/http"
+	"net/url"
 	"reflect"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/pasztorpisti/nano"
 	"github.com/pasztorpisti/nano/addons/transport/http/config"
@@ -33,6 +36,15 @@ type ErrorResponse struct {
 const (
 	HeaderReqID      = "X-Nano-Req-Id"
 	HeaderClientName = "X-Nano-Client-Name"
+	// HeaderMetadata contains the nano.Ctx.Metadata in URL query format
+	// ("key1=value1&key2=value2") in order to preserve the case of the keys.
+	HeaderMetadata = "X-Nano-Metadata"
+	// HeaderTimeout contains the remaining time budget of the request in
+	// milliseconds.
+	HeaderTimeout = "X-Nano-Timeout-Ms"
+	// HeaderTraceparent contains the nano.Ctx.Trace in W3C Trace Context
+	// format. An invalid traceparent is ignored as the specification requires.
+	HeaderTraceparent = "Traceparent"
 )
 
 type reqSerializer struct{}
@@ -47,7 +59,7 @@ func (reqSerializer) SerializeRequest(ec *config.EndpointConfig,
 		}
 	}
 
-	h = make(http.Header, 3)
+	h = make(http.Header, 5)
 	if ec.HasReqContent {
 		h.Set("Content-Type", "application/json; charset=utf-8")
 	}
@@ -57,6 +69,19 @@ func (reqSerializer) SerializeRequest(ec *config.EndpointConfig,
 	if c.ClientName != "" {
 		h.Set(HeaderClientName, c.ClientName)
 	}
+	if len(c.Metadata) != 0 {
+		metadata := make(url.Values, len(c.Metadata))
+		for k, v := range c.Metadata {
+			metadata.Set(k, v)
+		}
+		h.Set(HeaderMetadata, metadata.Encode())
+	}
+	if timeout := serialization.Timeout(c); timeout != 0 {
+		h.Set(HeaderTimeout, strconv.FormatInt(serialization.DurationToMs(timeout), 10))
+	}
+	if c.Trace != nil {
+		h.Set(HeaderTraceparent, serialization.FormatTraceparent(c.Trace))
+	}
 	return
 }
 
@@ -122,6 +147,33 @@ func (reqDeserializer) DeserializeRequest(ec *config.EndpointConfig,
 
 	ri.ReqID = r.Header.Get(HeaderReqID)
 	ri.ClientName = r.Header.Get(HeaderClientName)
+
+	if v := r.Header.Get(HeaderMetadata); v != "" {
+		metadata, err2 := url.ParseQuery(v)
+		if err2 != nil {
+			err = util.ErrCodef(err2, config.ErrorCodeBadRequest,
+				"error parsing %v header", HeaderMetadata)
+			return
+		}
+		ri.Metadata = make(map[string]string, len(metadata))
+		for k := range metadata {
+			ri.Metadata[k] = metadata.Get(k)
+		}
+	}
+
+	if v := r.Header.Get(HeaderTimeout); v != "" {
+		ms, err2 := strconv.ParseInt(v, 10, 64)
+		if err2 != nil || ms <= 0 {
+			err = util.ErrCodef(err2, config.ErrorCodeBadRequest,
+				"invalid %v header: %q", HeaderTimeout, v)
+			return
+		}
+		ri.Timeout = time.Duration(ms) * time.Millisecond
+	}
+
+	if v := r.Header.Get(HeaderTraceparent); v != "" {
+		ri.Trace, _ = serialization.ParseTraceparent(v)
+	}
 	return
 }
 
@@ -7,6 +7,7 @@ import (
 	"net/http/httptest"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/pasztorpisti/nano"
 	"github.com/pasztorpisti/nano/addons/transport/http/config"
@@ -309,3 +310,109 @@ func TestRespSerialization_NotFoundUtilError(t *testing.T) {
 	e := util.ErrCode(nil, config.ErrorCodeNotFound, "test error")
 	testErrorResponseSerialization(t, e, 404)
 }
+
+func TestReqSerialization_Metadata(t *testing.T) {
+	metadata := map[string]string{
+		"tenant_id": "tenant1",
+		"Locale":    "en-GB",
+		"flags":     "a=1&b=2",
+	}
+	c := newCtx()
+	c.Metadata = metadata
+	ec := endpointConfigNoContent
+	h, _, err := ClientSideSerializer.ReqSerializer.SerializeRequest(
+		ec, c, &ReqNoContent{})
+	if err != nil {
+		t.Errorf("SerializeRequest failed :: %v", err)
+		t.FailNow()
+	}
+
+	r := httptest.NewRequest(ec.Method, ec.Path, nil)
+	r.Header = h
+	_, ri, err := ServerSideSerializer.ReqDeserializer.DeserializeRequest(ec, r)
+	if err != nil {
+		t.Errorf("DeserializeRequest failed :: %v", err)
+		t.FailNow()
+	}
+	if !reflect.DeepEqual(ri.Metadata, metadata) {
+		t.Errorf("deserialised metadata == %v, want %v", ri.Metadata, metadata)
+	}
+}
+
+func TestReqSerialization_Timeout(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	c := newCtx()
+	c.Context = ctx
+	ec := endpointConfigNoContent
+	h, _, err := ClientSideSerializer.ReqSerializer.SerializeRequest(
+		ec, c, &ReqNoContent{})
+	if err != nil {
+		t.Errorf("SerializeRequest failed :: %v", err)
+		t.FailNow()
+	}
+
+	r := httptest.NewRequest(ec.Method, ec.Path, nil)
+	r.Header = h
+	_, ri, err := ServerSideSerializer.ReqDeserializer.DeserializeRequest(ec, r)
+	if err != nil {
+		t.Errorf("DeserializeRequest failed :: %v", err)
+		t.FailNow()
+	}
+	if ri.Timeout <= 0 || ri.Timeout > time.Minute {
+		t.Errorf("deserialised timeout == %v, want (0, %v]", ri.Timeout, time.Minute)
+	}
+
+	r.Header.Set(HeaderTimeout, "invalid")
+	_, _, err = ServerSideSerializer.ReqDeserializer.DeserializeRequest(ec, r)
+	if code := util.GetErrCode(err); code != config.ErrorCodeBadRequest {
+		t.Errorf("error code == %q, want %q", code, config.ErrorCodeBadRequest)
+	}
+}
+
+func TestReqSerialization_Trace(t *testing.T) {
+	c := newCtx()
+	c.Trace = &nano.SpanContext{
+		TraceID:      "4bf92f3577b34da6a3ce929d0e0e4736",
+		SpanID:       "00f067aa0ba902b7",
+		ParentSpanID: "b7ad6b7169203331",
+		Sampled:      true,
+	}
+	ec := endpointConfigNoContent
+	h, _, err := ClientSideSerializer.ReqSerializer.SerializeRequest(
+		ec, c, &ReqNoContent{})
+	if err != nil {
+		t.Errorf("SerializeRequest failed :: %v", err)
+		t.FailNow()
+	}
+	want := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	if v := h.Get(HeaderTraceparent); v != want {
+		t.Errorf("%v header == %q, want %q", HeaderTraceparent, v, want)
+	}
+
+	r := httptest.NewRequest(ec.Method, ec.Path, nil)
+	r.Header = h
+	_, ri, err := ServerSideSerializer.ReqDeserializer.DeserializeRequest(ec, r)
+	if err != nil {
+		t.Errorf("DeserializeRequest failed :: %v", err)
+		t.FailNow()
+	}
+	wantTrace := nano.SpanContext{
+		TraceID: c.Trace.TraceID,
+		SpanID:  c.Trace.SpanID,
+		Sampled: true,
+	}
+	if ri.Trace == nil || *ri.Trace != wantTrace {
+		t.Errorf("deserialised trace == %+v, want %+v", ri.Trace, wantTrace)
+	}
+
+	// An invalid traceparent doesn't fail the request.
+	r.Header.Set(HeaderTraceparent, "invalid")
+	_, ri, err = ServerSideSerializer.ReqDeserializer.DeserializeRequest(ec, r)
+	if err != nil {
+		t.Errorf("DeserializeRequest failed :: %v", err)
+	}
+	if ri.Trace != nil {
+		t.Errorf("deserialised trace == %+v, want nil", ri.Trace)
+	}
+}
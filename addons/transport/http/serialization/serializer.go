@@ -2,6 +2,7 @@ package serialization
 
 import (
 	"net/http"
+	"time"
 
 	"github.com/pasztorpisti/nano"
 	"github.com/pasztorpisti/nano/addons/transport/http/config"
@@ -15,6 +16,39 @@ type ReqSerializer interface {
 type ReqInfo struct {
 	ReqID      string
 	ClientName string
+	Metadata   map[string]string
+	// Timeout is the remaining time budget of the request sent by the client.
+	// Zero means that the request has no deadline.
+	Timeout time.Duration
+	// Trace is the span of the client that sent the request. Nil if the
+	// client sent no trace context or sent an invalid one.
+	Trace *nano.SpanContext
+}
+
+// Timeout returns the remaining time until the deadline of c.Context.
+// It returns zero if the context has no deadline and a positive value
+// (at least one millisecond) if it has a deadline even if the deadline has
+// already passed. This way a zero timeout always means "no deadline" on the
+// wire.
+func Timeout(c *nano.Ctx) time.Duration {
+	if c == nil || c.Context == nil {
+		return 0
+	}
+	deadline, ok := c.Context.Deadline()
+	if !ok {
+		return 0
+	}
+	timeout := time.Until(deadline)
+	if timeout < time.Millisecond {
+		return time.Millisecond
+	}
+	return timeout
+}
+
+// DurationToMs converts d to milliseconds rounding upwards so a positive
+// Timeout stays positive on the wire.
+func DurationToMs(d time.Duration) int64 {
+	return int64((d + time.Millisecond - 1) / time.Millisecond)
 }
 
 type ReqDeserializer interface {
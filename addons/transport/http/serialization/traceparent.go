@@ -0,0 +1,68 @@
+package serialization
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/pasztorpisti/nano"
+)
+
+// FormatTraceparent returns the W3C traceparent representation of sc.
+// It returns an empty string if sc is nil.
+func FormatTraceparent(sc *nano.SpanContext) string {
+	if sc == nil {
+		return ""
+	}
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return "00-" + sc.TraceID + "-" + sc.SpanID + "-" + flags
+}
+
+// ParseTraceparent parses a W3C traceparent value. The SpanID of the returned
+// SpanContext is the parent-id field of the traceparent: the span of the
+// remote caller. The ParentSpanID of the returned SpanContext is empty.
+func ParseTraceparent(s string) (*nano.SpanContext, error) {
+	parts := strings.Split(s, "-")
+	if len(parts) < 4 {
+		return nil, errors.New("traceparent: invalid format")
+	}
+	version := parts[0]
+	if !isLowerHex(version, 2) || version == "ff" {
+		return nil, errors.New("traceparent: invalid version")
+	}
+	// Version 00 has exactly 4 fields. Future versions may append fields.
+	if version == "00" && len(parts) != 4 {
+		return nil, errors.New("traceparent: invalid format")
+	}
+	traceID, spanID, flags := parts[1], parts[2], parts[3]
+	if !isLowerHex(traceID, 32) || traceID == strings.Repeat("0", 32) {
+		return nil, errors.New("traceparent: invalid trace-id")
+	}
+	if !isLowerHex(spanID, 16) || spanID == strings.Repeat("0", 16) {
+		return nil, errors.New("traceparent: invalid parent-id")
+	}
+	if !isLowerHex(flags, 2) {
+		return nil, errors.New("traceparent: invalid trace-flags")
+	}
+	return &nano.SpanContext{
+		TraceID: traceID,
+		SpanID:  spanID,
+		// The sampled flag is the least significant bit of trace-flags.
+		Sampled: strings.IndexByte("13579bdf", flags[1]) >= 0,
+	}, nil
+}
+
+func isLowerHex(s string, length int) bool {
+	if len(s) != length {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
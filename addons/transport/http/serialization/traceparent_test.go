@@ -0,0 +1,78 @@
+package serialization
+
+import (
+	"testing"
+
+	"github.com/pasztorpisti/nano"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	tests := []struct {
+		s    string
+		want *nano.SpanContext
+	}{
+		{
+			"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+			&nano.SpanContext{
+				TraceID: "4bf92f3577b34da6a3ce929d0e0e4736",
+				SpanID:  "00f067aa0ba902b7",
+				Sampled: true,
+			},
+		},
+		{
+			"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00",
+			&nano.SpanContext{
+				TraceID: "4bf92f3577b34da6a3ce929d0e0e4736",
+				SpanID:  "00f067aa0ba902b7",
+			},
+		},
+		// future versions may have additional fields
+		{
+			"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-03-xyz",
+			&nano.SpanContext{
+				TraceID: "4bf92f3577b34da6a3ce929d0e0e4736",
+				SpanID:  "00f067aa0ba902b7",
+				Sampled: true,
+			},
+		},
+		{"", nil},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7", nil},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-xyz", nil},
+		{"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", nil},
+		{"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", nil},
+		{"00-00000000000000000000000000000000-00f067aa0ba902b7-01", nil},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", nil},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-1", nil},
+	}
+	for _, test := range tests {
+		sc, err := ParseTraceparent(test.s)
+		if test.want == nil {
+			if err == nil {
+				t.Errorf("%q: unexpected success", test.s)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error :: %v", test.s, err)
+			continue
+		}
+		if *sc != *test.want {
+			t.Errorf("%q: got %+v, want %+v", test.s, sc, test.want)
+		}
+	}
+}
+
+func TestFormatTraceparent(t *testing.T) {
+	sc := &nano.SpanContext{
+		TraceID:      "4bf92f3577b34da6a3ce929d0e0e4736",
+		SpanID:       "00f067aa0ba902b7",
+		ParentSpanID: "b7ad6b7169203331",
+	}
+	want := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00"
+	if s := FormatTraceparent(sc); s != want {
+		t.Errorf("FormatTraceparent == %q, want %q", s, want)
+	}
+	if s := FormatTraceparent(nil); s != "" {
+		t.Errorf("FormatTraceparent(nil) == %q, want empty", s)
+	}
+}
@@ -0,0 +1,149 @@
+package http
+
+import (
+	"sync/atomic"
+
+	"github.com/pasztorpisti/nano/addons/discovery"
+)
+
+// ZoneOptions configures zone-aware routing. The zone of an instance is the
+// discovery.MetadataZone item of its metadata. The client sends the requests
+// to the instances in its own zone and spills over to the instances of all
+// zones only if the local instances are unhealthy or overloaded.
+//
+// The health and the load of the instances are tracked by the load balancer
+// of the client. Without a load balancer the client spills over only when its
+// zone has no instances.
+type ZoneOptions struct {
+	// Zone is the zone of the client. Required.
+	Zone string
+	// MinHealthyPercent is the percentage of the local instances that have
+	// to be healthy (not ejected by the load balancer) to keep the requests
+	// in the zone. Defaults to 50.
+	MinHealthyPercent int
+	// MaxOutstanding is the average number of outstanding requests per
+	// healthy local instance at which the requests spill over to the other
+	// zones. Zero means no limit.
+	MaxOutstanding int
+	// Metrics receives the ZoneRequestsMetric counter if it isn't nil,
+	// e.g.: a *metrics.Registry of the metrics addon.
+	Metrics Counters
+}
+
+// Counters is a registry of counters.
+type Counters interface {
+	// IncCounter increments the counter with the given name and labels.
+	// The labels are name and value pairs.
+	IncCounter(name string, labels ...string)
+}
+
+// ZoneRequestsMetric is the counter of the requests sent by the clients with
+// zone-aware routing. Its labels are the service (the target of the client)
+// and the zone: "local" or "cross".
+const ZoneRequestsMetric = "nano_zone_requests_total"
+
+func (o ZoneOptions) withDefaults() ZoneOptions {
+	if o.MinHealthyPercent <= 0 || o.MinHealthyPercent > 100 {
+		o.MinHealthyPercent = 50
+	}
+	return o
+}
+
+// ZoneStats is a snapshot of the request counters of zone-aware routing.
+type ZoneStats struct {
+	// Local is the number of requests sent to the zone of the client.
+	Local uint64
+	// CrossZone is the number of requests sent to other zones.
+	CrossZone uint64
+}
+
+// CrossZoneShare returns the ratio of the requests sent to other zones.
+func (s ZoneStats) CrossZoneShare() float64 {
+	total := s.Local + s.CrossZone
+	if total == 0 {
+		return 0
+	}
+	return float64(s.CrossZone) / float64(total)
+}
+
+// ZoneRoutingClient is implemented by the services returned by NewClient.
+type ZoneRoutingClient interface {
+	// ZoneStats returns the request counters of zone-aware routing. The
+	// counters are zero if zone-aware routing isn't enabled in the
+	// ClientOptions.
+	ZoneStats() ZoneStats
+}
+
+// zoneRouter implements zone-aware routing for a client.
+type zoneRouter struct {
+	// The counters are the first fields to keep them 64-bit aligned for
+	// the atomic operations.
+	local     uint64
+	crossZone uint64
+	service   string
+	opts      ZoneOptions
+}
+
+func newZoneRouter(service string, opts ZoneOptions) *zoneRouter {
+	if opts.Zone == "" {
+		panic("zone of the client is empty")
+	}
+	return &zoneRouter{
+		service: service,
+		opts:    opts.withDefaults(),
+	}
+}
+
+// filter returns the filter of the instances the next request can be sent
+// to. It returns nil if the request can be sent to any instance.
+func (z *zoneRouter) filter(instances []discovery.Instance,
+	b LoadBalancer) func(discovery.Instance) bool {
+	local := accepted(instances, z.isLocal)
+	if len(local) == 0 || len(local) == len(instances) {
+		return nil
+	}
+	if b == nil {
+		return z.isLocal
+	}
+
+	healthy, outstanding := 0, 0
+	for _, inst := range local {
+		n, ejected := b.Load(inst.Addr)
+		if !ejected {
+			healthy++
+			outstanding += n
+		}
+	}
+	if healthy*100 < len(local)*z.opts.MinHealthyPercent {
+		return nil
+	}
+	if z.opts.MaxOutstanding > 0 && outstanding >= healthy*z.opts.MaxOutstanding {
+		return nil
+	}
+	return z.isLocal
+}
+
+func (z *zoneRouter) isLocal(inst discovery.Instance) bool {
+	return inst.Metadata[discovery.MetadataZone] == z.opts.Zone
+}
+
+// count records a request sent to the given instance.
+func (z *zoneRouter) count(inst discovery.Instance) {
+	zone := "local"
+	if z.isLocal(inst) {
+		atomic.AddUint64(&z.local, 1)
+	} else {
+		atomic.AddUint64(&z.crossZone, 1)
+		zone = "cross"
+	}
+	if z.opts.Metrics != nil {
+		z.opts.Metrics.IncCounter(ZoneRequestsMetric, "service", z.service, "zone", zone)
+	}
+}
+
+func (z *zoneRouter) stats() ZoneStats {
+	return ZoneStats{
+		Local:     atomic.LoadUint64(&z.local),
+		CrossZone: atomic.LoadUint64(&z.crossZone),
+	}
+}
@@ -0,0 +1,42 @@
+/*
+Package httpconfig checks the typed.Handlers of a service against its http
+transport config. It is a separate package so the typed addon doesn't depend
+on the http transport.
+*/
+package httpconfig
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/pasztorpisti/nano/addons/transport/http/config"
+	"github.com/pasztorpisti/nano/addons/typed"
+)
+
+// Check checks whether hs has a handler with matching request and response
+// types for every endpoint of the given http transport config.
+// An endpoint without RespType matches handlers with an interface Resp type.
+// Calling Check right after registering the handlers moves the detection of
+// request/response type mismatches to the initialisation of the service.
+func Check(hs *typed.Handlers, cfg *config.ServiceConfig) error {
+	for _, ec := range cfg.Endpoints {
+		reqType := reflect.PtrTo(ec.ReqType)
+		handlerRespType, ok := hs.RespType(reqType)
+		if !ok {
+			return fmt.Errorf("service %v: no handler for request type %v",
+				cfg.ServiceName, reqType)
+		}
+		if ec.RespType == nil {
+			if handlerRespType.Kind() != reflect.Interface {
+				return fmt.Errorf("service %v: handler of %v responds with %v, want no response",
+					cfg.ServiceName, reqType, handlerRespType)
+			}
+			continue
+		}
+		if respType := reflect.PtrTo(ec.RespType); handlerRespType != respType {
+			return fmt.Errorf("service %v: handler of %v responds with %v, want %v",
+				cfg.ServiceName, reqType, handlerRespType, respType)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package httpconfig
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/pasztorpisti/nano"
+	"github.com/pasztorpisti/nano/addons/transport/http/config"
+	"github.com/pasztorpisti/nano/addons/typed"
+)
+
+type Req struct{}
+
+type Resp struct{}
+
+type OtherReq struct{}
+
+type OtherResp struct{}
+
+func TestCheck(t *testing.T) {
+	reqType := reflect.TypeOf((*Req)(nil)).Elem()
+	otherReqType := reflect.TypeOf((*OtherReq)(nil)).Elem()
+
+	newCfg := func(respType, otherRespType reflect.Type) *config.ServiceConfig {
+		return &config.ServiceConfig{
+			ServiceName: "svc",
+			Endpoints: []*config.EndpointConfig{
+				{Method: "POST", Path: "/", ReqType: reqType, RespType: respType},
+				{Method: "GET", Path: "/", ReqType: otherReqType, RespType: otherRespType},
+			},
+		}
+	}
+
+	var hs typed.Handlers
+	typed.Handle(&hs, func(c *nano.Ctx, req *Req) (*Resp, error) {
+		return &Resp{}, nil
+	})
+	typed.Handle(&hs, func(c *nano.Ctx, req *OtherReq) (interface{}, error) {
+		return nil, nil
+	})
+	respType := reflect.TypeOf((*Resp)(nil)).Elem()
+	otherRespType := reflect.TypeOf((*OtherResp)(nil)).Elem()
+
+	if err := Check(&hs, newCfg(respType, nil)); err != nil {
+		t.Errorf("unexpected config error :: %v", err)
+	}
+	if err := Check(&hs, newCfg(otherRespType, nil)); err == nil {
+		t.Error("haven't received the expected response type mismatch error")
+	}
+	if err := Check(&hs, newCfg(respType, otherRespType)); err == nil {
+		t.Error("haven't received the expected response type mismatch error")
+	}
+
+	var empty typed.Handlers
+	if err := Check(&empty, newCfg(respType, nil)); err == nil {
+		t.Error("haven't received the expected missing handler error")
+	}
+}
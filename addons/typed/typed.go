@@ -0,0 +1,127 @@
+/*
+Package typed provides a type-safe layer on top of nano.Client and
+nano.Service using go generics.
+
+Call and Client remove the type assertions from the caller side while
+Handlers builds the Handle method of a service from typed handler functions
+so a service doesn't have to hand-write a type switch.
+*/
+package typed
+
+import (
+	"reflect"
+
+	"github.com/pasztorpisti/nano"
+	"github.com/pasztorpisti/nano/addons/util"
+)
+
+// Call sends req through client and returns the response as a Resp. It returns
+// an error if the service responds with an object that isn't a Resp. A nil
+// response is returned as the zero value of Resp.
+func Call[Req, Resp any](client nano.Client, c *nano.Ctx, req Req) (Resp, error) {
+	var zero Resp
+	resp, err := client.Request(c, req)
+	if err != nil {
+		return zero, err
+	}
+	if resp == nil {
+		return zero, nil
+	}
+	r, ok := resp.(Resp)
+	if !ok {
+		return zero, util.Errf(nil, "response of type %T, want %v",
+			resp, reflect.TypeOf((*Resp)(nil)).Elem())
+	}
+	return r, nil
+}
+
+// Client wraps a nano.Client into a client that can send only Req requests and
+// receives Resp responses.
+type Client[Req, Resp any] struct {
+	client nano.Client
+}
+
+// NewClient creates a new typed Client that sends its requests through client.
+func NewClient[Req, Resp any](client nano.Client) Client[Req, Resp] {
+	return Client[Req, Resp]{client: client}
+}
+
+// Request sends the req request. See Call.
+func (p Client[Req, Resp]) Request(c *nano.Ctx, req Req) (Resp, error) {
+	return Call[Req, Resp](p.client, c, req)
+}
+
+// HandlerFunc is a typed handler function of a request type.
+type HandlerFunc[Req, Resp any] func(c *nano.Ctx, req Req) (Resp, error)
+
+// Handlers dispatches requests to typed handler functions based on the type of
+// the request. Handlers can be embedded into a service object to implement the
+// Handle method of the nano.Service interface. The zero value is ready to use.
+type Handlers struct {
+	handlers map[reflect.Type]*handler
+}
+
+type handler struct {
+	respType reflect.Type
+	handle   func(c *nano.Ctx, req interface{}) (interface{}, error)
+}
+
+// Handle registers h as the handler of the Req request type in hs.
+// It panics if Req is an interface type or if hs already has a handler
+// for Req.
+func Handle[Req, Resp any](hs *Handlers, h HandlerFunc[Req, Resp]) {
+	reqType := reflect.TypeOf((*Req)(nil)).Elem()
+	if reqType.Kind() == reflect.Interface {
+		panic("request type can't be an interface: " + reqType.String())
+	}
+	if hs.handlers == nil {
+		hs.handlers = make(map[reflect.Type]*handler)
+	}
+	if _, ok := hs.handlers[reqType]; ok {
+		panic("multiple handlers have the same req type: " + reqType.String())
+	}
+	hs.handlers[reqType] = &handler{
+		respType: reflect.TypeOf((*Resp)(nil)).Elem(),
+		handle: func(c *nano.Ctx, req interface{}) (interface{}, error) {
+			resp, err := h(c, req.(Req))
+			if err != nil || isNil(resp) {
+				// A nil pointer in the interface{} would be a non-nil
+				// response.
+				return nil, err
+			}
+			return resp, nil
+		},
+	}
+}
+
+// isNil returns true if v is nil or a nil pointer, map, slice, func or chan.
+func isNil(v interface{}) bool {
+	if v == nil {
+		return true
+	}
+	switch rv := reflect.ValueOf(v); rv.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Slice, reflect.Func, reflect.Chan:
+		return rv.IsNil()
+	}
+	return false
+}
+
+// Handle calls the handler registered for the type of req. It returns
+// nano.BadReqTypeError if there is no such handler.
+func (p *Handlers) Handle(c *nano.Ctx, req interface{}) (interface{}, error) {
+	h, ok := p.handlers[reflect.TypeOf(req)]
+	if !ok {
+		return nil, nano.BadReqTypeError
+	}
+	return h.handle(c, req)
+}
+
+// RespType returns the Resp type of the handler registered for reqType.
+// The returned bool is false if hs has no handler for reqType.
+func (p *Handlers) RespType(reqType reflect.Type) (reflect.Type, bool) {
+	h, ok := p.handlers[reqType]
+	if !ok {
+		return nil, false
+	}
+	return h.respType, true
+}
@@ -0,0 +1,117 @@
+package typed
+
+import (
+	"testing"
+
+	"github.com/pasztorpisti/nano"
+	"github.com/pasztorpisti/nano/addons/util"
+)
+
+type Req struct {
+	S string
+}
+
+type Resp struct {
+	S string
+}
+
+type OtherReq struct{}
+
+type OtherResp struct{}
+
+type testSvc struct {
+	Handlers
+}
+
+func (*testSvc) Name() string {
+	return "svc"
+}
+
+func newTestSvc() *testSvc {
+	svc := &testSvc{}
+	Handle(&svc.Handlers, func(c *nano.Ctx, req *Req) (*Resp, error) {
+		return &Resp{S: "resp_" + req.S}, nil
+	})
+	Handle(&svc.Handlers, func(c *nano.Ctx, req *OtherReq) (interface{}, error) {
+		return nil, nil
+	})
+	return svc
+}
+
+func TestCall(t *testing.T) {
+	client := nano.NewTestClientSet(newTestSvc()).LookupClient("svc")
+
+	resp, err := Call[*Req, *Resp](client, nil, &Req{S: "str"})
+	if err != nil {
+		t.Errorf("unexpected error :: %v", err)
+		t.FailNow()
+	}
+	if resp.S != "resp_str" {
+		t.Errorf("resp.S == %q, want %q", resp.S, "resp_str")
+	}
+
+	resp2, err := NewClient[*OtherReq, *OtherResp](client).Request(nil, &OtherReq{})
+	if err != nil {
+		t.Errorf("unexpected error :: %v", err)
+	}
+	if resp2 != nil {
+		t.Errorf("resp2 == %v, want nil", resp2)
+	}
+}
+
+func TestCall_BadRespType(t *testing.T) {
+	client := nano.NewTestClientSet(newTestSvc()).LookupClient("svc")
+	_, err := Call[*Req, *OtherResp](client, nil, &Req{})
+	if err == nil {
+		t.Error("haven't received the expected response type error")
+	}
+}
+
+func TestHandlers_BadReqType(t *testing.T) {
+	client := nano.NewTestClientSet(newTestSvc()).LookupClient("svc")
+	_, err := client.Request(nil, &Resp{})
+	if err != nano.BadReqTypeError {
+		t.Errorf("err == %v, want %v", err, nano.BadReqTypeError)
+	}
+}
+
+func TestHandlers_Error(t *testing.T) {
+	e := util.Err(nil, "handler error")
+	svc := &testSvc{}
+	Handle(&svc.Handlers, func(c *nano.Ctx, req *Req) (*Resp, error) {
+		return nil, e
+	})
+	resp, err := svc.Handle(nil, &Req{})
+	if err != e {
+		t.Errorf("err == %v, want %v", err, e)
+	}
+	if resp != nil {
+		t.Errorf("resp == %#v, want nil", resp)
+	}
+}
+
+func TestHandle_Duplicate(t *testing.T) {
+	svc := newTestSvc()
+	defer func() {
+		if recover() == nil {
+			t.Error("haven't received the expected panic")
+		}
+	}()
+	Handle(&svc.Handlers, func(c *nano.Ctx, req *Req) (*OtherResp, error) {
+		return nil, nil
+	})
+}
+
+func TestHandle_Nil_Pointer_Resp(t *testing.T) {
+	var hs Handlers
+	Handle(&hs, func(c *nano.Ctx, req *Req) (*Resp, error) {
+		return nil, nil
+	})
+	resp, err := hs.Handle(nil, &Req{})
+	if err != nil {
+		t.Fatalf("unexpected error :: %v", err)
+	}
+	if resp != nil {
+		t.Errorf("resp == %#v, want untyped nil", resp)
+	}
+}
@@ -1,6 +1,9 @@
 package util
 
-import "fmt"
+import (
+	"fmt"
+	"time"
+)
 
 type NanoError interface {
 	error
@@ -22,7 +25,7 @@ func ErrCode(cause error, code, msg string) NanoError {
 }
 
 func ErrCodef(cause error, code, format string, a ...interface{}) NanoError {
-	return ErrCode(cause, code, fmt.Sprintf(format, a))
+	return ErrCode(cause, code, fmt.Sprintf(format, a...))
 }
 
 func Err(cause error, msg string) NanoError {
@@ -30,7 +33,7 @@ func Err(cause error, msg string) NanoError {
 }
 
 func Errf(cause error, format string, a ...interface{}) NanoError {
-	return ErrCodef(cause, "", format, a)
+	return ErrCodef(cause, "", format, a...)
 }
 
 func GetErrCode(err error) string {
@@ -57,3 +60,44 @@ func (p *nanoError) Error() string {
 func (p *nanoError) Code() string {
 	return p.code
 }
+
+// RetryHint is an interface that can optionally be implemented by errors to
+// tell the client how long it should wait before retrying the request.
+type RetryHint interface {
+	RetryAfter() time.Duration
+}
+
+// WithRetryAfter returns an error that implements RetryHint and has the same
+// error message and code as err.
+func WithRetryAfter(err error, retryAfter time.Duration) NanoError {
+	return &retryHintError{
+		err:        err,
+		retryAfter: retryAfter,
+	}
+}
+
+// GetRetryAfter returns the retry hint of err. The returned bool is false
+// if err doesn't implement RetryHint.
+func GetRetryAfter(err error) (time.Duration, bool) {
+	if e, ok := err.(RetryHint); ok {
+		return e.RetryAfter(), true
+	}
+	return 0, false
+}
+
+type retryHintError struct {
+	err        error
+	retryAfter time.Duration
+}
+
+func (p *retryHintError) Error() string {
+	return p.err.Error()
+}
+
+func (p *retryHintError) Code() string {
+	return GetErrCode(p.err)
+}
+
+func (p *retryHintError) RetryAfter() time.Duration {
+	return p.retryAfter
+}
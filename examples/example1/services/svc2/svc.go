@@ -2,19 +2,27 @@ package svc2
 
 import (
 	"github.com/pasztorpisti/nano"
+	"github.com/pasztorpisti/nano/addons/typed"
+	"github.com/pasztorpisti/nano/addons/typed/httpconfig"
 	"github.com/pasztorpisti/nano/addons/util"
 	"github.com/pasztorpisti/nano/examples/example1/api_go/svc1"
 	"github.com/pasztorpisti/nano/examples/example1/api_go/svc2"
 	"github.com/pasztorpisti/nano/examples/example1/api_go/svc3"
 )
 
+// New creates the svc2 service. Unlike the other example services svc2 uses
+// the typed addon instead of a hand-written type switch and type assertions.
 func New() nano.Service {
-	return &svc{}
+	p := &svc{}
+	typed.Handle(&p.Handlers, p.handleReq)
+	typed.Handle(&p.Handlers, p.handleGetReq)
+	return p
 }
 
 type svc struct {
-	svc1 nano.Client
-	svc3 nano.Client
+	typed.Handlers
+	svc1 typed.Client[*svc1.Req, *svc1.Resp]
+	svc3 typed.Client[*svc3.Req, *svc3.Resp]
 }
 
 func (*svc) Name() string {
@@ -22,38 +30,27 @@ func (*svc) Name() string {
 }
 
 func (p *svc) Init(cs nano.ClientSet) error {
-	p.svc1 = cs.LookupClient("svc1")
-	p.svc3 = cs.LookupClient("svc3")
-	return nil
+	p.svc1 = typed.NewClient[*svc1.Req, *svc1.Resp](cs.LookupClient("svc1"))
+	p.svc3 = typed.NewClient[*svc3.Req, *svc3.Resp](cs.LookupClient("svc3"))
+	return httpconfig.Check(&p.Handlers, svc2.HTTPTransportConfig)
 }
 
-func (p *svc) Handle(c *nano.Ctx, req interface{}) (interface{}, error) {
-	switch r := req.(type) {
-	case *svc2.Req:
-		return p.handleReq(c, r)
-	case *svc2.GetReq:
-		return p.handleGetReq(c)
-	default:
-		return nil, nano.BadReqTypeError
-	}
-}
-
-func (p *svc) handleReq(c *nano.Ctx, r *svc2.Req) (interface{}, error) {
+func (p *svc) handleReq(c *nano.Ctx, r *svc2.Req) (*svc2.Resp, error) {
 	resp, err := p.svc1.Request(c, &svc1.Req{Param: r.Param})
 	if err != nil {
 		return nil, util.Err(err, "svc1 failure")
 	}
 	return &svc2.Resp{
-		Value: "svc2_" + resp.(*svc1.Resp).Value,
+		Value: "svc2_" + resp.Value,
 	}, nil
 }
 
-func (p *svc) handleGetReq(c *nano.Ctx) (interface{}, error) {
+func (p *svc) handleGetReq(c *nano.Ctx, r *svc2.GetReq) (*svc2.GetResp, error) {
 	resp, err := p.svc3.Request(c, &svc3.Req{Param: "getparam"})
 	if err != nil {
 		return nil, util.Err(err, "svc3 failure")
 	}
 	return &svc2.GetResp{
-		Value: "svc2_" + resp.(*svc3.Resp).Value,
+		Value: "svc2_" + resp.Value,
 	}, nil
 }
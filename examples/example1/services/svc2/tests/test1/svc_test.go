@@ -18,7 +18,7 @@ var svc2Client nano.Client
 func TestMain(m *testing.M) {
 	test.Init()
 
-	cs := nano.NewTestClientSet(
+	cs := test.NewClientSet(
 		svc_svc1.New(),
 		svc_svc2.New(),
 		svc_svc3.New(),
@@ -23,7 +23,7 @@ func TestMain(m *testing.M) {
 	})
 
 	// The svc1 and svc3 mocks demonstrate two different ways of creating mocks.
-	cs := nano.NewTestClientSet(
+	cs := test.NewClientSet(
 		svc1Mock,
 		&svc3Mock{},
 		svc_svc2.New(),
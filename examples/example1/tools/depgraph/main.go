@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/pasztorpisti/nano"
+	"github.com/pasztorpisti/nano/addons/depgraph"
+	svc_svc1 "github.com/pasztorpisti/nano/examples/example1/services/svc1"
+	svc_svc2 "github.com/pasztorpisti/nano/examples/example1/services/svc2"
+	svc_svc3 "github.com/pasztorpisti/nano/examples/example1/services/svc3"
+	svc_svc4 "github.com/pasztorpisti/nano/examples/example1/services/svc4"
+)
+
+var format = flag.String("format", "dot",
+	"The output format. Must be 'dot' or 'json'.")
+
+func main() {
+	flag.Parse()
+
+	ss := nano.NewServiceSet(
+		svc_svc1.New(),
+		svc_svc2.New(),
+		svc_svc3.New(),
+		svc_svc4.New(),
+	)
+	g, err := depgraph.FromServiceSet(ss)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	switch *format {
+	case "dot":
+		err = g.WriteDOT(os.Stdout)
+	case "json":
+		err = json.NewEncoder(os.Stdout).Encode(g)
+	default:
+		err = fmt.Errorf("invalid format: %q", *format)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	if cycles := g.Cycles(); len(cycles) > 0 {
+		fmt.Fprintf(os.Stderr, "dependency cycles: %v\n", cycles)
+		os.Exit(1)
+	}
+}
@@ -7,27 +7,117 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
+	"runtime/debug"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 )
 
 // BadReqTypeError should be returned by the Service.Handle method when it
 // receives a req object of type it can't handle.
 var BadReqTypeError = errors.New("bad request type")
 
+// PanicErrorCode is the error code of PanicError.
+const PanicErrorCode = "S-PANIC"
+
+// PanicError is returned by Client.Request when the called service panics
+// while handling the request and RecoverPanics is true.
+//
+// It implements the NanoError interface of the util addon (it has a Code
+// method) so the transport layer can transfer it as an error with code
+// PanicErrorCode.
+type PanicError struct {
+	// Value is the value passed to panic.
+	Value interface{}
+
+	// Stack is the stack trace of the goroutine that panicked.
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.Value)
+}
+
+// Code returns PanicErrorCode.
+func (e *PanicError) Code() string {
+	return PanicErrorCode
+}
+
+// RecoverPanics controls whether Client.Request recovers the panics of the
+// called service. If true then the panic is turned into a *PanicError that is
+// logged with LogPanic and returned by Client.Request. Tests can set this to
+// false in order to fail fast on panics.
+var RecoverPanics = true
+
+// LogPanic is called with the request context of the service that panicked
+// when Client.Request recovers a panic. The default implementation logs the
+// panic with the ReqID and the stack trace using the standard log package.
+var LogPanic = func(c *Ctx, err *PanicError) {
+	svcName := "-"
+	if c.Svc != nil {
+		svcName = c.Svc.Name()
+	}
+	log.Printf("Panic in service %q req_id=%s :: %v\n%s",
+		svcName, c.ReqID, err.Value, err.Stack)
+}
+
 // RunServer takes a set of initialised services and a list of listeners and
 // initialises the listeners and then listens with them. Blocks and returns only
-// when all listeners returned.
+// when all listeners returned. Before returning it closes ss if it is a
+// ClosableServiceSet.
 //
 // It panics if the Init of a listener returns an error. Terminates the server
 // process if the Listen method of a listener returns with an error.
 var RunServer = func(ss ServiceSet, listeners ...Listener) {
-	runServer(func(l Listener, err error) {
-		log.Println("Listener failure :: " + err.Error())
-		os.Exit(1)
-	}, ss, listeners...)
+	runServer(exitOnListenerError, ss, listeners...)
+}
+
+// RunServerContext is similar to RunServer but it also shuts down the server
+// gracefully when ctx is done. The shutdown calls the Shutdown method of the
+// listeners that implement the ListenerShutdown interface and waits for the
+// Listen method of all listeners to return. The draining of the listeners is
+// limited by ShutdownTimeout. After the listeners have been drained ss is
+// closed if it is a ClosableServiceSet.
+//
+// It returns nil if the listeners and the services have been shut down
+// without errors.
+var RunServerContext = func(ctx context.Context, ss ServiceSet, listeners ...Listener) error {
+	return runServerContext(ctx, exitOnListenerError, ss, listeners...)
+}
+
+// RunServerUntilSignal calls RunServerContext with a context that is done
+// when the server process receives one of the ShutdownSignals.
+var RunServerUntilSignal = func(ss ServiceSet, listeners ...Listener) error {
+	ctx, stop := signal.NotifyContext(context.Background(), ShutdownSignals...)
+	defer stop()
+	return RunServerContext(ctx, ss, listeners...)
+}
+
+// ShutdownSignals is the list of signals on which RunServerUntilSignal starts
+// the graceful shutdown of the server.
+var ShutdownSignals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+
+// ShutdownTimeout is the maximum time the listeners have to drain during a
+// graceful shutdown started by RunServerContext.
+var ShutdownTimeout = 30 * time.Second
+
+func exitOnListenerError(l Listener, err error) {
+	log.Println("Listener failure :: " + err.Error())
+	os.Exit(1)
 }
 
 var runServer = func(onError func(Listener, error), ss ServiceSet, listeners ...Listener) {
+	err := runServerContext(context.Background(), onError, ss, listeners...)
+	if err != nil {
+		log.Println("Shutdown failure :: " + err.Error())
+	}
+}
+
+func runServerContext(ctx context.Context, onError func(Listener, error),
+	ss ServiceSet, listeners ...Listener) error {
 	for _, listener := range listeners {
 		err := listener.Init(ss)
 		if err != nil {
@@ -47,7 +137,64 @@ var runServer = func(onError func(Listener, error), ss ServiceSet, listeners ...
 			}
 		}()
 	}
+
+	listenersReturned := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(listenersReturned)
+	}()
+
+	select {
+	case <-listenersReturned:
+		return closeServiceSet(ss)
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), ShutdownTimeout)
+	defer cancel()
+
+	err := shutdownListeners(shutdownCtx, listeners)
+	select {
+	case <-listenersReturned:
+	case <-shutdownCtx.Done():
+		err = errors.Join(err, fmt.Errorf(
+			"listeners haven't returned before the shutdown deadline :: %v",
+			shutdownCtx.Err()))
+	}
+	return errors.Join(err, closeServiceSet(ss))
+}
+
+// closeServiceSet closes ss if it implements the ClosableServiceSet interface.
+func closeServiceSet(ss ServiceSet) error {
+	if css, ok := ss.(ClosableServiceSet); ok {
+		return css.Close()
+	}
+	return nil
+}
+
+// shutdownListeners calls the Shutdown method of the listeners that implement
+// the ListenerShutdown interface in parallel and returns their joined errors.
+func shutdownListeners(ctx context.Context, listeners []Listener) error {
+	var mu sync.Mutex
+	var errs []error
+	var wg sync.WaitGroup
+	for _, listener := range listeners {
+		ls, ok := listener.(ListenerShutdown)
+		if !ok {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := ls.Shutdown(ctx); err != nil {
+				mu.Lock()
+				defer mu.Unlock()
+				errs = append(errs, fmt.Errorf("error shutting down listener :: %v", err))
+			}
+		}()
+	}
 	wg.Wait()
+	return errors.Join(errs...)
 }
 
 // NewServiceSet creates a new ServiceSet object from the given services.
@@ -56,30 +203,200 @@ var runServer = func(onError func(Listener, error), ss ServiceSet, listeners ...
 // of dependencies between each other by obtaining Client interfaces to each
 // other in their Init methods.
 var NewServiceSet = func(services ...Service) ServiceSet {
-	ss := make(serviceSet, len(services))
+	return NewServiceSetOpts(ServiceSetOpts{
+		Services: services,
+	})
+}
+
+// ServiceSetOpts is used as an incoming parameter for the NewServiceSetOpts
+// function.
+type ServiceSetOpts struct {
+	// Services is the list of services to include into the ServiceSet.
+	Services []Service
+
+	// Middlewares wrap every request sent to any of the services through the
+	// clients obtained from the ServiceSet (including the clients obtained by
+	// the services in their Init methods). The first item is the outermost
+	// middleware of the chain. Can be nil.
+	Middlewares []Middleware
+
+	// ServiceMiddlewares maps service names to middlewares that wrap only the
+	// requests sent to the given service. These are applied inside the chain
+	// of Middlewares. Can be nil.
+	ServiceMiddlewares map[string][]Middleware
+}
+
+// NewServiceSetOpts creates a new ServiceSet object just like NewServiceSet
+// but it allows specifying additional options.
+//
+// It panics if CreateServiceSet returns an error.
+var NewServiceSetOpts = func(opts ServiceSetOpts) ServiceSet {
+	ss, err := CreateServiceSet(opts)
+	if err != nil {
+		panic(err.Error())
+	}
+	return ss
+}
+
+// CreateServiceSet creates a new ServiceSet object just like NewServiceSetOpts
+// but instead of panicking at the first problem it returns a *ServiceSetError
+// that contains all duplicate services, missing dependencies and init errors
+// of all services.
+//
+// Looking up a missing service in ServiceInit.Init doesn't panic during
+// CreateServiceSet: the missing dependency is recorded and the Init receives a
+// Client that fails all requests. If any of the Init methods fail then the
+// InitFinished methods aren't called.
+var CreateServiceSet = func(opts ServiceSetOpts) (ServiceSet, error) {
+	services := opts.Services
+	ss := &serviceSet{
+		services:    make(map[string]Service, len(services)),
+		order:       services,
+		deps:        make(map[string][]string, len(services)),
+		middlewares: make(map[string][]Middleware, len(services)),
+	}
+	ssErr := &ServiceSetError{}
 	for _, svc := range services {
-		ss[svc.Name()] = svc
+		if _, ok := ss.services[svc.Name()]; ok {
+			ssErr.DuplicateServices = append(ssErr.DuplicateServices, svc.Name())
+			continue
+		}
+		ss.services[svc.Name()] = svc
+		mws := make([]Middleware, 0, len(opts.Middlewares)+len(opts.ServiceMiddlewares[svc.Name()]))
+		mws = append(mws, opts.Middlewares...)
+		mws = append(mws, opts.ServiceMiddlewares[svc.Name()]...)
+		ss.middlewares[svc.Name()] = mws
+	}
+	if len(ssErr.DuplicateServices) != 0 {
+		return nil, ssErr
 	}
 
 	for _, svc := range services {
 		if serviceInit, ok := svc.(ServiceInit); ok {
-			err := serviceInit.Init(NewClientSet(ss, svc.Name()))
+			cs := &dependencyRecorder{
+				ClientSet: NewClientSet(ss, svc.Name()),
+				ss:        ss,
+				ownerName: svc.Name(),
+				ssErr:     ssErr,
+			}
+			err := callInit(func() error {
+				return serviceInit.Init(cs)
+			})
 			if err != nil {
-				panic(fmt.Sprintf("error initialising service %q :: %v", svc.Name(), err))
+				ssErr.InitErrors = append(ssErr.InitErrors, &ServiceInitError{
+					ServiceName: svc.Name(),
+					Phase:       InitPhase,
+					Err:         err,
+				})
 			}
 		}
 	}
+	if len(ssErr.MissingDependencies) != 0 || len(ssErr.InitErrors) != 0 {
+		return nil, ssErr
+	}
 
 	for _, svc := range services {
 		if serviceInitFinished, ok := svc.(ServiceInitFinished); ok {
-			err := serviceInitFinished.InitFinished()
+			err := callInit(serviceInitFinished.InitFinished)
 			if err != nil {
-				panic(fmt.Sprintf("InitFinished error in service %q :: %v", svc.Name(), err))
+				ssErr.InitErrors = append(ssErr.InitErrors, &ServiceInitError{
+					ServiceName: svc.Name(),
+					Phase:       InitFinishedPhase,
+					Err:         err,
+				})
 			}
 		}
 	}
+	if len(ssErr.InitErrors) != 0 {
+		return nil, ssErr
+	}
 
-	return ss
+	return ss, nil
+}
+
+// callInit calls f and turns its panic into a *PanicError if RecoverPanics
+// is true.
+func callInit(f func() error) (err error) {
+	if RecoverPanics {
+		defer func() {
+			if v := recover(); v != nil {
+				err = &PanicError{
+					Value: v,
+					Stack: debug.Stack(),
+				}
+			}
+		}()
+	}
+	return f()
+}
+
+// ServiceSetError is returned by CreateServiceSet. It contains all problems
+// found while creating the ServiceSet.
+type ServiceSetError struct {
+	// DuplicateServices contains the names that belong to more than one of
+	// the services. The initialisation of the services doesn't start if there
+	// are duplicate services.
+	DuplicateServices []string
+
+	// MissingDependencies contains the services that have been looked up in
+	// ServiceInit.Init but aren't part of the ServiceSet.
+	MissingDependencies []*MissingDependency
+
+	// InitErrors contains the errors returned by the Init and InitFinished
+	// methods of the services.
+	InitErrors []*ServiceInitError
+}
+
+func (e *ServiceSetError) Error() string {
+	var msgs []string
+	for _, name := range e.DuplicateServices {
+		msgs = append(msgs, fmt.Sprintf("duplicate service %q", name))
+	}
+	for _, md := range e.MissingDependencies {
+		msgs = append(msgs, md.Error())
+	}
+	for _, ie := range e.InitErrors {
+		msgs = append(msgs, ie.Error())
+	}
+	return "error creating service set :: " + strings.Join(msgs, "; ")
+}
+
+// MissingDependency is a dependency of a service that wasn't found in the
+// ServiceSet.
+type MissingDependency struct {
+	ServiceName    string
+	DependencyName string
+}
+
+func (e *MissingDependency) Error() string {
+	return fmt.Sprintf("service %q failed to lookup client %q :: service not found",
+		e.ServiceName, e.DependencyName)
+}
+
+// Phases of the initialisation of a ServiceSet.
+const (
+	InitPhase         = "Init"
+	InitFinishedPhase = "InitFinished"
+)
+
+// ServiceInitError is an error returned by the Init or InitFinished method
+// of a service.
+type ServiceInitError struct {
+	ServiceName string
+	// Phase is InitPhase or InitFinishedPhase.
+	Phase string
+	Err   error
+}
+
+func (e *ServiceInitError) Error() string {
+	if e.Phase == InitFinishedPhase {
+		return fmt.Sprintf("InitFinished error in service %q :: %v", e.ServiceName, e.Err)
+	}
+	return fmt.Sprintf("error initialising service %q :: %v", e.ServiceName, e.Err)
+}
+
+func (e *ServiceInitError) Unwrap() error {
+	return e.Err
 }
 
 // NewTestClientSet is a convenience helper for tests to wrap a set of services
@@ -124,6 +441,9 @@ var NewClient = func(svc Service, ownerName string) Client {
 	return &client{
 		svc:       svc,
 		ownerName: ownerName,
+		handler: func(caller, callee *Ctx, req interface{}) (interface{}, error) {
+			return svc.Handle(callee, req)
+		},
 	}
 }
 
@@ -149,6 +469,46 @@ var NewReqID = func() (string, error) {
 	return fmt.Sprintf("%x", reqIDBytes), nil
 }
 
+// NewSpan creates the SpanContext of a new request. The parent parameter is
+// the Trace of the caller, it is nil if the caller isn't part of a trace.
+// The default implementation creates a new sampled trace if parent is nil,
+// in other case it creates a child span of parent that inherits the TraceID
+// and the Sampled flag of parent.
+//
+// You can replace this function with your own implementation, for example
+// to sample only a fraction of the new traces.
+var NewSpan = func(parent *SpanContext) (*SpanContext, error) {
+	spanID, err := randomHex(8)
+	if err != nil {
+		return nil, fmt.Errorf("error generating span ID :: %v", err)
+	}
+	if parent == nil {
+		traceID, err := randomHex(16)
+		if err != nil {
+			return nil, fmt.Errorf("error generating trace ID :: %v", err)
+		}
+		return &SpanContext{
+			TraceID: traceID,
+			SpanID:  spanID,
+			Sampled: true,
+		}, nil
+	}
+	return &SpanContext{
+		TraceID:      parent.TraceID,
+		SpanID:       spanID,
+		ParentSpanID: parent.SpanID,
+		Sampled:      parent.Sampled,
+	}, nil
+}
+
+func randomHex(numBytes int) (string, error) {
+	b := make([]byte, numBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", b), nil
+}
+
 // NewContext has to return a new context.Context object and a related
 // cancel func for a new request. The returned cancel func is guaranteed to be
 // called exactly once, it doesn't have to handle multiple calls like the cancel
@@ -190,17 +550,192 @@ var NewContext = func(clientContext context.Context) (context.Context, context.C
 	return c, func() { close(cancelChan) }
 }
 
-// serviceSet implements the ServiceSet interface.
-type serviceSet map[string]Service
+// serviceSet implements the ClosableServiceSet, DependencyGraph and
+// HealthChecker interfaces.
+type serviceSet struct {
+	services map[string]Service
+	// order is the list of services in the order they were passed to
+	// NewServiceSet.
+	order []Service
+	// deps maps the name of each service to the names of the services it
+	// has looked up during its initialisation.
+	deps map[string][]string
+	// middlewares maps the name of each service to the middlewares that wrap
+	// the requests sent to the service.
+	middlewares map[string][]Middleware
+
+	closeOnce sync.Once
+	closeErr  error
+	closed    int32
+}
 
-func (p serviceSet) LookupService(svcName string) (Service, error) {
-	svc, ok := p[svcName]
+func (p *serviceSet) LookupService(svcName string) (Service, error) {
+	svc, ok := p.services[svcName]
 	if !ok {
 		return nil, fmt.Errorf("service not found: %v", svcName)
 	}
 	return svc, nil
 }
 
+func (p *serviceSet) Close() error {
+	p.closeOnce.Do(func() {
+		atomic.StoreInt32(&p.closed, 1)
+		var errs []error
+		order := p.dependencyOrder()
+		for i := len(order) - 1; i >= 0; i-- {
+			svc := order[i]
+			if serviceShutdown, ok := svc.(ServiceShutdown); ok {
+				if err := serviceShutdown.Shutdown(); err != nil {
+					errs = append(errs, fmt.Errorf(
+						"error shutting down service %q :: %v", svc.Name(), err))
+				}
+			}
+		}
+		p.closeErr = errors.Join(errs...)
+	})
+	return p.closeErr
+}
+
+func (p *serviceSet) Dependencies() map[string][]string {
+	deps := make(map[string][]string, len(p.order))
+	for _, svc := range p.order {
+		deps[svc.Name()] = append([]string(nil), p.deps[svc.Name()]...)
+	}
+	return deps
+}
+
+func (p *serviceSet) Health(ctx context.Context) *HealthReport {
+	report := &HealthReport{
+		Status:   HealthOK,
+		Services: make(map[string]*ServiceHealthReport, len(p.order)),
+	}
+	closed := atomic.LoadInt32(&p.closed) != 0
+
+	// Each goroutine writes only its own item of reports.
+	reports := make([]*ServiceHealthReport, len(p.order))
+	var wg sync.WaitGroup
+	for i, svc := range p.order {
+		i := i
+		if closed {
+			reports[i] = &ServiceHealthReport{
+				Status: HealthNotReady,
+				Error:  "service set has been closed",
+			}
+			continue
+		}
+		serviceHealth, ok := svc.(ServiceHealth)
+		if !ok {
+			reports[i] = &ServiceHealthReport{Status: HealthOK}
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			status, err := checkHealth(ctx, serviceHealth)
+			reports[i] = &ServiceHealthReport{Status: status}
+			if err != nil {
+				reports[i].Error = err.Error()
+			}
+		}()
+	}
+	wg.Wait()
+	for i, svc := range p.order {
+		report.Services[svc.Name()] = reports[i]
+	}
+
+	for _, sr := range report.Services {
+		switch {
+		case sr.Status == HealthNotReady:
+			report.Status = HealthNotReady
+		case sr.Status != HealthOK && report.Status == HealthOK:
+			report.Status = HealthDegraded
+		}
+	}
+	return report
+}
+
+// checkHealth calls the Health method of a service. It reports a panicking
+// health check as HealthNotReady.
+func checkHealth(ctx context.Context, sh ServiceHealth) (status HealthStatus, err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			status, err = HealthNotReady, &PanicError{
+				Value: v,
+				Stack: debug.Stack(),
+			}
+		}
+	}()
+	return sh.Health(ctx)
+}
+
+// addDependency records that the owner service has looked up svcName.
+func (p *serviceSet) addDependency(ownerName, svcName string) {
+	for _, name := range p.deps[ownerName] {
+		if name == svcName {
+			return
+		}
+	}
+	p.deps[ownerName] = append(p.deps[ownerName], svcName)
+}
+
+// dependencyOrder returns the services in an order in which each service
+// comes after its dependencies. Services that are part of a dependency cycle
+// are ordered arbitrarily relative to each other.
+func (p *serviceSet) dependencyOrder() []Service {
+	order := make([]Service, 0, len(p.order))
+	visited := make(map[string]bool, len(p.order))
+	var visit func(name string)
+	visit = func(name string) {
+		if visited[name] {
+			return
+		}
+		visited[name] = true
+		for _, dep := range p.deps[name] {
+			visit(dep)
+		}
+		if svc, ok := p.services[name]; ok {
+			order = append(order, svc)
+		}
+	}
+	for _, svc := range p.order {
+		visit(svc.Name())
+	}
+	return order
+}
+
+// dependencyRecorder wraps the ClientSet passed to ServiceInit.Init in order
+// to record the dependencies between the services of a serviceSet and the
+// missing dependencies.
+type dependencyRecorder struct {
+	ClientSet
+	ss        *serviceSet
+	ownerName string
+	ssErr     *ServiceSetError
+}
+
+func (p *dependencyRecorder) LookupClient(svcName string) Client {
+	if _, err := p.ss.LookupService(svcName); err != nil {
+		md := &MissingDependency{
+			ServiceName:    p.ownerName,
+			DependencyName: svcName,
+		}
+		p.ssErr.MissingDependencies = append(p.ssErr.MissingDependencies, md)
+		return missingClient{md}
+	}
+	client := p.ClientSet.LookupClient(svcName)
+	p.ss.addDependency(p.ownerName, svcName)
+	return client
+}
+
+// missingClient implements the Client interface for a missing dependency.
+type missingClient struct {
+	err error
+}
+
+func (p missingClient) Request(c *Ctx, req interface{}) (interface{}, error) {
+	return nil, p.err
+}
+
 // clientSet implements the ClientSet interface.
 type clientSet struct {
 	ss        ServiceSet
@@ -213,17 +748,87 @@ func (p *clientSet) LookupClient(svcName string) Client {
 		panic(fmt.Sprintf("service %q failed to lookup client %q :: %v",
 			p.ownerName, svcName, err))
 	}
-	return NewClient(svc, p.ownerName)
+	return wrapClient(NewClient(svc, p.ownerName), svc, p.ownerName,
+		serviceMiddlewares(p.ss, svcName))
+}
+
+// wrapClient wraps the requests sent through c with the given middlewares.
+// The first middleware is the outermost one.
+func wrapClient(c Client, svc Service, ownerName string, middlewares []Middleware) Client {
+	if len(middlewares) == 0 {
+		return c
+	}
+	if cl, ok := c.(*client); ok {
+		wrapped := *cl
+		wrapped.handler = chain(cl.handler, middlewares)
+		return &wrapped
+	}
+	// The Client has been created by a replaced NewClient so the request
+	// context of the callee isn't available. The middlewares receive a copy
+	// of the request context of the caller instead.
+	return &middlewareClient{
+		svc:       svc,
+		ownerName: ownerName,
+		handler: chain(func(caller, callee *Ctx, req interface{}) (interface{}, error) {
+			return c.Request(caller, req)
+		}, middlewares),
+	}
+}
+
+func chain(handler RequestHandler, middlewares []Middleware) RequestHandler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+	return handler
+}
+
+// middlewareClient applies middlewares to a Client that isn't a *client.
+type middlewareClient struct {
+	svc       Service
+	ownerName string
+	handler   RequestHandler
+}
+
+func (p *middlewareClient) Request(c *Ctx, req interface{}) (interface{}, error) {
+	var callee Ctx
+	if c != nil {
+		callee = *c
+	}
+	callee.Svc, callee.ClientName = p.svc, p.ownerName
+	return p.handler(c, &callee, req)
+}
+
+// serviceMiddlewares returns the middlewares that have to wrap the requests
+// sent to the svcName service of ss.
+func serviceMiddlewares(ss ServiceSet, svcName string) []Middleware {
+	if p, ok := ss.(*serviceSet); ok {
+		return p.middlewares[svcName]
+	}
+	return nil
 }
 
 // client implements the Client interface.
 type client struct {
 	svc       Service
 	ownerName string
+	handler   RequestHandler
 }
 
 func (p *client) Request(c *Ctx, req interface{}) (resp interface{}, err error) {
 	var c2 Ctx
+	if RecoverPanics {
+		defer func() {
+			if v := recover(); v != nil {
+				pe := &PanicError{
+					Value: v,
+					Stack: debug.Stack(),
+				}
+				LogPanic(&c2, pe)
+				resp, err = nil, pe
+			}
+		}()
+	}
+
 	if c != nil {
 		c2 = *c
 	}
@@ -234,10 +839,22 @@ func (p *client) Request(c *Ctx, req interface{}) (resp interface{}, err error)
 		}
 	}
 
+	if c2.Trace, err = NewSpan(c2.Trace); err != nil {
+		return nil, err
+	}
+
+	if c2.Metadata != nil {
+		metadata := make(map[string]string, len(c2.Metadata))
+		for k, v := range c2.Metadata {
+			metadata[k] = v
+		}
+		c2.Metadata = metadata
+	}
+
 	var cancel context.CancelFunc
 	c2.Context, cancel = NewContext(c2.Context)
 	defer cancel()
 
 	c2.Svc, c2.ClientName = p.svc, p.ownerName
-	return p.svc.Handle(&c2, req)
+	return p.handler(c, &c2, req)
 }
@@ -14,6 +14,20 @@ type Listener interface {
 	Listen() error
 }
 
+// ListenerShutdown is an interface that can optionally be implemented by a
+// Listener object. If a listener implements this interface then the server
+// can stop it gracefully instead of simply killing the server process.
+type ListenerShutdown interface {
+	// Shutdown is called when the server is shutting down. It should stop
+	// accepting new requests, wait for the in-flight requests to finish and
+	// make the Listen method of the listener return.
+	//
+	// The deadline of the ctx parameter is the time until the listener is
+	// allowed to drain. After the deadline Shutdown should give up waiting
+	// and return ctx.Err().
+	Shutdown(ctx context.Context) error
+}
+
 // ServiceSet is a set of initialised services. During initialisation the
 // services have already resolved the dependencies between each other.
 type ServiceSet interface {
@@ -21,6 +35,36 @@ type ServiceSet interface {
 	LookupService(svcName string) (Service, error)
 }
 
+// ClosableServiceSet is a ServiceSet that can shut down its services.
+// The ServiceSet returned by NewServiceSet implements this interface.
+type ClosableServiceSet interface {
+	ServiceSet
+
+	// Close calls the Shutdown method of the services that implement the
+	// ServiceShutdown interface. The services are shut down in reverse
+	// dependency order: a service is shut down before the services it has
+	// looked up in its ServiceInit.Init method.
+	//
+	// Close calls the Shutdown of all services even if some of them fail
+	// and returns the errors aggregated into one error. Calling Close more
+	// than once has no effect, the subsequent calls return the result of the
+	// first call.
+	Close() error
+}
+
+// DependencyGraph is an interface that can optionally be implemented by a
+// ServiceSet. The ServiceSet returned by NewServiceSet implements it.
+type DependencyGraph interface {
+	// Dependencies returns the dependency graph of the services recorded
+	// while the services were looking up each other in their ServiceInit.Init
+	// methods. The keys of the returned map are the names of all services in
+	// the ServiceSet and the values are the names of the services looked up
+	// by the given service in lookup order.
+	//
+	// The returned map is a copy that can be modified by the caller.
+	Dependencies() map[string][]string
+}
+
 // Service is an interface that has to be implemented by all services.
 type Service interface {
 	// Name returns the name of the service. I recommend using simple names with
@@ -79,6 +123,72 @@ type ServiceInitFinished interface {
 	InitFinished() error
 }
 
+// ServiceShutdown is an interface that can optionally be implemented by a
+// Service object. If a service implements this interface then it receives a
+// Shutdown call when the ServiceSet that contains this service is being closed.
+type ServiceShutdown interface {
+	// Shutdown is the right place to release the resources of the service:
+	// closing DB pools and files, stopping background goroutines, etc...
+	//
+	// When a server shuts down gracefully the listeners are drained before
+	// the ServiceSet is closed so the service doesn't receive requests from
+	// the outside world during Shutdown. The services that depend on this
+	// service have already been shut down when Shutdown is called but the
+	// dependencies of this service are still available.
+	Shutdown() error
+}
+
+// HealthStatus is the health status of a service or a set of services.
+type HealthStatus string
+
+const (
+	// HealthOK means that the service is working normally.
+	HealthOK HealthStatus = "ok"
+
+	// HealthDegraded means that the service can serve requests but with
+	// reduced capacity or functionality (e.g.: a non-critical dependency is
+	// unavailable). A degraded service is still ready to receive requests.
+	HealthDegraded HealthStatus = "degraded"
+
+	// HealthNotReady means that the service can't serve requests at the moment
+	// (e.g.: it is still warming up or it can't reach a critical dependency).
+	HealthNotReady HealthStatus = "not_ready"
+)
+
+// ServiceHealth is an interface that can optionally be implemented by a
+// Service object. Services that don't implement it are considered healthy.
+type ServiceHealth interface {
+	// Health returns the current health status of the service. The returned
+	// error is optional, it can describe the reason of a status other than
+	// HealthOK. The ctx parameter limits the time of the health check.
+	Health(ctx context.Context) (HealthStatus, error)
+}
+
+// HealthChecker is an interface that can optionally be implemented by a
+// ServiceSet. The ServiceSet returned by NewServiceSet implements it.
+type HealthChecker interface {
+	// Health queries the health of all services in the ServiceSet that
+	// implement the ServiceHealth interface and aggregates the results.
+	Health(ctx context.Context) *HealthReport
+}
+
+// HealthReport is the aggregated health of the services of a ServiceSet.
+type HealthReport struct {
+	// Status is the worst status of the services: HealthNotReady if any of
+	// the services is not ready, in other case HealthDegraded if any of the
+	// services is degraded, in other case HealthOK.
+	Status HealthStatus `json:"status"`
+
+	// Services maps service names to their health.
+	Services map[string]*ServiceHealthReport `json:"services"`
+}
+
+// ServiceHealthReport is the health of a service.
+type ServiceHealthReport struct {
+	Status HealthStatus `json:"status"`
+	Error  string       `json:"error,omitempty"`
+}
+
 // ClientSet can be used by a service to obtain client interfaces to other
 // services.
 type ClientSet interface {
@@ -86,7 +196,9 @@ type ClientSet interface {
 	// given svcName. Trying to lookup a service that doesn't exist results in
 	// a panic. This is by design to make the initialisation code simpler.
 	// Normally services lookup their client at server startup in their Init
-	// methods where a panic is acceptable.
+	// methods where a panic is acceptable. The ClientSet received by Init
+	// during CreateServiceSet records the missing service instead of
+	// panicking.
 	LookupClient(svcName string) Client
 }
 
@@ -114,6 +226,21 @@ type Client interface {
 	Request(c *Ctx, req interface{}) (resp interface{}, err error)
 }
 
+// RequestHandler is the function type wrapped by Middleware. It receives both
+// the request context of the caller and the newly created request context of
+// the called service. The caller parameter might be nil (see the c parameter
+// of Client.Request) and it must not be modified.
+type RequestHandler func(caller, callee *Ctx, req interface{}) (resp interface{}, err error)
+
+// Middleware intercepts the requests sent through Client objects. It receives
+// the next handler of the chain and returns a handler that can do something
+// before and/or after calling next. The last handler of the chain calls the
+// Handle method of the called service with the callee request context.
+//
+// Middleware is a good place to implement cross-cutting concerns like logging,
+// metrics, retries and authorization.
+type Middleware func(next RequestHandler) RequestHandler
+
 // Ctx holds context data for a given request being served by a service.
 // If you fork and modify this repo (or roll your own stuff) for a project then
 // one of the benefits is being able to specify the contents of your request
@@ -143,6 +270,35 @@ type Ctx struct {
 	// usually the name of another service but it can be anything else, for
 	// example "test" if the request has been initiated by a test case.
 	ClientName string
+
+	// Metadata is an arbitrary set of key-value pairs that travels with the
+	// request (e.g.: tenant id, user id, locale, feature flags). Just like
+	// ReqID it is copied to the context of the called service when a request
+	// is sent through Client.Request and the transport layer carries it
+	// through the wire. Client.Request copies the map so the called service
+	// can't modify the Metadata of the caller. Might be nil.
+	Metadata map[string]string
+
+	// Trace identifies the current span of the distributed trace the request
+	// belongs to. Client.Request creates a new child span of the Trace of the
+	// caller for each request (or a new trace if the caller has no Trace)
+	// and the transport layer propagates it through the wire so the spans of
+	// a request form a call tree across the services of the cluster.
+	Trace *SpanContext
+}
+
+// SpanContext identifies a span of a distributed trace. The IDs are lowercase
+// hex strings in the format of the W3C Trace Context specification.
+type SpanContext struct {
+	// TraceID is a 32 character hex string shared by all spans of the trace.
+	TraceID string
+	// SpanID is a 16 character hex string.
+	SpanID string
+	// ParentSpanID is the SpanID of the parent span. Empty for the root span
+	// of the trace.
+	ParentSpanID string
+	// Sampled is true if the spans of the trace should be recorded.
+	Sampled bool
 }
 
 // WithContext returns a shallow copy of the context after assigning the given
@@ -155,3 +311,15 @@ func (c *Ctx) WithContext(ctx context.Context) *Ctx {
 	c2.Context = ctx
 	return &c2
 }
+
+// WithMetadata returns a shallow copy of the context after setting the given
+// key-value pair in a copy of the Metadata map.
+func (c *Ctx) WithMetadata(key, value string) *Ctx {
+	c2 := *c
+	c2.Metadata = make(map[string]string, len(c.Metadata)+1)
+	for k, v := range c.Metadata {
+		c2.Metadata[k] = v
+	}
+	c2.Metadata[key] = value
+	return &c2
+}
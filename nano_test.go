@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"reflect"
 	"strings"
 	"sync"
 	"testing"
@@ -38,10 +39,18 @@ func (f testSvcInitFinished) InitFinished() error {
 	return f()
 }
 
-// testListener implements the Listener interface.
+// testSvcShutdown implements the ServiceShutdown interface.
+type testSvcShutdown func() error
+
+func (f testSvcShutdown) Shutdown() error {
+	return f()
+}
+
+// testListener implements the Listener and ListenerShutdown interfaces.
 type testListener struct {
-	init   func(ServiceSet) error
-	listen func() error
+	init     func(ServiceSet) error
+	listen   func() error
+	shutdown func(context.Context) error
 }
 
 func (p *testListener) Init(ss ServiceSet) error {
@@ -58,6 +67,13 @@ func (p *testListener) Listen() error {
 	return p.listen()
 }
 
+func (p *testListener) Shutdown(ctx context.Context) error {
+	if p.shutdown == nil {
+		return nil
+	}
+	return p.shutdown(ctx)
+}
+
 // eventSetEquals check if the events and want arrays contain the same set of
 // strings ignoring the ordering.
 func eventSetEquals(t *testing.T, events []string, want ...string) bool {
@@ -641,3 +657,671 @@ func TestRunServer_Listen_Error(t *testing.T) {
 		t.Error("onError wasn't called")
 	}
 }
+
+func TestRunServerContext_Shutdown(t *testing.T) {
+	onError := func(l Listener, err error) {
+		t.Errorf("listener %p failed :: %v", l, err)
+	}
+	ss := NewServiceSet(&testSvc{
+		name: "svc1",
+	})
+
+	stop := make(chan struct{})
+	shutdownCalled := false
+	listener := &testListener{
+		listen: func() error {
+			<-stop
+			return nil
+		},
+		shutdown: func(ctx context.Context) error {
+			if _, ok := ctx.Deadline(); !ok {
+				t.Error("shutdown context doesn't have a deadline")
+			}
+			shutdownCalled = true
+			close(stop)
+			return nil
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := runServerContext(ctx, onError, ss, listener)
+	if err != nil {
+		t.Errorf("unexpected shutdown error :: %v", err)
+	}
+	if !shutdownCalled {
+		t.Error("listener shutdown wasn't called")
+	}
+}
+
+func TestRunServerContext_Shutdown_Error(t *testing.T) {
+	onError := func(l Listener, err error) {
+		t.Errorf("listener %p failed :: %v", l, err)
+	}
+	ss := NewServiceSet(&testSvc{
+		name: "svc1",
+	})
+
+	e := errors.New("shutdown error")
+	stop := make(chan struct{})
+	listener := &testListener{
+		listen: func() error {
+			<-stop
+			return nil
+		},
+		shutdown: func(ctx context.Context) error {
+			close(stop)
+			return e
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := runServerContext(ctx, onError, ss, listener)
+	if err == nil || !strings.Contains(err.Error(), e.Error()) {
+		t.Errorf("err == %v, want an error containing %q", err, e.Error())
+	}
+}
+
+func TestRunServerContext_Shutdown_Timeout(t *testing.T) {
+	origShutdownTimeout := ShutdownTimeout
+	defer func() {
+		ShutdownTimeout = origShutdownTimeout
+	}()
+	ShutdownTimeout = time.Millisecond * 10
+
+	onError := func(l Listener, err error) {
+		t.Errorf("listener %p failed :: %v", l, err)
+	}
+	ss := NewServiceSet(&testSvc{
+		name: "svc1",
+	})
+
+	stop := make(chan struct{})
+	defer close(stop)
+	// The embedded interface hides the Shutdown method of testListener.
+	listener := struct{ Listener }{&testListener{
+		listen: func() error {
+			<-stop
+			return nil
+		},
+	}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := runServerContext(ctx, onError, ss, listener)
+	if err == nil {
+		t.Error("haven't received the expected shutdown timeout error")
+	}
+}
+
+// newShutdownTestSvc creates a service that looks up the deps services in its
+// Init and records its Shutdown call into events.
+func newShutdownTestSvc(name string, events *[]string, shutdownErr error,
+	deps ...string) Service {
+	return &struct {
+		testSvc
+		testSvcInit
+		testSvcShutdown
+	}{
+		testSvc: testSvc{
+			name: name,
+		},
+		testSvcInit: func(cs ClientSet) error {
+			for _, dep := range deps {
+				cs.LookupClient(dep)
+			}
+			return nil
+		},
+		testSvcShutdown: func() error {
+			*events = append(*events, "Shutdown:"+name)
+			return shutdownErr
+		},
+	}
+}
+
+func TestServiceSet_Close(t *testing.T) {
+	var events []string
+	svc1 := newShutdownTestSvc("svc1", &events, nil, "svc2", "svc3")
+	svc2 := newShutdownTestSvc("svc2", &events, nil, "svc3")
+	svc3 := newShutdownTestSvc("svc3", &events, nil)
+	svc4 := &testSvc{name: "svc4"}
+
+	ss := NewServiceSet(svc3, svc4, svc2, svc1)
+	err := ss.(ClosableServiceSet).Close()
+	if err != nil {
+		t.Errorf("unexpected close error :: %v", err)
+	}
+
+	want := []string{"Shutdown:svc1", "Shutdown:svc2", "Shutdown:svc3"}
+	if !reflect.DeepEqual(events, want) {
+		t.Errorf("events == %v, want %v", events, want)
+	}
+
+	err = ss.(ClosableServiceSet).Close()
+	if err != nil {
+		t.Errorf("unexpected error from the second close :: %v", err)
+	}
+	if len(events) != len(want) {
+		t.Errorf("the second close shut down services again - events: %v", events)
+	}
+}
+
+func TestServiceSet_Close_Errors(t *testing.T) {
+	var events []string
+	e1 := errors.New("svc1 shutdown error")
+	e2 := errors.New("svc2 shutdown error")
+	svc1 := newShutdownTestSvc("svc1", &events, e1, "svc2")
+	svc2 := newShutdownTestSvc("svc2", &events, e2)
+
+	ss := NewServiceSet(svc1, svc2)
+	err := ss.(ClosableServiceSet).Close()
+	if err == nil {
+		t.Error("haven't received the expected close error")
+		t.FailNow()
+	}
+	for _, e := range []error{e1, e2} {
+		if !strings.Contains(err.Error(), e.Error()) {
+			t.Errorf("close error %q doesn't contain %q", err, e)
+		}
+	}
+	if v, want := len(events), 2; v != want {
+		t.Errorf("number of events is %v, want %v - events: %v", v, want, events)
+	}
+}
+
+func TestRunServer_Closes_ServiceSet(t *testing.T) {
+	var events []string
+	onError := func(l Listener, err error) {
+		t.Errorf("listener %p failed :: %v", l, err)
+	}
+	ss := NewServiceSet(newShutdownTestSvc("svc1", &events, nil))
+
+	runServer(onError, ss, &testListener{})
+
+	if want := []string{"Shutdown:svc1"}; !reflect.DeepEqual(events, want) {
+		t.Errorf("events == %v, want %v", events, want)
+	}
+}
+
+func TestNewServiceSetOpts_Middlewares(t *testing.T) {
+	var events []string
+	newMiddleware := func(name string) Middleware {
+		return func(next RequestHandler) RequestHandler {
+			return func(caller, callee *Ctx, req interface{}) (interface{}, error) {
+				events = append(events, name+":"+caller.ClientName+"->"+callee.Svc.Name())
+				resp, err := next(caller, callee, req)
+				events = append(events, name+":"+fmt.Sprint(resp))
+				return resp, err
+			}
+		}
+	}
+
+	svc1 := &testSvc{
+		name: "svc1",
+		handler: func(c *Ctx, req interface{}) (interface{}, error) {
+			events = append(events, "Handler:"+c.Svc.Name())
+			return "resp1", nil
+		},
+	}
+	svc2 := &testSvc{
+		name: "svc2",
+		handler: func(c *Ctx, req interface{}) (interface{}, error) {
+			events = append(events, "Handler:"+c.Svc.Name())
+			return "resp2", nil
+		},
+	}
+
+	ss := NewServiceSetOpts(ServiceSetOpts{
+		Services:    []Service{svc1, svc2},
+		Middlewares: []Middleware{newMiddleware("global1"), newMiddleware("global2")},
+		ServiceMiddlewares: map[string][]Middleware{
+			"svc1": {newMiddleware("svc1")},
+		},
+	})
+	cs := NewClientSet(ss, "test")
+
+	caller := &Ctx{ClientName: "caller"}
+	_, _ = cs.LookupClient("svc1").Request(caller, nil)
+	want := []string{
+		"global1:caller->svc1",
+		"global2:caller->svc1",
+		"svc1:caller->svc1",
+		"Handler:svc1",
+		"svc1:resp1",
+		"global2:resp1",
+		"global1:resp1",
+	}
+	if !reflect.DeepEqual(events, want) {
+		t.Errorf("events == %v, want %v", events, want)
+	}
+
+	events = nil
+	_, _ = cs.LookupClient("svc2").Request(caller, nil)
+	want = []string{
+		"global1:caller->svc2",
+		"global2:caller->svc2",
+		"Handler:svc2",
+		"global2:resp2",
+		"global1:resp2",
+	}
+	if !reflect.DeepEqual(events, want) {
+		t.Errorf("events == %v, want %v", events, want)
+	}
+}
+
+func TestNewServiceSetOpts_Middlewares_Replaced_NewClient(t *testing.T) {
+	origNewClient := NewClient
+	defer func() {
+		NewClient = origNewClient
+	}()
+	type wrappedClient struct{ Client }
+	NewClient = func(svc Service, ownerName string) Client {
+		return wrappedClient{origNewClient(svc, ownerName)}
+	}
+
+	var events []string
+	svc1 := &testSvc{
+		name: "svc1",
+		handler: func(c *Ctx, req interface{}) (interface{}, error) {
+			events = append(events, "Handler:"+c.Svc.Name())
+			return "resp1", nil
+		},
+	}
+	mw := func(next RequestHandler) RequestHandler {
+		return func(caller, callee *Ctx, req interface{}) (interface{}, error) {
+			events = append(events, "mw:"+callee.ClientName+"->"+callee.Svc.Name())
+			return next(caller, callee, req)
+		}
+	}
+	ss := NewServiceSetOpts(ServiceSetOpts{
+		Services:    []Service{svc1},
+		Middlewares: []Middleware{mw},
+	})
+
+	resp, err := NewClientSet(ss, "test").LookupClient("svc1").Request(nil, nil)
+	if err != nil || resp != "resp1" {
+		t.Errorf("Request() == %v, %v, want resp1", resp, err)
+	}
+	if want := []string{"mw:test->svc1", "Handler:svc1"}; !reflect.DeepEqual(events, want) {
+		t.Errorf("events == %v, want %v", events, want)
+	}
+}
+
+func TestServiceSet_Dependencies(t *testing.T) {
+	var events []string
+	svc1 := newShutdownTestSvc("svc1", &events, nil, "svc2", "svc3", "svc2")
+	svc2 := newShutdownTestSvc("svc2", &events, nil, "svc3")
+	svc3 := &testSvc{name: "svc3"}
+
+	ss := NewServiceSet(svc1, svc2, svc3)
+	deps := ss.(DependencyGraph).Dependencies()
+
+	want := map[string][]string{
+		"svc1": {"svc2", "svc3"},
+		"svc2": {"svc3"},
+		"svc3": nil,
+	}
+	if !reflect.DeepEqual(deps, want) {
+		t.Errorf("deps == %v, want %v", deps, want)
+	}
+}
+
+func TestClient_Request_Copies_Metadata(t *testing.T) {
+	var ctx *Ctx
+	svc1 := &testSvc{
+		name: "svc1",
+		handler: func(c *Ctx, req interface{}) (interface{}, error) {
+			ctx = c
+			c.Metadata["tenant_id"] = "modified"
+			return nil, nil
+		},
+	}
+
+	client := NewTestClientSet(svc1).LookupClient("svc1")
+	inputCtx := (&Ctx{}).WithMetadata("tenant_id", "tenant1").WithMetadata("locale", "en")
+	_, _ = client.Request(inputCtx, nil)
+
+	if ctx == nil {
+		t.Error("ctx is nil")
+		t.FailNow()
+	}
+	if v := ctx.Metadata["locale"]; v != "en" {
+		t.Errorf("ctx.Metadata[locale] == %q, want %q", v, "en")
+	}
+	if v := inputCtx.Metadata["tenant_id"]; v != "tenant1" {
+		t.Errorf("the called service modified the caller metadata: %q", v)
+	}
+}
+
+func TestClient_Request_Panic(t *testing.T) {
+	origLogPanic := LogPanic
+	defer func() {
+		LogPanic = origLogPanic
+	}()
+	var loggedReqID string
+	LogPanic = func(c *Ctx, err *PanicError) {
+		loggedReqID = c.ReqID
+	}
+
+	svc1 := &testSvc{
+		name: "svc1",
+		handler: func(c *Ctx, req interface{}) (interface{}, error) {
+			panic("test panic")
+		},
+	}
+	client := NewTestClientSet(svc1).LookupClient("svc1")
+	resp, err := client.Request(&Ctx{ReqID: "MyReqID"}, nil)
+
+	if resp != nil {
+		t.Errorf("resp == %v, want nil", resp)
+	}
+	pe, ok := err.(*PanicError)
+	if !ok {
+		t.Errorf("err == %#v, want a *PanicError", err)
+		t.FailNow()
+	}
+	if pe.Value != "test panic" {
+		t.Errorf("pe.Value == %v, want %q", pe.Value, "test panic")
+	}
+	if pe.Code() != PanicErrorCode {
+		t.Errorf("pe.Code() == %q, want %q", pe.Code(), PanicErrorCode)
+	}
+	if len(pe.Stack) == 0 {
+		t.Error("panic error without stack trace")
+	}
+	if loggedReqID != "MyReqID" {
+		t.Errorf("logged req id == %q, want %q", loggedReqID, "MyReqID")
+	}
+}
+
+func TestClient_Request_Panic_Without_Recovery(t *testing.T) {
+	origRecoverPanics := RecoverPanics
+	defer func() {
+		RecoverPanics = origRecoverPanics
+	}()
+	RecoverPanics = false
+
+	svc1 := &testSvc{
+		name: "svc1",
+		handler: func(c *Ctx, req interface{}) (interface{}, error) {
+			panic("test panic")
+		},
+	}
+	client := NewTestClientSet(svc1).LookupClient("svc1")
+
+	defer func() {
+		if v := recover(); v != "test panic" {
+			t.Errorf("recovered %v, want %q", v, "test panic")
+		}
+	}()
+	_, _ = client.Request(nil, nil)
+}
+
+func TestCreateServiceSet_Errors(t *testing.T) {
+	initErr := errors.New("init error")
+	initFinishedCalled := false
+
+	svc1 := &struct {
+		testSvc
+		testSvcInit
+		testSvcInitFinished
+	}{
+		testSvc: testSvc{name: "svc1"},
+		testSvcInit: func(cs ClientSet) error {
+			cs.LookupClient("missing1")
+			cs.LookupClient("svc2")
+			return nil
+		},
+		testSvcInitFinished: func() error {
+			initFinishedCalled = true
+			return nil
+		},
+	}
+	svc2 := &struct {
+		testSvc
+		testSvcInit
+	}{
+		testSvc: testSvc{name: "svc2"},
+		testSvcInit: func(cs ClientSet) error {
+			client := cs.LookupClient("missing2")
+			if _, err := client.Request(nil, nil); err == nil {
+				t.Error("the client of a missing service succeeded")
+			}
+			return initErr
+		},
+	}
+
+	ss, err := CreateServiceSet(ServiceSetOpts{
+		Services: []Service{svc1, svc2},
+	})
+	if ss != nil {
+		t.Error("unexpected ServiceSet")
+	}
+	ssErr, ok := err.(*ServiceSetError)
+	if !ok {
+		t.Errorf("err == %#v, want a *ServiceSetError", err)
+		t.FailNow()
+	}
+
+	wantMissing := []*MissingDependency{
+		{ServiceName: "svc1", DependencyName: "missing1"},
+		{ServiceName: "svc2", DependencyName: "missing2"},
+	}
+	if !reflect.DeepEqual(ssErr.MissingDependencies, wantMissing) {
+		t.Errorf("missing dependencies == %v, want %v", ssErr.MissingDependencies, wantMissing)
+	}
+	if len(ssErr.InitErrors) != 1 {
+		t.Errorf("init errors == %v, want 1 error", ssErr.InitErrors)
+	} else if ie := ssErr.InitErrors[0]; ie.ServiceName != "svc2" || ie.Phase != InitPhase || ie.Err != initErr {
+		t.Errorf("unexpected init error: %#v", ie)
+	}
+	if initFinishedCalled {
+		t.Error("InitFinished has been called after Init errors")
+	}
+	for _, s := range []string{"missing1", "missing2", initErr.Error()} {
+		if !strings.Contains(err.Error(), s) {
+			t.Errorf("error message %q doesn't contain %q", err, s)
+		}
+	}
+}
+
+func TestCreateServiceSet_DuplicateServices(t *testing.T) {
+	_, err := CreateServiceSet(ServiceSetOpts{
+		Services: []Service{
+			&testSvc{name: "svc1"},
+			&testSvc{name: "svc2"},
+			&testSvc{name: "svc1"},
+		},
+	})
+	ssErr, ok := err.(*ServiceSetError)
+	if !ok {
+		t.Errorf("err == %#v, want a *ServiceSetError", err)
+		t.FailNow()
+	}
+	if want := []string{"svc1"}; !reflect.DeepEqual(ssErr.DuplicateServices, want) {
+		t.Errorf("duplicate services == %v, want %v", ssErr.DuplicateServices, want)
+	}
+}
+
+func TestCreateServiceSet_InitFinished_Errors(t *testing.T) {
+	e := errors.New("InitFinished error")
+	newSvc := func(name string) Service {
+		return &struct {
+			testSvc
+			testSvcInitFinished
+		}{
+			testSvc: testSvc{name: name},
+			testSvcInitFinished: func() error {
+				return e
+			},
+		}
+	}
+
+	_, err := CreateServiceSet(ServiceSetOpts{
+		Services: []Service{newSvc("svc1"), newSvc("svc2")},
+	})
+	ssErr, ok := err.(*ServiceSetError)
+	if !ok {
+		t.Errorf("err == %#v, want a *ServiceSetError", err)
+		t.FailNow()
+	}
+	if len(ssErr.InitErrors) != 2 {
+		t.Errorf("init errors == %v, want 2 errors", ssErr.InitErrors)
+	}
+	for _, ie := range ssErr.InitErrors {
+		if ie.Phase != InitFinishedPhase || !errors.Is(ie, e) {
+			t.Errorf("unexpected init error: %#v", ie)
+		}
+	}
+}
+
+func TestNewServiceSet_Panics_On_Missing_Dependency(t *testing.T) {
+	svc1 := &struct {
+		testSvc
+		testSvcInit
+	}{
+		testSvc: testSvc{name: "svc1"},
+		testSvcInit: func(cs ClientSet) error {
+			cs.LookupClient("missing")
+			return nil
+		},
+	}
+
+	defer func() {
+		v := recover()
+		if v == nil {
+			t.Error("haven't received the expected panic")
+		} else if s := fmt.Sprint(v); !strings.Contains(s, "missing") {
+			t.Errorf("panic message %q doesn't contain the missing service", s)
+		}
+	}()
+	NewServiceSet(svc1)
+}
+
+type testSvcHealth func(ctx context.Context) (HealthStatus, error)
+
+func (f testSvcHealth) Health(ctx context.Context) (HealthStatus, error) {
+	return f(ctx)
+}
+
+func newHealthTestSvc(name string, status HealthStatus, err error) Service {
+	return &struct {
+		testSvc
+		testSvcHealth
+	}{
+		testSvc: testSvc{
+			name: name,
+		},
+		testSvcHealth: func(ctx context.Context) (HealthStatus, error) {
+			return status, err
+		},
+	}
+}
+
+func TestServiceSet_Health(t *testing.T) {
+	ss := NewServiceSet(
+		newHealthTestSvc("ok", HealthOK, nil),
+		newHealthTestSvc("degraded", HealthDegraded, errors.New("slow")),
+		&testSvc{name: "no_health"},
+	)
+	report := ss.(HealthChecker).Health(context.Background())
+	if report.Status != HealthDegraded {
+		t.Errorf("status == %q, want %q", report.Status, HealthDegraded)
+	}
+	want := map[string]ServiceHealthReport{
+		"ok":        {Status: HealthOK},
+		"degraded":  {Status: HealthDegraded, Error: "slow"},
+		"no_health": {Status: HealthOK},
+	}
+	if len(report.Services) != len(want) {
+		t.Errorf("got %v service reports, want %v", len(report.Services), len(want))
+	}
+	for name, w := range want {
+		sr, ok := report.Services[name]
+		if !ok {
+			t.Errorf("missing report of service %q", name)
+			continue
+		}
+		if *sr != w {
+			t.Errorf("service %q: report == %+v, want %+v", name, *sr, w)
+		}
+	}
+}
+
+func TestServiceSet_Health_NotReady(t *testing.T) {
+	ss := NewServiceSet(
+		newHealthTestSvc("not_ready", HealthNotReady, nil),
+		newHealthTestSvc("degraded", HealthDegraded, nil),
+	)
+	report := ss.(HealthChecker).Health(context.Background())
+	if report.Status != HealthNotReady {
+		t.Errorf("status == %q, want %q", report.Status, HealthNotReady)
+	}
+}
+
+func TestServiceSet_Health_Panic(t *testing.T) {
+	svc := &struct {
+		testSvc
+		testSvcHealth
+	}{
+		testSvc: testSvc{name: "svc"},
+		testSvcHealth: func(ctx context.Context) (HealthStatus, error) {
+			panic("health panic")
+		},
+	}
+	report := NewServiceSet(svc).(HealthChecker).Health(context.Background())
+	if report.Status != HealthNotReady {
+		t.Errorf("status == %q, want %q", report.Status, HealthNotReady)
+	}
+	if sr := report.Services["svc"]; sr == nil || sr.Error == "" {
+		t.Errorf("service report == %+v, want an error", sr)
+	}
+}
+
+func TestServiceSet_Health_After_Close(t *testing.T) {
+	ss := NewServiceSet(newHealthTestSvc("svc", HealthOK, nil))
+	err := ss.(ClosableServiceSet).Close()
+	if err != nil {
+		t.Errorf("unexpected close error :: %v", err)
+	}
+	report := ss.(HealthChecker).Health(context.Background())
+	if report.Status != HealthNotReady {
+		t.Errorf("status == %q, want %q", report.Status, HealthNotReady)
+	}
+}
+
+func TestClient_Request_Trace(t *testing.T) {
+	var callee *Ctx
+	svc := &testSvc{
+		name: "svc",
+		handler: func(c *Ctx, req interface{}) (interface{}, error) {
+			callee = c
+			return nil, nil
+		},
+	}
+	client := NewTestClientSet(svc).LookupClient("svc")
+
+	_, err := client.Request(nil, nil)
+	if err != nil {
+		t.Errorf("unexpected error :: %v", err)
+		t.FailNow()
+	}
+	root := callee.Trace
+	if root == nil || len(root.TraceID) != 32 || len(root.SpanID) != 16 ||
+		root.ParentSpanID != "" || !root.Sampled {
+		t.Errorf("invalid root span: %+v", root)
+		t.FailNow()
+	}
+
+	_, err = client.Request(callee, nil)
+	if err != nil {
+		t.Errorf("unexpected error :: %v", err)
+		t.FailNow()
+	}
+	child := callee.Trace
+	if child.TraceID != root.TraceID || child.ParentSpanID != root.SpanID ||
+		child.SpanID == root.SpanID || !child.Sampled {
+		t.Errorf("invalid child span: %+v, parent: %+v", child, root)
+	}
+}